@@ -0,0 +1,467 @@
+package cryptdecrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Public-key encryption in the age v1 file format (https://age-encryption.org/v1),
+// so files can be exchanged with age and rage. Only X25519 recipients are
+// supported. A random file key is wrapped for every recipient in the header,
+// the payload is encrypted with the STREAM code of stream.go:
+//
+//	age-encryption.org/v1
+//	-> X25519 <ephemeral share>
+//	<wrapped file key>
+//	--- <header MAC>
+//	<16 byte nonce><ChaCha20-Poly1305 chunks of 64 KiB>
+const (
+	ageIntro        = "age-encryption.org/v1\n"
+	ageArmorHeader  = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorFooter  = "-----END AGE ENCRYPTED FILE-----"
+	ageRecipientHRP = "age"
+	ageIdentityHRP  = "AGE-SECRET-KEY-"
+	ageX25519Label  = "age-encryption.org/v1/X25519"
+	ageFileKeySize  = 16
+	ageNonceSize    = 16
+	ageChunkSize    = 64 * 1024
+	ageColumns      = 64
+)
+
+var ageBase64 = base64.RawStdEncoding.Strict()
+
+// Identity is an X25519 private key in age format
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// Recipient is an X25519 public key in age format
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// GenerateIdentity creates a new random X25519 identity
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// String returns the identity as AGE-SECRET-KEY-1...
+func (id *Identity) String() string {
+	s, _ := bech32Encode(ageIdentityHRP, id.key.Bytes())
+	return strings.ToUpper(s)
+}
+
+// Recipient returns the public key of the identity
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{key: id.key.PublicKey()}
+}
+
+// String returns the public key as age1...
+func (r *Recipient) String() string {
+	s, _ := bech32Encode(ageRecipientHRP, r.key.Bytes())
+	return s
+}
+
+// ParseIdentity parses an AGE-SECRET-KEY-1... string
+func ParseIdentity(s string) (*Identity, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity: %v", err)
+	}
+	if hrp != strings.ToLower(ageIdentityHRP) {
+		return nil, fmt.Errorf("malformed identity: unexpected type %q", hrp)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity: %v", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// ParseRecipient parses an age1... public key
+func ParseRecipient(s string) (*Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %v", s, err)
+	}
+	if hrp != ageRecipientHRP {
+		return nil, fmt.Errorf("malformed recipient %q: unexpected type %q", s, hrp)
+	}
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %v", s, err)
+	}
+	return &Recipient{key: key}, nil
+}
+
+// keyLines returns the non-empty lines of a key file without comments
+func keyLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// ParseIdentities reads an identity file as written by WriteIdentity or
+// age-keygen
+func ParseIdentities(r io.Reader) ([]*Identity, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var identities []*Identity
+	for _, line := range lines {
+		id, err := ParseIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no identities found")
+	}
+	return identities, nil
+}
+
+// ParseRecipients reads a file with one age1... recipient per line
+func ParseRecipients(r io.Reader) ([]*Recipient, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var recipients []*Recipient
+	for _, line := range lines {
+		recipient, err := ParseRecipient(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// WriteIdentity writes a new identity in the format of age-keygen
+func WriteIdentity(w io.Writer, id *Identity) error {
+	_, err := fmt.Fprintf(w, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), id.Recipient(), id)
+	return err
+}
+
+// ageStanza is one recipient entry of the header
+type ageStanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// marshal writes the stanza in its text form. The body is wrapped at 64
+// columns and always ends with a line shorter than that, possibly empty.
+func (s *ageStanza) marshal(w *bytes.Buffer) {
+	w.WriteString("-> " + s.Type)
+	for _, arg := range s.Args {
+		w.WriteString(" " + arg)
+	}
+	w.WriteByte('\n')
+	body := ageBase64.EncodeToString(s.Body)
+	for len(body) >= ageColumns {
+		w.WriteString(body[:ageColumns] + "\n")
+		body = body[ageColumns:]
+	}
+	w.WriteString(body + "\n")
+}
+
+// hkdfKey derives a key of size bytes with HKDF-SHA256
+func hkdfKey(secret, salt []byte, info string, size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// wrapFileKey creates the X25519 stanza for one recipient
+func wrapFileKey(fileKey []byte, recipient *Recipient) (*ageStanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient.key)
+	if err != nil {
+		return nil, err
+	}
+	share := ephemeral.PublicKey().Bytes()
+	wrapKey, err := hkdfKey(shared, append(share, recipient.key.Bytes()...), ageX25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ageStanza{
+		Type: "X25519",
+		Args: []string{ageBase64.EncodeToString(share)},
+		Body: aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil),
+	}, nil
+}
+
+// unwrapFileKey tries to recover the file key from an X25519 stanza
+func unwrapFileKey(s *ageStanza, id *Identity) ([]byte, error) {
+	if len(s.Args) != 1 || len(s.Body) != ageFileKeySize+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("invalid X25519 stanza")
+	}
+	share, err := ageBase64.DecodeString(s.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	}
+	shared, err := id.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 stanza: %v", err)
+	}
+	wrapKey, err := hkdfKey(shared, append(share, id.key.PublicKey().Bytes()...), ageX25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), s.Body, nil)
+}
+
+// headerMAC authenticates the header up to and including "---"
+func headerMAC(fileKey, header []byte) ([]byte, error) {
+	macKey, err := hkdfKey(fileKey, nil, "header", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(header)
+	return mac.Sum(nil), nil
+}
+
+// newPayloadAEAD derives the payload cipher from the file key and nonce
+func newPayloadAEAD(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := hkdfKey(fileKey, nonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// agePayloadPrefix turns the STREAM nonce of stream.go into the age payload
+// nonce, an 11 byte big-endian counter followed by the last flag
+var agePayloadPrefix = make([]byte, chacha20poly1305.NonceSize-streamNonceSuffix)
+
+// encryptAge encrypts src to the recipients and writes an age file to dst
+func encryptAge(dst io.Writer, src io.Reader, recipients []*Recipient) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return err
+	}
+
+	var hdr bytes.Buffer
+	hdr.WriteString(ageIntro)
+	for _, recipient := range recipients {
+		stanza, err := wrapFileKey(fileKey, recipient)
+		if err != nil {
+			return err
+		}
+		stanza.marshal(&hdr)
+	}
+	hdr.WriteString("---")
+	mac, err := headerMAC(fileKey, hdr.Bytes())
+	if err != nil {
+		return err
+	}
+	hdr.WriteString(" " + ageBase64.EncodeToString(mac) + "\n")
+
+	nonce := make([]byte, ageNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	hdr.Write(nonce)
+	if _, err := dst.Write(hdr.Bytes()); err != nil {
+		return err
+	}
+
+	payload, err := newPayloadAEAD(fileKey, nonce)
+	if err != nil {
+		return err
+	}
+	return sealChunks(dst, src, payload, agePayloadPrefix, ageChunkSize, nil)
+}
+
+// readAgeLine reads one header line including the newline. Lines are bounded
+// by the buffer size of r.
+func readAgeLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid age header: %v", ErrCorrupted, err)
+	}
+	return append([]byte(nil), line...), nil
+}
+
+// readAgeHeader parses the header of an age file. It returns the stanzas,
+// the header bytes covered by the MAC and the MAC itself.
+func readAgeHeader(r *bufio.Reader) ([]*ageStanza, []byte, []byte, error) {
+	var hdr bytes.Buffer
+	line, err := readAgeLine(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if string(line) != ageIntro {
+		return nil, nil, nil, fmt.Errorf("%w: age version", ErrUnsupportedVersion)
+	}
+	hdr.Write(line)
+
+	var stanzas []*ageStanza
+	for {
+		line, err := readAgeLine(r)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+
+		if strings.HasPrefix(text, "--- ") {
+			mac, err := ageBase64.DecodeString(strings.TrimPrefix(text, "--- "))
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%w: invalid header MAC: %v", ErrCorrupted, err)
+			}
+			hdr.WriteString("---")
+			return stanzas, hdr.Bytes(), mac, nil
+		}
+
+		args := strings.Split(text, " ")
+		if len(args) < 2 || args[0] != "->" {
+			return nil, nil, nil, fmt.Errorf("%w: invalid age header line %q", ErrCorrupted, text)
+		}
+		hdr.Write(line)
+		stanza := &ageStanza{Type: args[1], Args: args[2:]}
+		for {
+			line, err := readAgeLine(r)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			hdr.Write(line)
+			chunk := strings.TrimSuffix(string(line), "\n")
+			if len(chunk) > ageColumns {
+				return nil, nil, nil, fmt.Errorf("%w: invalid stanza body line", ErrCorrupted)
+			}
+			decoded, err := ageBase64.DecodeString(chunk)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%w: invalid stanza body: %v", ErrCorrupted, err)
+			}
+			stanza.Body = append(stanza.Body, decoded...)
+			if len(chunk) < ageColumns {
+				break
+			}
+		}
+		stanzas = append(stanzas, stanza)
+	}
+}
+
+// decryptAge decrypts an age file from r with any of the identities
+func decryptAge(dst io.Writer, r *bufio.Reader, identities []*Identity) error {
+	stanzas, hdr, mac, err := readAgeHeader(r)
+	if err != nil {
+		return err
+	}
+
+	var fileKey []byte
+	for _, stanza := range stanzas {
+		if stanza.Type != "X25519" {
+			continue
+		}
+		for _, id := range identities {
+			if key, err := unwrapFileKey(stanza, id); err == nil {
+				fileKey = key
+				break
+			}
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return ErrNoIdentity
+	}
+
+	expected, err := headerMAC(fileKey, hdr)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, expected) {
+		return fmt.Errorf("%w: age header MAC mismatch", ErrCorrupted)
+	}
+
+	nonce := make([]byte, ageNonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return fmt.Errorf("%w: encrypted stream is truncated", ErrCorrupted)
+	}
+	payload, err := newPayloadAEAD(fileKey, nonce)
+	if err != nil {
+		return err
+	}
+	// The header MAC proved the file key, so any failure is corruption
+	return openChunks(dst, r, payload, agePayloadPrefix, ageChunkSize, nil,
+		fmt.Errorf("%w: chunk 0 failed authentication", ErrCorrupted))
+}
+
+// Armor wraps a binary age file in the ASCII armor of age -a
+func Armor(data []byte) string {
+	var sb strings.Builder
+	sb.WriteString(ageArmorHeader + "\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > ageColumns {
+		sb.WriteString(encoded[:ageColumns] + "\n")
+		encoded = encoded[ageColumns:]
+	}
+	sb.WriteString(encoded + "\n")
+	sb.WriteString(ageArmorFooter + "\n")
+	return sb.String()
+}
+
+// dearmorAge decodes the ASCII armor of an age file
+func dearmorAge(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, ageArmorHeader) || !strings.HasSuffix(text, ageArmorFooter) {
+		return nil, fmt.Errorf("%w: invalid age armor", ErrCorrupted)
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, ageArmorHeader), ageArmorFooter)
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid age armor: %v", ErrCorrupted, err)
+	}
+	return data, nil
+}
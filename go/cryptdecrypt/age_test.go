@@ -0,0 +1,153 @@
+package cryptdecrypt_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// Vectors from the age test kit (c2sp.org/CCTV/age), the files are Base64
+// encoded. All successful ones decrypt to the same payload.
+const ageTestkitPayload = "013f54400c82da08037759ada907a8b864e97de81c088a182062c4b5622fd2ab"
+
+var ageTestkit = []struct {
+	name     string
+	identity string
+	file     string
+	want     error // nil for success
+}{
+	{
+		"x25519",
+		"AGE-SECRET-KEY-1XMWWC06LY3EE5RYTXM9MFLAZ2U56JJJ36S0MYPDRWSVLUL66MV4QX3S7F6",
+		"YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBURWlGMHlwcXIrYnB2Y3FYTnlDVkpwTDdPdXdQZFZ3UEw3S1FFYkZET0NjCkVtRUNBRWNLTituL1ZzOVNiV2lWK0h1MHIrRThSNzdEZFdZeWQ4M253N1UKLS0tIFZuKzU0anFpaVVDRStXWmNFVlkzZjFzcUhqbHUvejFMQ1EvVDdYbTdxSTAK7s9ix86RtDMnTmjU8vkTTLdMW/73vqpSyPC8DpksHoMx+2Y=",
+		nil,
+	},
+	{
+		"x25519_grease",
+		"AGE-SECRET-KEY-1XMWWC06LY3EE5RYTXM9MFLAZ2U56JJJ36S0MYPDRWSVLUL66MV4QX3S7F6",
+		"YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IGdyZWFzZQoKLT4gWDI1NTE5IFRFaUYweXBxciticHZjcVhOeUNWSnBMN091d1BkVndQTDdLUUViRkRPQ2MKRW1FQ0FFY0tOK24vVnM5U2JXaVYrSHUwcitFOFI3N0RkV1l5ZDgzbnc3VQotPiBncmVhc2UKCi0tLSA3TkxyZmJSVVp0NnFLMHBkdEFSVWY1OWRId28xMlJlbGRqSktqTWxiRTNJCu7PYsfOkbQzJ05o1PL5E0y3TFv+976qUsjwvA6ZLB6DMftm",
+		nil,
+	},
+	{
+		"x25519_multiple_recipients",
+		"AGE-SECRET-KEY-1XMWWC06LY3EE5RYTXM9MFLAZ2U56JJJ36S0MYPDRWSVLUL66MV4QX3S7F6",
+		"YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBhanRxQXZERWtWTnIyQjd6VU90cTJtQVFYRFNCbE5yVkF1TS9kS2I1c1Q0CjBldnJLL0hRWFZzUTRZYURlKzY1OWw1T1F6dkF6RDJ5dExHSFFMUWlxeGcKLT4gWDI1NTE5IDBxQzd1NkFiTHh1d25NOHRQRk9XVnRXWm4vWlplN3o3Z2NzUDVrZ0EwRkkKVC9QWmc3Nk1tVnQySWFMbnRyeHBwekRuemVGRFlIc0hGY25UbmhiUkxROAotLS0gN1cwN2VmMlBoc1RBbDc0cG4rOXZTai9YenVrd2E2U3VUcU1jMTZjZEJrMArwuBi+DzVUQjmZIK2AhJZLb5XDbbNeT1nY+J488m8tH6VCEw==",
+		nil,
+	},
+	{
+		"x25519_bad_tag",
+		"AGE-SECRET-KEY-1XMWWC06LY3EE5RYTXM9MFLAZ2U56JJJ36S0MYPDRWSVLUL66MV4QX3S7F6",
+		"YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBURWlGMHlwcXIrYnB2Y3FYTnlDVkpwTDdPdXdQZFZ3UEw3S1FFYkZET0NjCkVtRUNBRWNLTituL1ZzOVNiV2lWK0h1MHIrRThSNzdEZFdZeWQ4M253MG8KLS0tIHRHMGs5Ymc0aUl1QmRNV2IxM243RkZZRHpvQmJ0c0xwcE5MaGJoMjJhS2cK7s9ix86RtDMnTmjU8vkTTLdMW/73vqpSyPC8DpksHoMx+2Y=",
+		cryptdecrypt.ErrNoIdentity,
+	},
+	{
+		"x25519_low_order",
+		"AGE-SECRET-KEY-1EGTZVFFV20835NWYV6270LXYVK2VKNX2MMDKWYKLMGR48UAWX40Q2P2LM0",
+		"YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBYNXlWdktOUWpDU3gwTEZWbklQdld3UkVYTVJZSEk2RzJDSk8zZENmRWRjCjNFME5wRmFucy9tMFdMV0Y3KzU0WkJkTmozaXFRcXByYUdERmlhUmt2QkEKLS0tIHNYdzMyN1lNVDEvVUxYZStaeVJNYk1ZMFoyam5XSEdnSTlqMXdlNnlROEEKrF0/NwblUHHTpgQgRpe5CQWVwvf1267oDXoBjChyBYrzznw=",
+		cryptdecrypt.ErrNoIdentity,
+	},
+}
+
+func TestAgeTestkit(t *testing.T) {
+	for _, tt := range ageTestkit {
+		id, err := cryptdecrypt.ParseIdentity(tt.identity)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		file, err := base64.StdEncoding.DecodeString(tt.file)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var decrypted bytes.Buffer
+		err = cryptdecrypt.DecryptWith(&decrypted, bytes.NewReader(file), cryptdecrypt.Credentials{Identities: []*cryptdecrypt.Identity{id}})
+		if tt.want != nil {
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if sum := sha256.Sum256(decrypted.Bytes()); hex.EncodeToString(sum[:]) != ageTestkitPayload {
+			t.Errorf("%s: payload differs", tt.name)
+		}
+	}
+}
+
+// Files are exchanged with the reference implementation in both directions,
+// binary and armored
+func TestAgeInterop(t *testing.T) {
+	ours, err := cryptdecrypt.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := age.ParseX25519Identity(ours.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theirs.Recipient().String() != ours.Recipient().String() {
+		t.Fatalf("recipient %s, age derives %s", ours.Recipient(), theirs.Recipient())
+	}
+
+	// Sizes around the 64 KiB chunk of age
+	for _, size := range []int{0, 1, 65536, 65537, 200000} {
+		plaintext := randomBytes(t, size)
+
+		// cryptdecrypt to age
+		var encrypted bytes.Buffer
+		if err := cryptdecrypt.EncryptToRecipients(&encrypted, bytes.NewReader(plaintext), []*cryptdecrypt.Recipient{ours.Recipient()}); err != nil {
+			t.Fatal(err)
+		}
+		for name, r := range map[string]io.Reader{
+			"binary":  bytes.NewReader(encrypted.Bytes()),
+			"armored": armor.NewReader(strings.NewReader(cryptdecrypt.Armor(encrypted.Bytes()))),
+		} {
+			dec, err := age.Decrypt(r, theirs)
+			if err != nil {
+				t.Fatalf("%d bytes, %s: age.Decrypt: %v", size, name, err)
+			}
+			got, err := io.ReadAll(dec)
+			if err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("%d bytes, %s: age decrypted %d bytes, %v", size, name, len(got), err)
+			}
+		}
+
+		// age to cryptdecrypt
+		var fromAge, armored bytes.Buffer
+		aw := armor.NewWriter(&armored)
+		for _, dst := range []io.Writer{&fromAge, aw} {
+			w, err := age.Encrypt(dst, theirs.Recipient())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(plaintext)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := aw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		creds := cryptdecrypt.Credentials{Identities: []*cryptdecrypt.Identity{ours}}
+		for name, data := range map[string][]byte{"binary": fromAge.Bytes(), "armored": armored.Bytes()} {
+			var decrypted bytes.Buffer
+			if err := cryptdecrypt.DecryptWith(&decrypted, bytes.NewReader(data), creds); err != nil {
+				t.Fatalf("%d bytes, %s: DecryptWith: %v", size, name, err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plaintext) {
+				t.Errorf("%d bytes, %s: plaintext differs", size, name)
+			}
+		}
+	}
+}
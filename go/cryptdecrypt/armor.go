@@ -0,0 +1,140 @@
+package cryptdecrypt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Armored envelopes are PEM-like text for mail, terminals and paper:
+//
+//	-----BEGIN CRYPTDECRYPT ENVELOPE-----
+//	Q0RFTgEAAQEAAAAPAAAACAAAAAEQ...  Base64, 64 characters per line
+//	=2Zb3                            CRC-24 of the envelope, as in OpenPGP
+//	-----END CRYPTDECRYPT ENVELOPE-----
+//
+// The checksum only catches transcription errors, authenticity is provided
+// by the envelope itself.
+const (
+	envelopeArmorHeader = "-----BEGIN CRYPTDECRYPT ENVELOPE-----"
+	envelopeArmorFooter = "-----END CRYPTDECRYPT ENVELOPE-----"
+	armorColumns        = 64
+	crc24Init           = 0xb704ce
+
+	// envelopeBase64Prefix starts every Base64 envelope, "CDEN" and the
+	// high bits of the version
+	envelopeBase64Prefix = "Q0RFTg"
+)
+
+// crc24 updates the OpenPGP CRC-24 (RFC 4880, section 6.1) with data
+func crc24(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// armorChecksum returns the checksum line for the given CRC
+func armorChecksum(crc uint32) string {
+	return "=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)})
+}
+
+// lineWriter breaks the written text into lines of armorColumns characters
+type lineWriter struct {
+	w      io.Writer
+	column int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), armorColumns-l.column)
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+		if l.column += n; l.column == armorColumns {
+			if _, err := io.WriteString(l.w, "\n"); err != nil {
+				return written, err
+			}
+			l.column = 0
+		}
+	}
+	return written, nil
+}
+
+// armorWriter armors everything written to it
+type armorWriter struct {
+	w     io.Writer
+	lines *lineWriter
+	enc   io.WriteCloser
+	crc   uint32
+}
+
+// NewArmorWriter returns a writer that writes the armored form of an
+// envelope to w, e.g. of the output of Encrypt. Close writes the checksum and
+// the footer, it does not close w.
+func NewArmorWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := io.WriteString(w, envelopeArmorHeader+"\n"); err != nil {
+		return nil, err
+	}
+	lines := &lineWriter{w: w}
+	return &armorWriter{w: w, lines: lines, enc: base64.NewEncoder(base64.StdEncoding, lines), crc: crc24Init}, nil
+}
+
+func (a *armorWriter) Write(p []byte) (int, error) {
+	a.crc = crc24(a.crc, p)
+	return a.enc.Write(p)
+}
+
+func (a *armorWriter) Close() error {
+	if err := a.enc.Close(); err != nil {
+		return err
+	}
+	end := armorChecksum(a.crc) + "\n" + envelopeArmorFooter + "\n"
+	if a.lines.column > 0 {
+		end = "\n" + end
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
+
+// ArmorEnvelope returns the armored form of a binary envelope, e.g. of the
+// result of Seal
+func ArmorEnvelope(envelope []byte) string {
+	var sb strings.Builder
+	// Writing to a strings.Builder cannot fail
+	w, _ := NewArmorWriter(&sb)
+	w.Write(envelope)
+	w.Close()
+	return sb.String()
+}
+
+// dearmorEnvelope decodes an armored envelope and verifies its checksum
+func dearmorEnvelope(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, envelopeArmorHeader) || !strings.HasSuffix(text, envelopeArmorFooter) {
+		return nil, fmt.Errorf("%w: invalid armor, header or footer missing", ErrCorrupted)
+	}
+	lines := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(text, envelopeArmorHeader), envelopeArmorFooter))
+	if len(lines) == 0 || !strings.HasPrefix(lines[len(lines)-1], "=") {
+		return nil, fmt.Errorf("%w: invalid armor, checksum missing", ErrCorrupted)
+	}
+	checksum := lines[len(lines)-1]
+	data, err := base64.StdEncoding.DecodeString(strings.Join(lines[:len(lines)-1], ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid armor: %v", ErrCorrupted, err)
+	}
+	if armorChecksum(crc24(crc24Init, data)) != checksum {
+		return nil, fmt.Errorf("%w: armor checksum mismatch, the text was changed or mistyped", ErrCorrupted)
+	}
+	return data, nil
+}
@@ -1,15 +1,15 @@
 $env:GOOS = "linux"
 $env:GOARCH = "amd64"
-go build -o ./linux/cryptdecrypt main.go
+go build -o ./linux/cryptdecrypt ./cmd/cryptdecrypt
 
 $env:GOOS = "windows"
 $env:GOARCH = "amd64"
-go build -o ./windows/cryptdecrypt.exe main.go
+go build -o ./windows/cryptdecrypt.exe ./cmd/cryptdecrypt
 
 $env:GOOS = "darwin"
 $env:GOARCH = "amd64"
-go build -o ./macos/amd64/cryptdecrypt main.go
+go build -o ./macos/amd64/cryptdecrypt ./cmd/cryptdecrypt
 
 $env:GOOS = "darwin"
 $env:GOARCH = "arm64"
-go build -o ./macos/arm64/cryptdecrypt main.go
\ No newline at end of file
+go build -o ./macos/arm64/cryptdecrypt ./cmd/cryptdecrypt
\ No newline at end of file
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveDir returns a reader that produces a tar archive of dir. The archive
+// is written by a goroutine, so directories of any size are encrypted as one
+// stream without temporary files. Permissions, modification times and
+// symbolic links are preserved (PAX format for sub-second mtimes).
+func archiveDir(dir string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, dir))
+	}()
+	return pr
+}
+
+// writeTar writes all entries below dir to w with paths relative to dir
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Format = tar.FormatPAX
+		// Owner names are meaningless on the machine that extracts
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar restores an archive written by writeTar into dest. Entries must
+// stay inside dest: absolute paths and '..' are rejected, symbolic links may
+// only point downwards, and nothing is ever written through a symbolic link.
+func extractTar(r io.Reader, dest string) error {
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+
+	// Directory permissions and times are set last, as creating their content
+	// needs write access and changes the modification time
+	type dirAttrs struct {
+		path  string
+		mode  fs.FileMode
+		mtime time.Time
+	}
+	var dirs []dirAttrs
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("refusing to extract %q: path leaves the target directory", hdr.Name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if err := checkNoSymlinkParents(dest, target); err != nil {
+			return err
+		}
+		mode := fs.FileMode(hdr.Mode) & fs.ModePerm
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// MkdirAll accepts a link to a directory, the mode would then be
+			// set on the directory it points to
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				return fmt.Errorf("refusing to extract directory %q: %q exists and is not a directory", hdr.Name, target)
+			}
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{target, mode, hdr.ModTime})
+		case tar.TypeReg:
+			if err := extractFile(tr, target, mode); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(link) || !filepath.IsLocal(link) || strings.Contains(hdr.Linkname, "..") {
+				return fmt.Errorf("refusing to extract symbolic link %q -> %q", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("refusing to extract %q: unsupported entry type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// A later entry may have replaced the directory by a link
+		info, err := os.Lstat(dirs[i].path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("refusing to set the permissions of %q: it is no longer a directory", dirs[i].path)
+		}
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes the current archive entry to target
+func extractFile(r io.Reader, target string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	// Replace instead of truncating, so an existing link is not followed
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// The umask may have removed bits from mode
+	return os.Chmod(target, mode)
+}
+
+// checkNoSymlinkParents fails if a directory between dest and target is a
+// symbolic link, which could redirect the write outside of dest
+func checkNoSymlinkParents(dest, target string) error {
+	rel, err := filepath.Rel(dest, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	current := dest
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %q: %q is a symbolic link", target, current)
+		}
+	}
+	return nil
+}
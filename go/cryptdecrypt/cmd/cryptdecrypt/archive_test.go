@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+	if err := os.MkdirAll(filepath.Join(src, "sub", "empty"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "script.sh"), []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "sub", "script.sh"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/script.sh", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTar(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "restored")
+	if err := extractTar(&buf, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "sub", "script.sh"))
+	if err != nil || info.Mode().Perm() != 0750 || !info.ModTime().Equal(mtime) {
+		t.Errorf("script.sh: %v, %v", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "sub", "empty")); err != nil || !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Errorf("empty directory: %v, %v", info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "link")); err != nil || link != "sub/script.sh" {
+		t.Errorf("link = %q, %v", link, err)
+	}
+}
+
+// Every entry that would write outside of the target directory, or change
+// anything there, is refused
+func TestExtractTarRefusesEscapes(t *testing.T) {
+	dir := func(name string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0777}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: 4}
+	}
+	symlink := func(name, target string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target}
+	}
+
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+	}{
+		{"parent directory", []*tar.Header{file("../outside/x")}},
+		{"parent directory inside the path", []*tar.Header{file("sub/../../outside/x")}},
+		{"absolute path", []*tar.Header{file("OUTSIDE/x")}},
+		{"symlink to the parent", []*tar.Header{symlink("escape", "../outside")}},
+		{"absolute symlink", []*tar.Header{symlink("escape", "OUTSIDE")}},
+		{"symlink with .. that stays inside", []*tar.Header{dir("sub/"), symlink("sub/up", "../sub")}},
+		{"write through a symlinked directory", []*tar.Header{dir("sub/"), symlink("ln", "sub"), file("ln/x")}},
+		{"write through an existing symlink", []*tar.Header{file("existing/x")}},
+		{"directory over a symlink", []*tar.Header{dir("sub/"), symlink("ln", "sub"), dir("ln/")}},
+		{"directory replaced by a symlink", []*tar.Header{dir("sub/"), dir("d/"), symlink("d", "sub")}},
+		{"hard link", []*tar.Header{{Typeflag: tar.TypeLink, Name: "hard", Linkname: "OUTSIDE/secret"}}},
+		{"device", []*tar.Header{{Typeflag: tar.TypeChar, Name: "null", Devmajor: 1, Devminor: 3}}},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		outside := filepath.Join(root, "outside")
+		if err := os.Mkdir(outside, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(root, "dest")
+		if err := os.Mkdir(dest, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(dest, "existing")); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range tt.entries {
+			hdr := *hdr
+			hdr.Name = replaceOutside(hdr.Name, outside)
+			hdr.Linkname = replaceOutside(hdr.Linkname, outside)
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write([]byte("evil"))
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := extractTar(&buf, dest); err == nil {
+			t.Errorf("%s: the archive was extracted", tt.name)
+		}
+		entries, err := os.ReadDir(outside)
+		if err != nil || len(entries) != 1 {
+			t.Errorf("%s: the directory outside has %v, %v", tt.name, entries, err)
+		}
+		if info, err := os.Stat(outside); err != nil || info.Mode().Perm() != 0700 {
+			t.Errorf("%s: the directory outside changed: %v, %v", tt.name, info, err)
+		}
+		if info, err := os.Stat(filepath.Join(dest, "sub")); err == nil && info.Mode().Perm() != 0700 {
+			t.Errorf("%s: the mode of a directory was set through a link: %v", tt.name, info.Mode())
+		}
+	}
+}
+
+// replaceOutside puts the absolute path of the directory outside the target
+// in place of OUTSIDE
+func replaceOutside(name, outside string) string {
+	if rest, ok := strings.CutPrefix(name, "OUTSIDE"); ok {
+		return filepath.ToSlash(outside) + rest
+	}
+	return name
+}
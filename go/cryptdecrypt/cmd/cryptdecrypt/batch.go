@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// maxBatchLine limits one JSON Lines request
+const maxBatchLine = 16 * 1024 * 1024
+
+// batchRequest is one line of batch input
+type batchRequest struct {
+	Op   string `json:"op"` // "crypt" or "decrypt"
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	Data string `json:"data,omitempty"` // Base64 plaintext for crypt, instead of text
+}
+
+// batchResult is one line of batch output, in the order of the requests
+type batchResult struct {
+	ID     string `json:"id"`
+	Text   string `json:"text,omitempty"`
+	Data   string `json:"data,omitempty"` // Base64 plaintext that is not valid UTF-8
+	Signer string `json:"signer,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// requestPlaintext returns the plaintext of a request given either as text
+// or as Base64 data
+func requestPlaintext(text, data string) ([]byte, error) {
+	if data == "" {
+		return []byte(text), nil
+	}
+	if text != "" {
+		return nil, errors.New("give either text or data, not both")
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// responsePlaintext returns decrypted plaintext as text, or as Base64 data if
+// it is not valid UTF-8 and JSON would replace the invalid bytes
+func responsePlaintext(plaintext []byte) (text, data string) {
+	if utf8.Valid(plaintext) {
+		return string(plaintext), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(plaintext)
+}
+
+// runBatch reads JSON Lines requests from stdin and writes one result per
+// request to stdout. All requests share one password; keys are derived once
+// per salt and the requests are processed by parallel workers.
+func runBatch() {
+	if *workers < 1 {
+		log.Fatalf("Batch error: -workers must be at least 1")
+	}
+
+	kdf, err := cryptdecrypt.NewKDFParams(*kdfName, *kdfMemory, *kdfTime, *kdfThreads)
+	if err != nil {
+		log.Fatalf("Invalid KDF parameters: %v", err)
+	}
+	cipherID, err := cryptdecrypt.ParseCipher(*cipherName)
+	if err != nil {
+		log.Fatalf("Invalid cipher: %v", err)
+	}
+	// The requests are not known yet, so a prompted password is only
+	// confirmed when the first crypt request arrives. Decryption fails for a
+	// mistyped password, encryption does not.
+	source := passwordFlags()
+	pass, err := source.read("Password: ", false)
+	if err != nil {
+		log.Fatalf("Password error: %v", err)
+	}
+	keyfile, err := loadKeyfile()
+	if err != nil {
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	signer, err := loadSigningKey()
+	if err != nil {
+		log.Fatalf("Invalid signing key: %v", err)
+	}
+	signers, err := loadSigners()
+	if err != nil {
+		log.Fatalf("Invalid signers: %v", err)
+	}
+
+	var confirmOnce sync.Once
+	var confirmErr error
+	confirm := func() error {
+		confirmOnce.Do(func() {
+			if !source.given() {
+				confirmErr = confirmPassword(pass)
+			}
+		})
+		return confirmErr
+	}
+	session := cryptdecrypt.NewSession(
+		cryptdecrypt.Credentials{Password: pass, Keyfile: keyfile, Signers: signers},
+		&cryptdecrypt.Options{Cipher: cipherID, KDF: kdf, Signer: signer},
+	)
+
+	total, failed, err := processBatch(os.Stdin, os.Stdout, session, confirm, *workers)
+	if err != nil {
+		log.Fatalf("Batch error: %v", err)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d batch requests failed.", failed, total)
+	}
+}
+
+// processBatch answers the requests read from in on out with the given
+// number of parallel workers and returns the number of requests and of
+// failed ones. confirm is called before each crypt request.
+func processBatch(in io.Reader, w io.Writer, session *cryptdecrypt.Session, confirm func() error, workers int) (int, int, error) {
+	type job struct {
+		line int
+		data []byte
+	}
+	type done struct {
+		line   int
+		result batchResult
+	}
+	jobs := make(chan job)
+	results := make(chan done)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- done{j.line, processBatchRequest(session, confirm, j.line+1, j.data)}
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), maxBatchLine)
+		for line := 0; scanner.Scan(); line++ {
+			jobs <- job{line, append([]byte(nil), scanner.Bytes()...)}
+		}
+		readErr = scanner.Err()
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results are written in the order of the requests
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	pending := map[int]batchResult{}
+	next, total, failed := 0, 0, 0
+	var writeErr error
+	for r := range results {
+		if writeErr != nil {
+			// Keep receiving, so the workers can finish
+			continue
+		}
+		pending[r.line] = r.result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			total++
+			if result.Error != "" {
+				failed++
+			}
+			if writeErr = enc.Encode(result); writeErr != nil {
+				break
+			}
+		}
+		// Flush while the input is still being read, so a pipeline can
+		// consume results as they arrive
+		if len(pending) == 0 && writeErr == nil {
+			writeErr = out.Flush()
+		}
+	}
+	if writeErr != nil {
+		return total, failed, writeErr
+	}
+	if err := out.Flush(); err != nil {
+		return total, failed, err
+	}
+	if readErr != nil {
+		return total, failed, fmt.Errorf("reading requests: %v", readErr)
+	}
+	return total, failed, nil
+}
+
+// processBatchRequest handles one line of batch input, numbered from 1
+func processBatchRequest(session *cryptdecrypt.Session, confirm func() error, number int, line []byte) batchResult {
+	var req batchRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return batchResult{Error: fmt.Sprintf("line %d: invalid request: %v", number, err)}
+	}
+	result := batchResult{ID: req.ID}
+	switch req.Op {
+	case "crypt":
+		if err := confirm(); err != nil {
+			result.Error = err.Error()
+			break
+		}
+		plaintext, err := requestPlaintext(req.Text, req.Data)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		envelope, err := session.EncryptText(plaintext)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		result.Text = envelope
+	case "decrypt":
+		plaintext, sig, err := session.DecryptText(req.Text)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		result.Text, result.Data = responsePlaintext(plaintext)
+		if sig != nil {
+			result.Signer = sig.Signer.String()
+		}
+	default:
+		result.Error = fmt.Sprintf("unknown op %q, use 'crypt' or 'decrypt'", req.Op)
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// runTestBatch processes the requests and decodes the results
+func runTestBatch(t *testing.T, session *cryptdecrypt.Session, confirm func() error, workers int, requests string) ([]batchResult, int, int) {
+	t.Helper()
+	var out strings.Builder
+	total, failed, err := processBatch(strings.NewReader(requests), &out, session, confirm, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []batchResult
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var r batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid result %q: %v", scanner.Text(), err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != total {
+		t.Fatalf("%d results written, %d counted", len(results), total)
+	}
+	return results, total, failed
+}
+
+func batchLine(op, id, text string) string {
+	line, _ := json.Marshal(batchRequest{Op: op, ID: id, Text: text})
+	return string(line) + "\n"
+}
+
+func TestProcessBatchOrder(t *testing.T) {
+	session := cryptdecrypt.NewSession(cryptdecrypt.Credentials{Password: "batch"}, &cryptdecrypt.Options{KDF: fastKDF(t)})
+	noConfirm := func() error { return nil }
+
+	var crypt strings.Builder
+	for i := 0; i < 100; i++ {
+		// Different sizes, so the workers finish out of order
+		crypt.WriteString(batchLine("crypt", fmt.Sprint(i), strings.Repeat("x", (i*7919)%5000)))
+	}
+	encrypted, total, failed := runTestBatch(t, session, noConfirm, 8, crypt.String())
+	if total != 100 || failed != 0 {
+		t.Fatalf("crypt: %d requests, %d failed", total, failed)
+	}
+
+	var decrypt strings.Builder
+	for i, r := range encrypted {
+		if r.ID != fmt.Sprint(i) || r.Error != "" {
+			t.Fatalf("crypt result %d: %+v", i, r)
+		}
+		decrypt.WriteString(batchLine("decrypt", r.ID, r.Text))
+	}
+	decrypted, total, failed := runTestBatch(t, session, noConfirm, 8, decrypt.String())
+	if total != 100 || failed != 0 {
+		t.Fatalf("decrypt: %d requests, %d failed", total, failed)
+	}
+	for i, r := range decrypted {
+		if r.ID != fmt.Sprint(i) || r.Text != strings.Repeat("x", (i*7919)%5000) {
+			t.Fatalf("decrypt result %d: ID %q, %d bytes", i, r.ID, len(r.Text))
+		}
+	}
+}
+
+func TestProcessBatchFailures(t *testing.T) {
+	session := cryptdecrypt.NewSession(cryptdecrypt.Credentials{Password: "batch"}, &cryptdecrypt.Options{KDF: fastKDF(t)})
+	other, err := cryptdecrypt.EncryptText([]byte("other"), "other", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := batchLine("crypt", "ok", "text") +
+		"not json\n" +
+		batchLine("sign", "op", "text") +
+		batchLine("decrypt", "garbage", "not an envelope") +
+		batchLine("decrypt", "password", other) +
+		batchLine("crypt", "last", "text")
+	results, total, failed := runTestBatch(t, session, func() error { return nil }, 3, requests)
+	if total != 6 || failed != 4 {
+		t.Fatalf("%d requests, %d failed, want 6 and 4", total, failed)
+	}
+
+	want := []struct {
+		id    string
+		error string
+	}{
+		{"ok", ""},
+		{"", "line 2: invalid request"},
+		{"op", `unknown op "sign"`},
+		{"garbage", "?"},
+		{"password", cryptdecrypt.ErrWrongPassword.Error()},
+		{"last", ""},
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.ID != w.id {
+			t.Errorf("result %d: ID %q, want %q", i, r.ID, w.id)
+		}
+		switch {
+		case w.error == "" && r.Error != "":
+			t.Errorf("result %d: unexpected error %q", i, r.Error)
+		case w.error == "?" && r.Error == "":
+			t.Errorf("result %d: no error", i)
+		case w.error != "" && w.error != "?" && !strings.Contains(r.Error, w.error):
+			t.Errorf("result %d: error %q, want %q", i, r.Error, w.error)
+		}
+	}
+}
+
+func TestProcessBatchConfirm(t *testing.T) {
+	session := cryptdecrypt.NewSession(cryptdecrypt.Credentials{Password: "batch"}, &cryptdecrypt.Options{KDF: fastKDF(t)})
+	envelope, err := session.EncryptText([]byte("text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decrypt-only batches never ask for the confirmation
+	var calls atomic.Int32
+	confirm := func() error {
+		calls.Add(1)
+		return errPasswordMismatch
+	}
+	_, _, failed := runTestBatch(t, session, confirm, 4, batchLine("decrypt", "1", envelope)+batchLine("decrypt", "2", envelope))
+	if failed != 0 || calls.Load() != 0 {
+		t.Fatalf("decrypt only: %d failed, %d confirmations", failed, calls.Load())
+	}
+
+	// A failed confirmation fails the crypt requests only
+	results, total, failed := runTestBatch(t, session, confirm, 4, batchLine("crypt", "1", "text")+batchLine("decrypt", "2", envelope))
+	if total != 2 || failed != 1 {
+		t.Fatalf("%d requests, %d failed, want 2 and 1", total, failed)
+	}
+	if results[0].Error != errPasswordMismatch.Error() || results[0].Text != "" {
+		t.Errorf("crypt result: %+v", results[0])
+	}
+	if results[1].Error != "" || results[1].Text != "text" {
+		t.Errorf("decrypt result: %+v", results[1])
+	}
+}
+
+func TestProcessBatchBinary(t *testing.T) {
+	session := cryptdecrypt.NewSession(cryptdecrypt.Credentials{Password: "batch"}, &cryptdecrypt.Options{KDF: fastKDF(t)})
+	secret := []byte{0xff, 0xfe, 'k', 0x00, 0x80}
+	data := base64.StdEncoding.EncodeToString(secret)
+
+	line, _ := json.Marshal(batchRequest{Op: "crypt", ID: "binary", Data: data})
+	both, _ := json.Marshal(batchRequest{Op: "crypt", ID: "both", Text: "text", Data: data})
+	invalid, _ := json.Marshal(batchRequest{Op: "crypt", ID: "invalid", Data: "not base64!"})
+	encrypted, _, failed := runTestBatch(t, session, func() error { return nil }, 2, string(line)+"\n"+string(both)+"\n"+string(invalid)+"\n")
+	if failed != 2 || encrypted[0].Error != "" || encrypted[1].Error == "" || encrypted[2].Error == "" {
+		t.Fatalf("crypt results: %+v", encrypted)
+	}
+
+	// Binary plaintext comes back as data, valid UTF-8 as text
+	text, err := session.EncryptText([]byte("text ÿ"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, _, failed := runTestBatch(t, session, nil, 2, batchLine("decrypt", "binary", encrypted[0].Text)+batchLine("decrypt", "text", text))
+	if failed != 0 {
+		t.Fatalf("decrypt results: %+v", decrypted)
+	}
+	if decrypted[0].Text != "" || decrypted[0].Data != data {
+		t.Errorf("binary result: %+v, want data %q", decrypted[0], data)
+	}
+	if decrypted[1].Text != "text ÿ" || decrypted[1].Data != "" {
+		t.Errorf("text result: %+v", decrypted[1])
+	}
+}
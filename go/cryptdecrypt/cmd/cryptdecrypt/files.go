@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// openInput opens the file at path for reading, "-" means standard input
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// output is a destination for encrypted or decrypted data that is removed
+// again if processing fails, so no partial results are left behind
+type output struct {
+	*os.File
+	path string
+}
+
+// createOutput creates the file at path for writing, "-" means standard output
+func createOutput(path string) (*output, error) {
+	if path == "-" {
+		return &output{File: os.Stdout, path: path}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &output{File: file, path: path}, nil
+}
+
+// finish closes the output and deletes it if err is not nil
+func (o *output) finish(err error) error {
+	if o.path == "-" {
+		return err
+	}
+	if closeErr := o.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(o.path)
+	}
+	return err
+}
+
+// writeOutput writes data to the file at path or to stdout for "-"
+func writeOutput(path string, data []byte) error {
+	out, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return out.finish(err)
+}
+
+// processFile runs process from the input to the output path
+func processFile(inPath, outPath string, process func(io.Writer, io.Reader) error) error {
+	in, err := openInput(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Truncating the output would destroy the input before it is read
+	if err := checkDistinct(inPath, outPath); err != nil {
+		return err
+	}
+	out, err := createOutput(outPath)
+	if err != nil {
+		return err
+	}
+	return out.finish(process(out, in))
+}
+
+// checkDistinct fails if inPath and outPath are the same file, also through
+// links
+func checkDistinct(inPath, outPath string) error {
+	if inPath == "-" || outPath == "-" {
+		return nil
+	}
+	outInfo, err := os.Stat(outPath)
+	if err != nil {
+		// A missing output cannot be the input
+		return nil
+	}
+	inInfo, err := os.Stat(inPath)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(inInfo, outInfo) {
+		return fmt.Errorf("%s is the input file, write the output to another file", outPath)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data. The data is written
+// to a temporary file in the same directory first and renamed, so readers
+// see either the old or the new content.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return replaceFileAtomic(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// replaceFileAtomic is writeFileAtomic for content produced by write. If
+// write fails, the original file is left untouched.
+func replaceFileAtomic(path string, perm os.FileMode, write func(io.Writer) error) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
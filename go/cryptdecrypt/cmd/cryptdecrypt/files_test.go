@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func copyUpper(dst io.Writer, src io.Reader) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write([]byte(strings.ToUpper(string(data))))
+	return err
+}
+
+func TestProcessFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.txt")
+	if err := processFile(in, out, copyUpper); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "DATA" {
+		t.Errorf("output = %q, %v", data, err)
+	}
+
+	// A failed run leaves no partial output behind
+	failed := filepath.Join(dir, "failed.txt")
+	err := processFile(in, failed, func(dst io.Writer, src io.Reader) error {
+		dst.Write([]byte("partial"))
+		return errors.New("failure")
+	})
+	if err == nil {
+		t.Error("the error of process was lost")
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Errorf("partial output was kept: %v", err)
+	}
+}
+
+// The input is never truncated by using it as the output, also not through
+// a symbolic or hard link
+func TestProcessFileSameFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "same.txt")
+	if err := os.WriteFile(in, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	symlink := filepath.Join(dir, "symlink.txt")
+	if err := os.Symlink(in, symlink); err != nil {
+		t.Fatal(err)
+	}
+	hardlink := filepath.Join(dir, "hardlink.txt")
+	if err := os.Link(in, hardlink); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, out := range []string{in, filepath.Join(dir, ".", "same.txt"), symlink, hardlink} {
+		if err := processFile(in, out, copyUpper); err == nil {
+			t.Errorf("%s: the input was accepted as output", out)
+		}
+		if data, err := os.ReadFile(in); err != nil || string(data) != "data" {
+			t.Fatalf("%s: input = %q, %v", out, data, err)
+		}
+	}
+}
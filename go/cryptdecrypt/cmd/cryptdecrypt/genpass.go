@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// charsets are the named character sets of genpass -charset
+var charsets = map[string]string{
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"alpha":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"digits": "0123456789",
+	"hex":    "0123456789abcdef",
+	"ascii":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+	// Without characters that are easily confused when read aloud or typed
+	"safe": "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789",
+}
+
+// runGenpass prints a random password, or a diceware passphrase for -words
+func runGenpass() {
+	var pass string
+	var bits float64
+	var err error
+	if *words > 0 {
+		pass, bits, err = cryptdecrypt.GeneratePassphrase(*words, *separator)
+	} else {
+		charset, ok := charsets[*charsetName]
+		if !ok {
+			// Anything else is taken as the characters themselves
+			charset = *charsetName
+		}
+		pass, bits, err = cryptdecrypt.GeneratePassword(*length, charset)
+	}
+	if err != nil {
+		log.Fatalf("Genpass error: %v", err)
+	}
+
+	if err := writeOutput(*outPath, []byte(pass+"\n")); err != nil {
+		log.Fatalf("Genpass error: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Entropy: %.0f bits\n", bits)
+}
+
+// checkStrength prints the estimated strength of a newly chosen password on
+// stderr and enforces -min-entropy
+func checkStrength(pass string) {
+	s := cryptdecrypt.EstimateStrength(pass)
+	fmt.Fprintf(os.Stderr, "Password strength: %s\n", s)
+	if *minEntropy > 0 && s.Entropy < *minEntropy {
+		log.Fatalf("Password error: estimated %.0f bits, -min-entropy requires %.0f. Try 'cryptdecrypt genpass -words 6'.", s.Entropy, *minEntropy)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// readKeyFile parses the file at path and names it in errors
+func readKeyFile[T any](path string, parse func(io.Reader) ([]T, error)) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	items, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return items, nil
+}
+
+// loadRecipients collects the public keys from -recipient and -recipients-file
+func loadRecipients() ([]*cryptdecrypt.Recipient, error) {
+	var recipients []*cryptdecrypt.Recipient
+	for _, r := range recipientFlags {
+		recipient, err := cryptdecrypt.ParseRecipient(r)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	if *recipientsFile != "" {
+		fromFile, err := readKeyFile(*recipientsFile, cryptdecrypt.ParseRecipients)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, fromFile...)
+	}
+	return recipients, nil
+}
+
+// loadKeyfile returns the contents of -keyfile, nil if it is not given
+func loadKeyfile() ([]byte, error) {
+	if *keyfilePath == "" {
+		return nil, nil
+	}
+	return os.ReadFile(*keyfilePath)
+}
+
+// passwordFromShares combines shares given on the command line and in the
+// file at path, if any, into the password
+func passwordFromShares(values []string, path string) (string, error) {
+	var shares []*cryptdecrypt.Share
+	for _, v := range values {
+		s, err := cryptdecrypt.ParseShare(v)
+		if err != nil {
+			return "", fmt.Errorf("%.12s...: %v", strings.TrimSpace(v), err)
+		}
+		shares = append(shares, s)
+	}
+	if path != "" {
+		fromFile, err := readKeyFile(path, cryptdecrypt.ParseShares)
+		if err != nil {
+			return "", err
+		}
+		shares = append(shares, fromFile...)
+	}
+	secret, err := cryptdecrypt.CombineShares(shares)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// generatedKeyLength is the number of random bytes of a password created by
+// 'split -generate'
+const generatedKeyLength = 32
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// Command-line flags, shared by all modes
+var (
+	mode           = flag.String("mode", "", "Mode: 'crypt', 'decrypt', 'keygen' (new X25519 identity or Ed25519 signing key), 'genkeyfile', 'genpass' (random password or passphrase), 'sign', 'verify', 'secrets' (.env/YAML files), 'vault' (named secrets), 'batch' (JSON Lines on stdin), 'serve' (local encryption service), 'share' (reveal-once link on the local network), 'rekey' (change the password), 'split' or 'combine' (Shamir shares)")
+	text           = flag.String("text", "", "Text to encrypt/decrypt (any output of crypt, including legacy 'salt:ciphertext', for decryption)")
+	password       = flag.String("password", "", "Password for encryption/decryption (insecure, prefer the prompt or the sources below)")
+	passwordEnv    = flag.String("password-env", "", "Name of an environment variable holding the password")
+	passwordFile   = flag.String("password-file", "", "File whose first line is the password")
+	passwordFD     = flag.Int("password-fd", -1, "Open file descriptor to read the password from, e.g. 3 with '3<<<\"$PW\"'")
+	newPasswordEnv = flag.String("new-password-env", "", "rekey: environment variable holding the new password")
+	newPassFile    = flag.String("new-password-file", "", "rekey: file whose first line is the new password")
+	newPasswordFD  = flag.Int("new-password-fd", -1, "rekey: file descriptor to read the new password from")
+	dryRun         = flag.Bool("dry-run", false, "rekey: only check the old password and report what would change")
+	cipherName     = flag.String("cipher", "aes-256-gcm", "Cipher for encryption: 'aes-256-gcm' or 'chacha20-poly1305'")
+	inPath         = flag.String("in", "", "File to encrypt/decrypt instead of -text ('-' for stdin)")
+	outPath        = flag.String("out", "-", "File to write the result of -in or keygen to ('-' for stdout)")
+	armor          = flag.Bool("armor", false, "crypt: write an armored envelope (BEGIN/END lines and checksum) instead of Base64 text or binary")
+	opensslFormat  = flag.Bool("openssl", false, "crypt: write the unauthenticated format of 'openssl enc -aes-256-cbc' (Base64 like -a for -text)")
+	opensslKDF     = flag.String("openssl-kdf", "pbkdf2", "OpenSSL format: 'pbkdf2' (openssl -pbkdf2) or 'evp' (EVP_BytesToKey of older openssl commands)")
+	opensslMD      = flag.String("openssl-md", "sha256", "OpenSSL format: digest as for openssl -md, 'sha256', 'sha512', 'sha1' or 'md5'")
+	opensslIter    = flag.Int("openssl-iter", 10000, "OpenSSL format: PBKDF2 iterations as for openssl -iter")
+	qrPath         = flag.String("qr", "", "crypt: also render the -text output as a QR code PNG for paper backups (share: the link)")
+	kdfName        = flag.String("kdf", "scrypt", "Key derivation for encryption: 'scrypt' or 'argon2id'")
+	kdfMemory      = flag.Uint("kdf-memory", 0, "KDF memory in MiB (default: scrypt 32, argon2id 64)")
+	kdfTime        = flag.Uint("kdf-time", 0, "Argon2id number of passes (default 3)")
+	kdfThreads     = flag.Uint("kdf-threads", 0, "KDF parallelism (default: scrypt 1, argon2id 4)")
+	calibrate      = flag.Duration("calibrate", 0, "Choose KDF parameters so that unlocking takes this long, e.g. '1s' (without -mode: only print them)")
+	recipientFlags stringList
+	recipientsFile = flag.String("recipients-file", "", "File with one age1... public key per line to encrypt to")
+	identityFile   = flag.String("identity", "", "Identity file (AGE-SECRET-KEY-1...) for decrypting data encrypted to public keys")
+	keyType        = flag.String("type", "x25519", "keygen: 'x25519' (age identity for encryption) or 'ed25519' (signing key)")
+	signingKeyPath = flag.String("signing-key", "", "Ed25519 signing key for sign, or for crypt to embed a signature in the envelope")
+	signerFlags    stringList
+	signersFile    = flag.String("signers-file", "", "File with one trusted cdsign1... public key per line for verify and decrypt")
+	sigPath        = flag.String("sig", "", "verify: signature file (default FILE.sig)")
+	keyfilePath    = flag.String("keyfile", "", "Keyfile that is required in addition to the password (any file, or one made by genkeyfile)")
+	secretsFile    = flag.String("file", "", "Secrets file (.env or .yaml) for the secrets commands")
+	insecureTemp   = flag.Bool("insecure-temp", false, "secrets edit: allow the decrypted copy on disk where /dev/shm is missing")
+	workers        = flag.Int("workers", runtime.NumCPU(), "batch: number of requests processed in parallel")
+	listenAddr     = flag.String("listen", "127.0.0.1:8377", "serve: loopback address, or 'unix:PATH' for a Unix socket (share: default a random port on all interfaces)")
+	shareExpiry    = flag.Duration("expire", 10*time.Minute, "share: how long the link stays valid if nobody opens it")
+	tokensFile     = flag.String("tokens-file", "", "serve: file with one client per line, 'NAME TOKEN [OP,OP...]'")
+	auditPath      = flag.String("audit-log", "", "serve: file to append the audit log to (default stderr)")
+	length         = flag.Int("length", 24, "genpass: number of characters")
+	charsetName    = flag.String("charset", "alnum", "genpass: 'lower', 'alpha', 'alnum', 'digits', 'hex', 'ascii', 'safe' (no look-alikes) or the characters to use")
+	words          = flag.Int("words", 0, "genpass: number of diceware words (EFF large wordlist) instead of random characters")
+	separator      = flag.String("separator", "-", "genpass: separator between diceware words")
+	minEntropy     = flag.Float64("min-entropy", 0, "crypt, rekey, vault init: refuse new passwords with a lower estimated strength in bits")
+	vaultFile      = flag.String("vault", "", "Vault file for the vault commands (default $CRYPTDECRYPT_VAULT or ~/.cryptdecrypt.vault)")
+	dirPath        = flag.String("dir", "", "Directory to archive (tar) and encrypt as one stream")
+	extractDir     = flag.String("extract", "", "Directory to restore a decrypted -dir archive into")
+	shareCount     = flag.Int("shares", 5, "split: number of shares to create")
+	threshold      = flag.Int("threshold", 3, "split: number of shares needed to recover the password")
+	generate       = flag.Bool("generate", false, "split: generate a random password instead of reading one")
+	shareFlags     stringList
+	shareFile      = flag.String("share-file", "", "File with one Shamir share per line to combine into the password")
+)
+
+func init() {
+	flag.Var(&recipientFlags, "recipient", "age1... public key to encrypt to instead of a password (repeatable)")
+	flag.Var(&signerFlags, "signer", "Trusted cdsign1... public key for verify and decrypt (repeatable)")
+	flag.Var(&shareFlags, "share", "Shamir share to combine into the password (repeat until the threshold is reached)")
+}
+
+func main() {
+	// The mode may also be given as the first argument: 'cryptdecrypt keygen'
+	args := parseArgs(os.Args[1:])
+	if *mode == "" && len(args) > 0 {
+		*mode = args[0]
+		args = args[1:]
+	}
+
+	switch *mode {
+	case "crypt", "":
+		runCrypt()
+	case "decrypt":
+		runDecrypt()
+	case "keygen":
+		runKeygen()
+	case "genkeyfile":
+		runGenKeyfile(args)
+	case "genpass":
+		runGenpass()
+	case "sign":
+		runSign(args)
+	case "verify":
+		runVerify(args)
+	case "secrets":
+		runSecrets(args)
+	case "vault":
+		runVault(args)
+	case "batch":
+		runBatch()
+	case "serve":
+		runServe()
+	case "share":
+		runShare()
+	case "rekey":
+		runRekey(args)
+	case "split":
+		runSplit()
+	case "combine":
+		runCombine(args)
+	default:
+		fmt.Println("Invalid mode. Use 'crypt', 'decrypt', 'keygen', 'genkeyfile', 'genpass', 'sign', 'verify', 'secrets', 'vault', 'batch', 'serve', 'share', 'rekey', 'split' or 'combine'.")
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses flags anywhere between the positional arguments and
+// returns the positional arguments. Everything after "--" is returned as is.
+func parseArgs(args []string) []string {
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args)
+		rest := flag.Args()
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...)
+		}
+		if len(rest) == 0 {
+			return positional
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// flagGiven reports whether a flag was set on the command line
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// runCrypt encrypts -text or -in with a password or to public keys
+func runCrypt() {
+	// KDF parameters are only chosen here, decryption reads them from the envelope
+	kdf, err := cryptdecrypt.NewKDFParams(*kdfName, *kdfMemory, *kdfTime, *kdfThreads)
+	if err != nil {
+		log.Fatalf("Invalid KDF parameters: %v", err)
+	}
+	if *calibrate > 0 {
+		if kdf, err = cryptdecrypt.CalibrateKDF(kdf, *calibrate); err != nil {
+			log.Fatalf("Calibration error: %v", err)
+		}
+		if *mode == "" {
+			fmt.Println(kdf)
+			fmt.Println(kdfFlags(kdf))
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Using %s\n", kdf)
+	}
+
+	// Check if required parameters are provided
+	if *mode == "" {
+		fmt.Println("Error: Parameter mode is required.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	recipients, err := loadRecipients()
+	if err != nil {
+		log.Fatalf("Invalid recipients: %v", err)
+	}
+
+	// Without recipients a password is needed, prompted for twice if not given
+	source := passwordFlags()
+	var pass string
+	if len(recipients) > 0 {
+		if source.given() || *keyfilePath != "" {
+			log.Fatalf("Use either a password (and keyfile) or recipients, not both.")
+		}
+		if *signingKeyPath != "" {
+			log.Fatalf("Signatures are embedded in password envelopes only, use 'sign' for a detached signature.")
+		}
+		if *armor && *text == "" {
+			log.Fatalf("-armor is only supported for password encryption, age files are armored for -text.")
+		}
+		if *opensslFormat {
+			log.Fatalf("-openssl needs a password, openssl enc has no public keys.")
+		}
+	} else if pass, err = source.read("Password: ", true); err != nil {
+		log.Fatalf("Password error: %v", err)
+	} else {
+		checkStrength(pass)
+	}
+
+	cipherID, err := cryptdecrypt.ParseCipher(*cipherName)
+	if err != nil {
+		log.Fatalf("Invalid cipher: %v", err)
+	}
+	keyfile, err := loadKeyfile()
+	if err != nil {
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	signer, err := loadSigningKey()
+	if err != nil {
+		log.Fatalf("Invalid signing key: %v", err)
+	}
+	opts := &cryptdecrypt.Options{Cipher: cipherID, KDF: kdf, Keyfile: keyfile, Signer: signer}
+	if *opensslFormat {
+		if keyfile != nil || signer != nil || *armor {
+			log.Fatalf("-openssl cannot be combined with -keyfile, -signing-key or -armor, openssl does not know them.")
+		}
+		fmt.Fprintln(os.Stderr, "WARNING: the OpenSSL format is not authenticated, changes to the encrypted data go unnoticed.")
+	}
+
+	encrypt := func(dst io.Writer, src io.Reader) error {
+		if len(recipients) > 0 {
+			return cryptdecrypt.EncryptToRecipients(dst, src, recipients)
+		}
+		if *opensslFormat {
+			// openssl enc pads at the end, the format cannot be streamed
+			plaintext, err := io.ReadAll(src)
+			if err != nil {
+				return err
+			}
+			data, err := cryptdecrypt.EncryptOpenSSL(plaintext, pass, opensslOptions())
+			if err != nil {
+				return err
+			}
+			_, err = dst.Write(data)
+			return err
+		}
+		if !*armor {
+			return cryptdecrypt.Encrypt(dst, src, pass, opts)
+		}
+		w, err := cryptdecrypt.NewArmorWriter(dst)
+		if err != nil {
+			return err
+		}
+		if err := cryptdecrypt.Encrypt(w, src, pass, opts); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	if *qrPath != "" && *text == "" {
+		log.Fatalf("-qr needs -text, a QR code only holds about 2 KB.")
+	}
+
+	// Directories are archived and encrypted as one stream
+	if *dirPath != "" {
+		if info, err := os.Stat(*dirPath); err != nil || !info.IsDir() {
+			log.Fatalf("Not a directory: %s", *dirPath)
+		}
+		out, err := createOutput(*outPath)
+		if err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		archive := archiveDir(*dirPath)
+		err = encrypt(out, archive)
+		archive.Close()
+		if err := out.finish(err); err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		return
+	}
+
+	// Files and streams are encrypted chunk by chunk
+	if *inPath != "" {
+		if err := processFile(*inPath, *outPath, encrypt); err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		return
+	}
+
+	// Ensure text is provided for encryption
+	if *text == "" {
+		fmt.Println("Error: Text or input file to encrypt is required.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Perform encryption. Text encrypted to public keys is printed as an
+	// armored age file.
+	var output string
+	switch {
+	case len(recipients) > 0:
+		var ageFile strings.Builder
+		if err := cryptdecrypt.EncryptToRecipients(&ageFile, strings.NewReader(*text), recipients); err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		output = cryptdecrypt.Armor([]byte(ageFile.String()))
+	case *opensslFormat:
+		o := opensslOptions()
+		o.Base64 = true
+		data, err := cryptdecrypt.EncryptOpenSSL([]byte(*text), pass, o)
+		if err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		output = string(data)
+	case *armor:
+		envelope, err := cryptdecrypt.Seal([]byte(*text), pass, opts)
+		if err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		output = cryptdecrypt.ArmorEnvelope(envelope)
+	default:
+		envelope, err := cryptdecrypt.EncryptText([]byte(*text), pass, opts)
+		if err != nil {
+			log.Fatalf("Encryption error: %v", err)
+		}
+		output = envelope + "\n"
+	}
+
+	// Print the envelope, which carries salt, nonce and parameters itself
+	fmt.Print(output)
+	if *qrPath != "" {
+		if err := writeQR(*qrPath, strings.TrimSpace(output)); err != nil {
+			log.Fatalf("QR code error: %v", err)
+		}
+	}
+}
+
+// kdfFlags returns the command-line flags that reproduce the parameters
+func kdfFlags(p cryptdecrypt.KDFParams) string {
+	if p.Algorithm == cryptdecrypt.Argon2id {
+		return fmt.Sprintf("-kdf argon2id -kdf-memory %d -kdf-time %d -kdf-threads %d", p.Memory>>10, p.Time, p.Threads)
+	}
+	return fmt.Sprintf("-kdf scrypt -kdf-memory %d -kdf-threads %d", (128*uint64(p.R)<<p.LogN)>>20, p.P)
+}
+
+// runDecrypt decrypts -text or -in with a password or an identity file
+func runDecrypt() {
+	creds := cryptdecrypt.Credentials{OpenSSL: opensslOptions()}
+	if *identityFile != "" {
+		identities, err := readKeyFile(*identityFile, cryptdecrypt.ParseIdentities)
+		if err != nil {
+			log.Fatalf("Invalid identity file: %v", err)
+		}
+		creds.Identities = identities
+	}
+	keyfile, err := loadKeyfile()
+	if err != nil {
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	creds.Keyfile = keyfile
+	if creds.Signers, err = loadSigners(); err != nil {
+		log.Fatalf("Invalid signers: %v", err)
+	}
+	trusted := len(creds.Signers) > 0
+
+	// A password is needed unless an identity file is used instead
+	if source := passwordFlags(); len(creds.Identities) == 0 || source.given() {
+		pass, err := source.read("Password: ", false)
+		if err != nil {
+			log.Fatalf("Password error: %v", err)
+		}
+		creds.Password = pass
+	}
+
+	// Archives are extracted while they are decrypted
+	if *extractDir != "" {
+		if *inPath == "" {
+			log.Fatalf("-extract needs the encrypted archive as -in.")
+		}
+		sig, err := decryptArchive(*inPath, *extractDir, creds)
+		if err != nil {
+			log.Fatalf("Extraction error: %v", err)
+		}
+		reportSignature(sig, trusted)
+		return
+	}
+
+	// Files and streams contain the binary envelope or age file
+	if *inPath != "" {
+		var sig *cryptdecrypt.Signature
+		err := processFile(*inPath, *outPath, func(dst io.Writer, src io.Reader) error {
+			var err error
+			sig, err = cryptdecrypt.DecryptSigned(dst, src, creds)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Decryption error: %v", err)
+		}
+		reportSignature(sig, trusted)
+		return
+	}
+
+	// Ensure text is provided for decryption
+	if *text == "" {
+		fmt.Println("Error: Text or input file to decrypt is required.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Perform decryption
+	decryptedText, sig, err := cryptdecrypt.DecryptTextSigned(*text, creds)
+	if err != nil {
+		log.Fatalf("Decryption error: %v", err)
+	}
+	reportSignature(sig, trusted)
+
+	// Output the decrypted text only (no salt/ciphertext for decrypt mode)
+	fmt.Print(string(decryptedText))
+}
+
+// decryptArchive decrypts the archive at inPath and extracts it into dir.
+// Decryption and extraction run concurrently; if the archive turns out to be
+// corrupted, the files extracted up to that point remain in dir.
+func decryptArchive(inPath, dir string, creds cryptdecrypt.Credentials) (*cryptdecrypt.Signature, error) {
+	in, err := openInput(inPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	type result struct {
+		sig *cryptdecrypt.Signature
+		err error
+	}
+	done := make(chan result, 1)
+	pr, pw := io.Pipe()
+	go func() {
+		sig, err := cryptdecrypt.DecryptSigned(pw, in, creds)
+		pw.CloseWithError(err)
+		done <- result{sig, err}
+	}()
+	err = extractTar(pr, dir)
+	pr.CloseWithError(err)
+
+	// The signature is only verified after the last chunk
+	r := <-done
+	if err != nil {
+		return nil, err
+	}
+	return r.sig, r.err
+}
+
+// runKeygen writes a new X25519 identity or Ed25519 signing key and prints
+// its public key
+func runKeygen() {
+	var write func(io.Writer) error
+	var public fmt.Stringer
+	switch *keyType {
+	case "x25519":
+		id, err := cryptdecrypt.GenerateIdentity()
+		if err != nil {
+			log.Fatalf("Key generation error: %v", err)
+		}
+		write = func(w io.Writer) error { return cryptdecrypt.WriteIdentity(w, id) }
+		public = id.Recipient()
+	case "ed25519":
+		key, err := cryptdecrypt.GenerateSigningKey()
+		if err != nil {
+			log.Fatalf("Key generation error: %v", err)
+		}
+		write = func(w io.Writer) error { return cryptdecrypt.WriteSigningKey(w, key) }
+		public = key.Public()
+	default:
+		log.Fatalf("Key generation error: unknown key type %q, use 'x25519' or 'ed25519'", *keyType)
+	}
+
+	out, err := createOutput(*outPath)
+	if err != nil {
+		log.Fatalf("Key generation error: %v", err)
+	}
+	if err := out.finish(write(out)); err != nil {
+		log.Fatalf("Key generation error: %v", err)
+	}
+	if *outPath != "-" {
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", public)
+	}
+}
+
+// runGenKeyfile writes a new random keyfile to the given path or -out. An
+// existing file is never overwritten, it may still protect data.
+func runGenKeyfile(args []string) {
+	path := *outPath
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" || path == "-" {
+		fmt.Println("Usage: cryptdecrypt genkeyfile FILE")
+		os.Exit(1)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	err = cryptdecrypt.GenerateKeyfile(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Keyfile written to %s. Keep a backup, data encrypted with it cannot be decrypted without it.\n", path)
+}
+
+// runRekey changes the password of the given files or of -text
+func runRekey(files []string) {
+	if len(files) == 0 && *text == "" {
+		fmt.Println("Usage: cryptdecrypt rekey [-dry-run] [-text BLOB] FILE...")
+		os.Exit(1)
+	}
+
+	o := rekeyOptions{OpenSSL: opensslOptions()}
+	var err error
+	if o.Old, err = passwordFlags().read("Old password: ", false); err != nil {
+		log.Fatalf("Password error: %v", err)
+	}
+	if o.Keyfile, err = loadKeyfile(); err != nil {
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	if o.Signer, err = loadSigningKey(); err != nil {
+		log.Fatalf("Invalid signing key: %v", err)
+	}
+	o.DryRun = *dryRun
+	if !o.DryRun {
+		newSource := passwordSource{Env: *newPasswordEnv, File: *newPassFile, FD: *newPasswordFD}
+		if o.New, err = newSource.read("New password: ", true); err != nil {
+			log.Fatalf("Password error: %v", err)
+		}
+		checkStrength(o.New)
+	}
+	// Without KDF flags every blob keeps its algorithms and parameters
+	if flagGiven("kdf") || flagGiven("kdf-memory") || flagGiven("kdf-time") || flagGiven("kdf-threads") {
+		kdf, err := cryptdecrypt.NewKDFParams(*kdfName, *kdfMemory, *kdfTime, *kdfThreads)
+		if err != nil {
+			log.Fatalf("Invalid KDF parameters: %v", err)
+		}
+		o.KDF = &kdf
+	}
+
+	if *text != "" {
+		desc, rekeyed, err := rekeyText(*text, o)
+		if err != nil {
+			log.Fatalf("Rekey error: %s: %v", desc, err)
+		}
+		if o.DryRun {
+			fmt.Fprintf(os.Stderr, "%s: would be rekeyed\n", desc)
+		} else {
+			fmt.Println(rekeyed)
+		}
+	}
+
+	if failed := rekeyFiles(os.Stderr, files, o); failed > 0 {
+		log.Fatalf("%d of %d files could not be rekeyed.", failed, len(files))
+	}
+}
+
+// runSplit splits the password, or a generated one, into Shamir shares
+func runSplit() {
+	var secret string
+	var err error
+	source := passwordFlags()
+	if *generate {
+		if source.given() {
+			log.Fatalf("Use either -generate or a password, not both.")
+		}
+		key := make([]byte, generatedKeyLength)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Split error: %v", err)
+		}
+		secret = base64.RawURLEncoding.EncodeToString(key)
+	} else if secret, err = source.read("Password to split: ", true); err != nil {
+		log.Fatalf("Password error: %v", err)
+	}
+
+	shares, err := cryptdecrypt.SplitSecret([]byte(secret), *shareCount, *threshold)
+	if err != nil {
+		log.Fatalf("Split error: %v", err)
+	}
+	var b strings.Builder
+	for _, s := range shares {
+		fmt.Fprintf(&b, "# share %d of %d, any %d recover the password\n%s\n", s.Index, len(shares), s.Threshold, s)
+	}
+	if err := writeOutput(*outPath, []byte(b.String())); err != nil {
+		log.Fatalf("Split error: %v", err)
+	}
+
+	// The generated password is shown once to encrypt with, e.g. via -password-fd
+	if *generate {
+		fmt.Fprintf(os.Stderr, "Generated password (encrypt with it, then discard it): %s\n", secret)
+	}
+}
+
+// runCombine prints the password recovered from shares given as arguments,
+// with -share or in -share-file
+func runCombine(args []string) {
+	values := append(append([]string{}, shareFlags...), args...)
+	if len(values) == 0 && *shareFile == "" {
+		fmt.Println("Usage: cryptdecrypt combine [-share-file FILE] [-out FILE] SHARE...")
+		os.Exit(1)
+	}
+	secret, err := passwordFromShares(values, *shareFile)
+	if err != nil {
+		log.Fatalf("Combine error: %v", err)
+	}
+	if err := writeOutput(*outPath, []byte(secret+"\n")); err != nil {
+		log.Fatalf("Combine error: %v", err)
+	}
+}
+
+// passwordFlags returns the password source selected on the command line
+func passwordFlags() passwordSource {
+	return passwordSource{
+		Plain:     *password,
+		Env:       *passwordEnv,
+		File:      *passwordFile,
+		FD:        *passwordFD,
+		Shares:    shareFlags,
+		ShareFile: *shareFile,
+	}
+}
+
+// opensslOptions returns the key derivation for the OpenSSL format selected
+// by the -openssl-* flags
+func opensslOptions() *cryptdecrypt.OpenSSLOptions {
+	return &cryptdecrypt.OpenSSLOptions{KDF: *opensslKDF, Digest: *opensslMD, Iterations: *opensslIter}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var (
+	errNoPassword       = errors.New("no password given and no terminal to prompt on; use -password-file, -password-env or -password-fd")
+	errPasswordMismatch = errors.New("passwords do not match")
+)
+
+// passwordSource describes where a password is read from. At most one of the
+// fields may be set; if none is, the password is prompted for on the terminal.
+type passwordSource struct {
+	Plain string // -password, visible in the shell history and process list
+	Env   string // name of an environment variable
+	File  string // path of a file whose first line is the password
+	FD    int    // open file descriptor to read the first line from, -1 if unset
+
+	// Shamir shares combined into the password, given directly or in a file
+	Shares    []string
+	ShareFile string
+}
+
+// given reports whether any source was configured
+func (s passwordSource) given() bool {
+	return s.Plain != "" || s.Env != "" || s.File != "" || s.FD >= 0 || s.hasShares()
+}
+
+// hasShares reports whether the password is to be combined from shares
+func (s passwordSource) hasShares() bool {
+	return len(s.Shares) > 0 || s.ShareFile != ""
+}
+
+// read returns the password. When it has to prompt, confirm asks a second
+// time and fails if the two entries differ.
+func (s passwordSource) read(prompt string, confirm bool) (string, error) {
+	count := 0
+	for _, set := range []bool{s.Plain != "", s.Env != "", s.File != "", s.FD >= 0, s.hasShares()} {
+		if set {
+			count++
+		}
+	}
+	if count > 1 {
+		return "", fmt.Errorf("only one password source may be given")
+	}
+
+	switch {
+	case s.Plain != "":
+		fmt.Fprintln(os.Stderr, "WARNING: -password is visible in the shell history and to other users via the process list.")
+		fmt.Fprintln(os.Stderr, "WARNING: use the prompt, -password-file, -password-env or -password-fd instead.")
+		return s.Plain, nil
+	case s.Env != "":
+		password, ok := os.LookupEnv(s.Env)
+		if !ok || password == "" {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return password, nil
+	case s.File != "":
+		file, err := os.Open(s.File)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		return readFirstLine(file)
+	case s.FD >= 0:
+		file := os.NewFile(uintptr(s.FD), fmt.Sprintf("fd %d", s.FD))
+		if file == nil {
+			return "", fmt.Errorf("invalid file descriptor %d", s.FD)
+		}
+		defer file.Close()
+		return readFirstLine(file)
+	case s.hasShares():
+		return passwordFromShares(s.Shares, s.ShareFile)
+	default:
+		return promptPassword(prompt, confirm)
+	}
+}
+
+// readFirstLine reads a password terminated by a newline or EOF
+func readFirstLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("password is empty")
+	}
+	return line, nil
+}
+
+// openTerminal opens the controlling terminal, so that the prompt works even
+// when stdin and stdout carry data
+func openTerminal() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.OpenFile("CONIN$", os.O_RDWR, 0)
+	}
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// promptPassword reads a password without echo from the terminal
+func promptPassword(prompt string, confirm bool) (string, error) {
+	tty, err := openTerminal()
+	if err != nil || !term.IsTerminal(int(tty.Fd())) {
+		return "", errNoPassword
+	}
+	defer tty.Close()
+
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	password, err := read(prompt)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("password is empty")
+	}
+	if confirm {
+		again, err := read("Confirm password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errPasswordMismatch
+		}
+	}
+	return password, nil
+}
+
+// confirmPassword asks for a password that was prompted for earlier once
+// more and fails if the entries differ
+func confirmPassword(password string) error {
+	again, err := promptPassword("Confirm password: ", false)
+	if err != nil {
+		return err
+	}
+	if again != password {
+		return errPasswordMismatch
+	}
+	return nil
+}
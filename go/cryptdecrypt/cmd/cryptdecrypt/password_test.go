@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+func TestPasswordSource(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	t.Setenv("CRYPTDECRYPT_TEST_PASSWORD", "from env")
+	t.Setenv("CRYPTDECRYPT_TEST_EMPTY", "")
+
+	tests := []struct {
+		name   string
+		source passwordSource
+		want   string // empty if an error is expected
+	}{
+		{"plain", passwordSource{Plain: "plain", FD: -1}, "plain"},
+		{"env", passwordSource{Env: "CRYPTDECRYPT_TEST_PASSWORD", FD: -1}, "from env"},
+		{"unset env", passwordSource{Env: "CRYPTDECRYPT_TEST_UNSET", FD: -1}, ""},
+		{"empty env", passwordSource{Env: "CRYPTDECRYPT_TEST_EMPTY", FD: -1}, ""},
+		{"file", passwordSource{File: writeFile("pw", "from file\nsecond line\n"), FD: -1}, "from file"},
+		{"file without newline", passwordSource{File: writeFile("pw-eof", "no newline"), FD: -1}, "no newline"},
+		{"file with CRLF", passwordSource{File: writeFile("pw-crlf", "windows\r\n"), FD: -1}, "windows"},
+		{"spaces are kept", passwordSource{File: writeFile("pw-spaces", " spaces \n"), FD: -1}, " spaces "},
+		{"empty file", passwordSource{File: writeFile("pw-empty", "\n"), FD: -1}, ""},
+		{"missing file", passwordSource{File: filepath.Join(dir, "missing"), FD: -1}, ""},
+		{"two sources", passwordSource{Plain: "plain", Env: "CRYPTDECRYPT_TEST_PASSWORD", FD: -1}, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.source.read("Password: ", false)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestPasswordSourceShares(t *testing.T) {
+	shares, err := cryptdecrypt.SplitSecret([]byte("master password"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "shares.txt")
+	if err := os.WriteFile(path, []byte("# share 3\n"+shares[2].String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []passwordSource{
+		{Shares: []string{shares[0].String(), shares[1].String()}, FD: -1},
+		{Shares: []string{shares[0].String()}, ShareFile: path, FD: -1},
+	} {
+		if got, err := s.read("Password: ", false); err != nil || got != "master password" {
+			t.Errorf("%+v: got %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []passwordSource{
+		{Shares: []string{shares[0].String()}, FD: -1},
+		{Shares: []string{shares[0].String(), shares[1].String()}, Plain: "plain", FD: -1},
+	} {
+		if got, err := s.read("Password: ", false); err == nil {
+			t.Errorf("%+v: got %q, want an error", s, got)
+		}
+	}
+}
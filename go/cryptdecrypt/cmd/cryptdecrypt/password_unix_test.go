@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestPasswordSourceFD(t *testing.T) {
+	// A raw pipe, the descriptor is closed by read and must not belong to
+	// an *os.File of the test
+	var p [2]int
+	if err := syscall.Pipe(p[:]); err != nil {
+		t.Fatal(err)
+	}
+	w := os.NewFile(uintptr(p[1]), "pipe")
+	go func() {
+		w.WriteString("from fd\n")
+		w.Close()
+	}()
+	s := passwordSource{FD: p[0]}
+	if !s.given() {
+		t.Error("a file descriptor does not count as a source")
+	}
+	if got, err := s.read("Password: ", false); err != nil || got != "from fd" {
+		t.Errorf("got %q, %v, want %q", got, err, "from fd")
+	}
+	if (passwordSource{FD: -1}).given() {
+		t.Error("an empty source counts as given")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// qrModuleSize is the size of one QR code module in pixels, large enough to
+// survive printing and scanning
+const qrModuleSize = 8
+
+// writeQR renders text as a QR code PNG for paper backups. Medium error
+// correction fits about 2 KB, enough for a password or a short key.
+func writeQR(path, text string) error {
+	code, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("%d characters do not fit into a QR code: %v", len(text), err)
+	}
+	png, err := code.PNG(-qrModuleSize)
+	if err != nil {
+		return err
+	}
+	return writeOutput(path, png)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// rekeyOptions describe a password change
+type rekeyOptions struct {
+	Old     string
+	New     string
+	Keyfile []byte                       // kept for data that requires it
+	Signer  *cryptdecrypt.SigningKey     // signs the new version of signed envelopes
+	KDF     *cryptdecrypt.KDFParams      // new KDF parameters, nil keeps those of the original
+	OpenSSL *cryptdecrypt.OpenSSLOptions // key derivation of OpenSSL data, kept for the new version
+	DryRun  bool                         // only verify the old password and report
+}
+
+// credentials returns the credentials that unlock data with the old password
+func (o rekeyOptions) credentials() cryptdecrypt.Credentials {
+	return cryptdecrypt.Credentials{Password: o.Old, Keyfile: o.Keyfile, OpenSSL: o.OpenSSL}
+}
+
+// encryptOptions returns the encryption options for the rekeyed version of
+// the described data, keeping its algorithms unless new KDF flags were given
+// and the keyfile and signature if it has them
+func (o rekeyOptions) encryptOptions(info *cryptdecrypt.Info) *cryptdecrypt.Options {
+	opts := info.Options()
+	if o.KDF != nil {
+		opts.KDF = *o.KDF
+	}
+	if info.Keyfile {
+		opts.Keyfile = o.Keyfile
+	}
+	if info.Signed {
+		opts.Signer = o.Signer
+	}
+	return opts
+}
+
+// checkSigner refuses to rekey signed envelopes without a signing key, the
+// rekeyed version would silently lose its signature
+func (o rekeyOptions) checkSigner(info *cryptdecrypt.Info) error {
+	if info.Signed && o.Signer == nil && !o.DryRun {
+		return fmt.Errorf("signed envelope, give -signing-key to sign the rekeyed version")
+	}
+	return nil
+}
+
+// rekeyFiles rekeys every file, reports the result of each to report and
+// returns the number of files that failed. A failure does not stop the
+// remaining files.
+func rekeyFiles(report io.Writer, files []string, o rekeyOptions) int {
+	failed := 0
+	for _, path := range files {
+		desc, err := rekeyFile(path, o)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(report, "%s: %s: ERROR: %v\n", path, desc, err)
+		case o.DryRun:
+			fmt.Fprintf(report, "%s: %s: would be rekeyed\n", path, desc)
+		default:
+			fmt.Fprintf(report, "%s: %s: rekeyed\n", path, desc)
+		}
+	}
+	return failed
+}
+
+// rekeyFile re-encrypts the file at path with the new password. The file is
+// replaced atomically and plaintext never touches the disk. The returned
+// string describes the detected format for the report.
+func rekeyFile(path string, o rekeyOptions) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := cryptdecrypt.Inspect(f)
+	f.Close()
+
+	if err == nil {
+		switch info.Format {
+		case cryptdecrypt.FormatAge:
+			return info.String(), fmt.Errorf("encrypted to public keys, there is no password to change")
+		case cryptdecrypt.FormatEnvelope:
+			return rekeyEnvelopeFile(path, info, o)
+		case cryptdecrypt.FormatOpenSSL:
+			return rekeyOpenSSLFile(path, info, o)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if doc, err := cryptdecrypt.ParseSecrets(path, data); err == nil && doc.Metadata() != "" {
+		return rekeySecretsFile(path, doc, o)
+	}
+
+	desc, rekeyed, err := rekeyText(string(data), o)
+	if err != nil || o.DryRun {
+		return desc, err
+	}
+	return desc, writeFileAtomic(path, []byte(rekeyed+"\n"), 0600)
+}
+
+// rekeyEnvelopeFile rekeys a binary or armored envelope, streamed ones chunk
+// by chunk
+func rekeyEnvelopeFile(path string, info *cryptdecrypt.Info, o rekeyOptions) (string, error) {
+	desc := info.String()
+	if err := o.checkSigner(info); err != nil {
+		return desc, err
+	}
+	decrypt := func(dst io.Writer) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return cryptdecrypt.DecryptWith(dst, f, o.credentials())
+	}
+	if o.DryRun {
+		return desc, decrypt(io.Discard)
+	}
+
+	opts := o.encryptOptions(info)
+	return desc, replaceFileAtomic(path, 0600, func(w io.Writer) error {
+		if info.Armored {
+			aw, err := cryptdecrypt.NewArmorWriter(w)
+			if err != nil {
+				return err
+			}
+			if err := reencrypt(aw, decrypt, info, o.New, opts); err != nil {
+				return err
+			}
+			return aw.Close()
+		}
+		return reencrypt(w, decrypt, info, o.New, opts)
+	})
+}
+
+// rekeyOpenSSLFile rekeys a binary OpenSSL file, which stays readable by
+// openssl with the new password and the same options
+func rekeyOpenSSLFile(path string, info *cryptdecrypt.Info, o rekeyOptions) (string, error) {
+	desc := info.String()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return desc, err
+	}
+	plaintext, err := cryptdecrypt.DecryptOpenSSL(data, o.Old, o.OpenSSL)
+	if err != nil || o.DryRun {
+		return desc, err
+	}
+	rekeyed, err := cryptdecrypt.EncryptOpenSSL(plaintext, o.New, o.OpenSSL)
+	if err != nil {
+		return desc, err
+	}
+	return desc, writeFileAtomic(path, rekeyed, 0600)
+}
+
+// reencrypt writes the plaintext produced by decrypt to w as a new envelope
+// of the same kind as the described one
+func reencrypt(w io.Writer, decrypt func(io.Writer) error, info *cryptdecrypt.Info, password string, opts *cryptdecrypt.Options) error {
+	if !info.Streamed {
+		var plaintext bytes.Buffer
+		if err := decrypt(&plaintext); err != nil {
+			return err
+		}
+		envelope, err := cryptdecrypt.Seal(plaintext.Bytes(), password, opts)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(envelope)
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(decrypt(pw))
+	}()
+	err := cryptdecrypt.Encrypt(w, pr, password, opts)
+	pr.CloseWithError(err)
+	return err
+}
+
+// rekeyText rekeys an armored or Base64 envelope, OpenSSL Base64 or a legacy
+// 'salt:ciphertext' string and returns the new envelope in the same form.
+// Legacy strings are upgraded to an authenticated Base64 envelope.
+func rekeyText(text string, o rekeyOptions) (string, string, error) {
+	info, err := cryptdecrypt.InspectText(text)
+	if err != nil {
+		return "unknown format", "", fmt.Errorf("not an encrypted file or string: %v", err)
+	}
+	desc := info.String()
+	if info.Format == cryptdecrypt.FormatAge {
+		return desc, "", fmt.Errorf("encrypted to public keys, there is no password to change")
+	}
+	if err := o.checkSigner(info); err != nil {
+		return desc, "", err
+	}
+
+	plaintext, err := cryptdecrypt.DecryptTextWith(text, o.credentials())
+	if err != nil || o.DryRun {
+		return desc, "", err
+	}
+	if info.Format == cryptdecrypt.FormatOpenSSL {
+		opts := *o.OpenSSL
+		opts.Base64 = true
+		rekeyed, err := cryptdecrypt.EncryptOpenSSL(plaintext, o.New, &opts)
+		return desc, strings.TrimSpace(string(rekeyed)), err
+	}
+	if info.Armored {
+		envelope, err := cryptdecrypt.Seal(plaintext, o.New, o.encryptOptions(info))
+		if err != nil {
+			return desc, "", err
+		}
+		return desc, strings.TrimSpace(cryptdecrypt.ArmorEnvelope(envelope)), nil
+	}
+	rekeyed, err := cryptdecrypt.EncryptText(plaintext, o.New, o.encryptOptions(info))
+	return desc, rekeyed, err
+}
+
+// rekeySecretsFile re-encrypts all values of a secrets file with a key
+// derived from the new password
+func rekeySecretsFile(path string, doc cryptdecrypt.SecretsFile, o rekeyOptions) (string, error) {
+	desc := "secrets file"
+	key, err := cryptdecrypt.OpenSecretsKey(doc, o.Old)
+	if err != nil {
+		return desc, err
+	}
+	desc = fmt.Sprintf("secrets file (%s)", key.KDF())
+	if _, err := cryptdecrypt.DecryptSecrets(doc, key); err != nil || o.DryRun {
+		return desc, err
+	}
+
+	kdf := key.KDF()
+	if o.KDF != nil {
+		kdf = *o.KDF
+	}
+	newKey, err := cryptdecrypt.NewSecretsKey(o.New, kdf)
+	if err != nil {
+		return desc, err
+	}
+	if err := cryptdecrypt.EncryptSecrets(doc, newKey, nil); err != nil {
+		return desc, err
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		return desc, err
+	}
+	return desc, writeFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// fastKDF returns cheap parameters, the defaults take a noticeable time
+func fastKDF(t *testing.T) cryptdecrypt.KDFParams {
+	t.Helper()
+	kdf, err := cryptdecrypt.NewKDFParams("scrypt", 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kdf
+}
+
+// rekeyFixture writes one file of every supported kind to a new directory
+// and returns the paths by kind
+func rekeyFixture(t *testing.T, signer *cryptdecrypt.SigningKey) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	opts := &cryptdecrypt.Options{KDF: fastKDF(t)}
+	files := make(map[string]string)
+	write := func(kind, name string, data []byte, perm os.FileMode) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, perm); err != nil {
+			t.Fatal(err)
+		}
+		files[kind] = path
+	}
+
+	var streamed bytes.Buffer
+	if err := cryptdecrypt.Encrypt(&streamed, bytes.NewReader(bytes.Repeat([]byte("plaintext "), 20000)), "old", opts); err != nil {
+		t.Fatal(err)
+	}
+	write("streamed", "streamed.enc", streamed.Bytes(), 0640)
+
+	envelope, err := cryptdecrypt.Seal([]byte("plaintext"), "old", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("single-shot", "single.enc", envelope, 0600)
+	write("armored", "armored.txt", []byte(cryptdecrypt.ArmorEnvelope(envelope)), 0600)
+
+	text, err := cryptdecrypt.EncryptText([]byte("plaintext"), "old", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("base64", "base64.txt", []byte(text+"\n"), 0600)
+
+	openssl, err := cryptdecrypt.EncryptOpenSSL([]byte("plaintext"), "old", &cryptdecrypt.OpenSSLOptions{Iterations: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("openssl", "openssl.bin", openssl, 0600)
+
+	doc, err := cryptdecrypt.ParseSecrets("app.env", []byte("KEY=plaintext\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cryptdecrypt.NewSecretsKey("old", fastKDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cryptdecrypt.EncryptSecrets(doc, key, nil); err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("secrets", "app.env", secrets, 0600)
+
+	if signer != nil {
+		signed, err := cryptdecrypt.Seal([]byte("plaintext"), "old", &cryptdecrypt.Options{KDF: fastKDF(t), Signer: signer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		write("signed", "signed.enc", signed, 0600)
+	}
+	return files
+}
+
+// decryptsWith reports whether the file at path decrypts with password
+func decryptsWith(t *testing.T, path, password string) bool {
+	t.Helper()
+	if filepath.Ext(path) == ".env" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := cryptdecrypt.ParseSecrets(path, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cryptdecrypt.OpenSecretsKey(doc, password)
+		return err == nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	creds := cryptdecrypt.Credentials{Password: password, OpenSSL: &cryptdecrypt.OpenSSLOptions{Iterations: 1000}}
+	return cryptdecrypt.DecryptWith(&bytes.Buffer{}, f, creds) == nil
+}
+
+func newRekeyOptions() rekeyOptions {
+	return rekeyOptions{Old: "old", New: "new", OpenSSL: &cryptdecrypt.OpenSSLOptions{Iterations: 1000}}
+}
+
+func TestRekeyFiles(t *testing.T) {
+	files := rekeyFixture(t, nil)
+	var paths []string
+	for _, path := range files {
+		paths = append(paths, path)
+	}
+
+	var report bytes.Buffer
+	if failed := rekeyFiles(&report, paths, newRekeyOptions()); failed != 0 {
+		t.Fatalf("%d files failed:\n%s", failed, report.String())
+	}
+	for kind, path := range files {
+		if !decryptsWith(t, path, "new") || decryptsWith(t, path, "old") {
+			t.Errorf("%s: not rekeyed", kind)
+		}
+		if !strings.Contains(report.String(), path+": ") {
+			t.Errorf("%s: missing in the report:\n%s", kind, report.String())
+		}
+	}
+
+	// The form of the file and its permissions are kept
+	if info, err := os.Stat(files["streamed"]); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("streamed: %v, %v, want mode 0640", info, err)
+	}
+	for _, kind := range []string{"streamed", "armored"} {
+		f, err := os.Open(files[kind])
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := cryptdecrypt.Inspect(f)
+		f.Close()
+		if err != nil || info.Streamed != (kind == "streamed") || info.Armored != (kind == "armored") {
+			t.Errorf("%s: rekeyed to %v, %v", kind, info, err)
+		}
+	}
+	if data, err := os.ReadFile(files["base64"]); err != nil || bytes.Count(data, []byte("\n")) != 1 {
+		t.Errorf("base64: rekeyed to %q, %v", data, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(files["streamed"]))
+	if err != nil || len(entries) != len(files) {
+		t.Errorf("temporary files were left behind: %v, %v", entries, err)
+	}
+}
+
+func TestRekeyDryRun(t *testing.T) {
+	files := rekeyFixture(t, nil)
+	o := newRekeyOptions()
+	o.New, o.DryRun = "", true
+
+	for kind, path := range files {
+		before, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report bytes.Buffer
+		if failed := rekeyFiles(&report, []string{path}, o); failed != 0 || !strings.Contains(report.String(), "would be rekeyed") {
+			t.Errorf("%s: %d failed, report %q", kind, failed, report.String())
+		}
+		if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, before) {
+			t.Errorf("%s: the dry run changed the file", kind)
+		}
+	}
+
+	// The old password is still checked
+	o.Old = "wrong"
+	var paths []string
+	for _, path := range files {
+		paths = append(paths, path)
+	}
+	var report bytes.Buffer
+	if failed := rekeyFiles(&report, paths, o); failed != len(files) {
+		t.Errorf("%d of %d files failed with a wrong password:\n%s", failed, len(files), report.String())
+	}
+}
+
+// Failed files are left untouched and do not stop the others
+func TestRekeyFailures(t *testing.T) {
+	signer, err := cryptdecrypt.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := rekeyFixture(t, signer)
+	dir := filepath.Dir(files["streamed"])
+
+	// A streamed envelope whose last chunk is damaged fails after the
+	// temporary file already received the first chunks
+	damaged := filepath.Join(dir, "damaged.enc")
+	data, err := os.ReadFile(files["streamed"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(damaged, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := cryptdecrypt.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ageFile bytes.Buffer
+	if err := cryptdecrypt.EncryptToRecipients(&ageFile, strings.NewReader("plaintext"), []*cryptdecrypt.Recipient{id.Recipient()}); err != nil {
+		t.Fatal(err)
+	}
+	agePath := filepath.Join(dir, "file.age")
+	if err := os.WriteFile(agePath, ageFile.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(plain, []byte("not encrypted\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := []string{damaged, files["signed"], agePath, plain, filepath.Join(dir, "missing")}
+	contents := make(map[string][]byte)
+	for _, path := range failing {
+		contents[path], _ = os.ReadFile(path)
+	}
+	var report bytes.Buffer
+	paths := append([]string{files["base64"]}, failing...)
+	if failed := rekeyFiles(&report, paths, newRekeyOptions()); failed != len(failing) {
+		t.Errorf("%d files failed, want %d:\n%s", failed, len(failing), report.String())
+	}
+	if !decryptsWith(t, files["base64"], "new") {
+		t.Error("the file before the failures was not rekeyed")
+	}
+	if !strings.Contains(report.String(), "give -signing-key") {
+		t.Errorf("the signed envelope was not refused:\n%s", report.String())
+	}
+	for _, path := range failing {
+		if data, _ := os.ReadFile(path); !bytes.Equal(data, contents[path]) {
+			t.Errorf("%s was changed", path)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s was left behind", e.Name())
+		}
+	}
+
+	// With the signing key the signed envelope is rekeyed and signed again
+	o := newRekeyOptions()
+	o.Signer = signer
+	if _, err := rekeyFile(files["signed"], o); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(files["signed"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sig, err := cryptdecrypt.DecryptSigned(&bytes.Buffer{}, f, cryptdecrypt.Credentials{Password: "new"})
+	if err != nil || sig == nil || !sig.Signer.Equal(signer.Public()) {
+		t.Errorf("rekeyed signed envelope: %v, %v", sig, err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// runSecrets implements 'secrets encrypt|decrypt|edit|exec'
+func runSecrets(args []string) {
+	if len(args) == 0 || *secretsFile == "" {
+		fmt.Println("Usage: cryptdecrypt secrets encrypt|decrypt|edit -file FILE")
+		fmt.Println("       cryptdecrypt secrets exec -file FILE -- command [args...]")
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "encrypt":
+		err = secretsEncrypt()
+	case "decrypt":
+		err = secretsDecrypt()
+	case "edit":
+		err = secretsEdit()
+	case "exec":
+		err = secretsExec(args[1:])
+	default:
+		err = fmt.Errorf("unknown secrets command %q", args[0])
+	}
+	if err != nil {
+		log.Fatalf("Secrets error: %v", err)
+	}
+}
+
+// loadSecrets reads and parses the secrets file
+func loadSecrets(path string) (cryptdecrypt.SecretsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := cryptdecrypt.ParseSecrets(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return doc, nil
+}
+
+// secretsKeyFor returns the key of an encrypted document, or a new key with
+// a confirmed password if the document was never encrypted
+func secretsKeyFor(doc cryptdecrypt.SecretsFile) (*cryptdecrypt.SecretsKey, error) {
+	kdf, err := cryptdecrypt.NewKDFParams(*kdfName, *kdfMemory, *kdfTime, *kdfThreads)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Metadata() == "" {
+		pass, err := passwordFlags().read("New password: ", true)
+		if err != nil {
+			return nil, err
+		}
+		return cryptdecrypt.NewSecretsKey(pass, kdf)
+	}
+	pass, err := passwordFlags().read("Password: ", false)
+	if err != nil {
+		return nil, err
+	}
+	return cryptdecrypt.OpenSecretsKey(doc, pass)
+}
+
+// secretsEncrypt encrypts all plaintext values, in place unless -out is given
+func secretsEncrypt() error {
+	doc, err := loadSecrets(*secretsFile)
+	if err != nil {
+		return err
+	}
+	key, err := secretsKeyFor(doc)
+	if err != nil {
+		return err
+	}
+	if err := cryptdecrypt.EncryptSecrets(doc, key, nil); err != nil {
+		return err
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		return err
+	}
+	if flagGiven("out") {
+		return writeOutput(*outPath, data)
+	}
+	return writeFileAtomic(*secretsFile, data, 0600)
+}
+
+// secretsDecrypt writes the decrypted file to -out (stdout by default)
+func secretsDecrypt() error {
+	doc, err := loadSecrets(*secretsFile)
+	if err != nil {
+		return err
+	}
+	if err := openSecrets(doc); err != nil {
+		return err
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		return err
+	}
+	return writeOutput(*outPath, data)
+}
+
+// openSecrets asks for the password and decrypts all values of doc
+func openSecrets(doc cryptdecrypt.SecretsFile) error {
+	if doc.Metadata() == "" {
+		return fmt.Errorf("%s is not encrypted", *secretsFile)
+	}
+	pass, err := passwordFlags().read("Password: ", false)
+	if err != nil {
+		return err
+	}
+	key, err := cryptdecrypt.OpenSecretsKey(doc, pass)
+	if err != nil {
+		return err
+	}
+	_, err = cryptdecrypt.DecryptSecrets(doc, key)
+	return err
+}
+
+// secretsEdit opens a decrypted copy in $EDITOR and re-encrypts the result.
+// Values that were not changed keep their ciphertext, so diffs only show the
+// edited entries.
+func secretsEdit() error {
+	doc, err := loadSecrets(*secretsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		doc, err = cryptdecrypt.ParseSecrets(*secretsFile, nil)
+	}
+	if err != nil {
+		return err
+	}
+	key, err := secretsKeyFor(doc)
+	if err != nil {
+		return err
+	}
+	var previous cryptdecrypt.DecryptedValues
+	if doc.Metadata() != "" {
+		if previous, err = cryptdecrypt.DecryptSecrets(doc, key); err != nil {
+			return err
+		}
+	}
+	plaintext, err := doc.Marshal()
+	if err != nil {
+		return err
+	}
+
+	edited, err := editInTempFile(filepath.Ext(*secretsFile), plaintext)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plaintext) && previous != nil {
+		fmt.Fprintln(os.Stderr, "No changes.")
+		return nil
+	}
+
+	doc, err = cryptdecrypt.ParseSecrets(*secretsFile, edited)
+	if err != nil {
+		return fmt.Errorf("edited file: %v", err)
+	}
+	doc.StripMetadata()
+	if err := cryptdecrypt.EncryptSecrets(doc, key, previous); err != nil {
+		return err
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*secretsFile, data, 0600)
+}
+
+// editInTempFile lets the user edit data and returns the result. The file is
+// created in memory-backed /dev/shm and removed afterwards.
+func editInTempFile(ext string, data []byte) ([]byte, error) {
+	dir, err := plaintextTempDir("/dev/shm", *insecureTemp)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, "cryptdecrypt-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], tmp.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor: %v", err)
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+// plaintextTempDir returns shm if it is a directory. Otherwise the decrypted
+// copy would end up on disk, where removing it does not erase the data, so
+// the default temporary directory is only returned if allowDisk is set.
+func plaintextTempDir(shm string, allowDisk bool) (string, error) {
+	if info, err := os.Stat(shm); err == nil && info.IsDir() {
+		return shm, nil
+	}
+	if !allowDisk {
+		return "", fmt.Errorf("%s is not available, the decrypted copy would be written to disk; use -insecure-temp to allow it", shm)
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s is not available, the decrypted copy is written to %s and may be recoverable from the disk.\n", shm, os.TempDir())
+	return os.TempDir(), nil
+}
+
+// secretsExec runs a command with the decrypted values in its environment.
+// Nested YAML keys become upper-case names joined by '_', e.g.
+// database.password becomes DATABASE_PASSWORD.
+func secretsExec(command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("no command given, use: secrets exec -file FILE -- command [args...]")
+	}
+	doc, err := loadSecrets(*secretsFile)
+	if err != nil {
+		return err
+	}
+	if err := openSecrets(doc); err != nil {
+		return err
+	}
+
+	env := os.Environ()
+	ext := strings.ToLower(filepath.Ext(*secretsFile))
+	isYAML := ext == ".yaml" || ext == ".yml"
+	for _, e := range doc.Entries() {
+		name := e.Path
+		if isYAML {
+			name = strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+		}
+		env = append(env, name+"="+e.Value)
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlaintextTempDir(t *testing.T) {
+	shm := t.TempDir()
+	if dir, err := plaintextTempDir(shm, false); err != nil || dir != shm {
+		t.Errorf("plaintextTempDir(existing) = %q, %v, want %q", dir, err, shm)
+	}
+	missing := filepath.Join(shm, "missing")
+	if dir, err := plaintextTempDir(missing, false); err == nil {
+		t.Errorf("plaintextTempDir(missing) = %q, want an error", dir)
+	}
+	if dir, err := plaintextTempDir(missing, true); err != nil || dir != os.TempDir() {
+		t.Errorf("plaintextTempDir(missing, allowDisk) = %q, %v, want %q", dir, err, os.TempDir())
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+const (
+	// maxServeRequest limits the body of one request
+	maxServeRequest = 16 * 1024 * 1024
+
+	// minTokenLength rejects tokens that are easy to guess
+	minTokenLength = 16
+)
+
+// serveOps are the endpoints of serve, each a POST of {"text": "..."}
+var serveOps = []string{"encrypt", "decrypt", "rekey"}
+
+// serveClient is one line of the tokens file: name, token and the allowed
+// operations
+type serveClient struct {
+	name  string
+	token string
+	ops   map[string]bool
+}
+
+// serveRequest is the body of every request
+type serveRequest struct {
+	Text string `json:"text"`
+	Data string `json:"data,omitempty"` // Base64 plaintext for encrypt, instead of text
+}
+
+// serveResponse is the body of every response
+type serveResponse struct {
+	Text   string `json:"text,omitempty"`
+	Data   string `json:"data,omitempty"` // Base64 plaintext that is not valid UTF-8
+	Signer string `json:"signer,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditEntry is one line of the audit log. It never contains request or
+// response bodies.
+type auditEntry struct {
+	Time   string `json:"time"`
+	Client string `json:"client,omitempty"`
+	Op     string `json:"op"`
+	Remote string `json:"remote,omitempty"`
+	Status int    `json:"status"`
+	Signer string `json:"signer,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditLog writes audit entries as JSON Lines
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (a *auditLog) write(e auditEntry) {
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(e); err != nil {
+		log.Printf("Audit log error: %v", err)
+	}
+}
+
+// server holds the unlocked key and the clients allowed to use it
+type server struct {
+	session *cryptdecrypt.Session
+	clients []serveClient
+	audit   *auditLog
+}
+
+// runServe unlocks the key once and serves encrypt, decrypt and rekey
+// requests over a Unix socket or localhost HTTP until interrupted
+func runServe() {
+	if *tokensFile == "" {
+		log.Fatalf("Serve error: -tokens-file is required, every client needs a token")
+	}
+	clients, err := loadClients(*tokensFile)
+	if err != nil {
+		log.Fatalf("Serve error: %v", err)
+	}
+
+	kdf, err := cryptdecrypt.NewKDFParams(*kdfName, *kdfMemory, *kdfTime, *kdfThreads)
+	if err != nil {
+		log.Fatalf("Invalid KDF parameters: %v", err)
+	}
+	cipherID, err := cryptdecrypt.ParseCipher(*cipherName)
+	if err != nil {
+		log.Fatalf("Invalid cipher: %v", err)
+	}
+	// Encryption cannot detect a mistyped password, so the prompt asks twice
+	pass, err := passwordFlags().read("Password: ", true)
+	if err != nil {
+		log.Fatalf("Password error: %v", err)
+	}
+	keyfile, err := loadKeyfile()
+	if err != nil {
+		log.Fatalf("Keyfile error: %v", err)
+	}
+	signer, err := loadSigningKey()
+	if err != nil {
+		log.Fatalf("Invalid signing key: %v", err)
+	}
+	signers, err := loadSigners()
+	if err != nil {
+		log.Fatalf("Invalid signers: %v", err)
+	}
+
+	audit := io.Writer(os.Stderr)
+	if *auditPath != "" {
+		file, err := os.OpenFile(*auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatalf("Serve error: %v", err)
+		}
+		defer file.Close()
+		audit = file
+	}
+
+	listener, err := listen(*listenAddr)
+	if err != nil {
+		log.Fatalf("Serve error: %v", err)
+	}
+
+	// The KDF parameters of decrypted envelopes come from the clients, they
+	// may not make the service derive keys more expensive than its own
+	s := &server{
+		session: cryptdecrypt.NewSession(
+			cryptdecrypt.Credentials{Password: pass, Keyfile: keyfile, Signers: signers, MaxKDF: &kdf},
+			&cryptdecrypt.Options{Cipher: cipherID, KDF: kdf, Signer: signer},
+		),
+		clients: clients,
+		audit:   &auditLog{enc: json.NewEncoder(audit)},
+	}
+	mux := http.NewServeMux()
+	for _, op := range serveOps {
+		mux.HandleFunc("/"+op, s.handler(op))
+	}
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %s for %d clients on %s\n", strings.Join(serveOps, ", "), len(clients), *listenAddr)
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Serve error: %v", err)
+	}
+}
+
+// listen opens a Unix socket for "unix:PATH", otherwise a TCP address that
+// must be on the loopback interface
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// A socket left behind by a crashed server is removed, a running
+		// server is not replaced
+		if _, err := os.Stat(path); err == nil {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s is in use by another server", path)
+			}
+			os.Remove(path)
+		}
+		listener, err := listenUnix(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s is not a loopback address, the service is for local clients only", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// loadClients reads the tokens file: one client per line as
+// 'NAME TOKEN [OP,OP...]', all operations if none are listed
+func loadClients(path string) ([]serveClient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var clients []serveClient
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected 'NAME TOKEN [OP,OP...]'", path, number)
+		}
+		c := serveClient{name: fields[0], token: fields[1], ops: map[string]bool{}}
+		if len(c.token) < minTokenLength {
+			return nil, fmt.Errorf("%s:%d: token of %s is shorter than %d characters", path, number, c.name, minTokenLength)
+		}
+		// The token alone identifies the client, so it must be unique
+		if other, ok := tokens[c.token]; ok {
+			return nil, fmt.Errorf("%s:%d: %s has the same token as %s", path, number, c.name, other)
+		}
+		tokens[c.token] = c.name
+		ops := serveOps
+		if len(fields) == 3 {
+			ops = strings.Split(fields[2], ",")
+		}
+		for _, op := range ops {
+			if !isServeOp(op) {
+				return nil, fmt.Errorf("%s:%d: unknown operation %q, use %s", path, number, op, strings.Join(serveOps, ", "))
+			}
+			c.ops[op] = true
+		}
+		clients = append(clients, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("%s contains no clients", path)
+	}
+	return clients, nil
+}
+
+func isServeOp(op string) bool {
+	for _, o := range serveOps {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the client of the bearer token in r, nil if there is none.
+// Every token is compared in constant time.
+func (s *server) client(r *http.Request) *serveClient {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	var found *serveClient
+	for i := range s.clients {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.clients[i].token)) == 1 {
+			found = &s.clients[i]
+		}
+	}
+	return found
+}
+
+// handler returns the HTTP handler of one operation
+func (s *server) handler(op string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := auditEntry{Op: op, Remote: r.RemoteAddr}
+		reply := func(status int, resp serveResponse) {
+			entry.Status = status
+			entry.Error = resp.Error
+			s.audit.write(entry)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		client := s.client(r)
+		if client == nil {
+			reply(http.StatusUnauthorized, serveResponse{Error: "missing or unknown token"})
+			return
+		}
+		entry.Client = client.name
+		if !client.ops[op] {
+			reply(http.StatusForbidden, serveResponse{Error: fmt.Sprintf("%s may not %s", client.name, op)})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			reply(http.StatusMethodNotAllowed, serveResponse{Error: "use POST"})
+			return
+		}
+
+		var req serveRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequest)).Decode(&req); err != nil {
+			reply(http.StatusBadRequest, serveResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		resp, err := s.process(op, req)
+		if err != nil {
+			reply(http.StatusUnprocessableEntity, serveResponse{Error: err.Error()})
+			return
+		}
+		entry.Signer = resp.Signer
+		reply(http.StatusOK, resp)
+	}
+}
+
+// process runs one operation with the unlocked key. rekey decrypts any text
+// form under the password and encrypts it again with the current salt and
+// algorithms, e.g. to upgrade legacy strings.
+func (s *server) process(op string, req serveRequest) (serveResponse, error) {
+	if op == "encrypt" {
+		plaintext, err := requestPlaintext(req.Text, req.Data)
+		if err != nil {
+			return serveResponse{}, err
+		}
+		envelope, err := s.session.EncryptText(plaintext)
+		return serveResponse{Text: envelope}, err
+	}
+	if req.Data != "" {
+		return serveResponse{}, fmt.Errorf("%s takes an encrypted text, not data", op)
+	}
+
+	plaintext, sig, err := s.session.DecryptText(req.Text)
+	if err != nil {
+		return serveResponse{}, err
+	}
+	var resp serveResponse
+	if sig != nil {
+		resp.Signer = sig.Signer.String()
+	}
+	if op == "decrypt" {
+		resp.Text, resp.Data = responsePlaintext(plaintext)
+		return resp, nil
+	}
+	resp.Text, err = s.session.EncryptText(plaintext)
+	return resp, err
+}
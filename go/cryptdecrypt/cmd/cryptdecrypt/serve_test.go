@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+const (
+	adminToken  = "admin-token-0123456789"
+	backupToken = "backup-token-0123456789"
+)
+
+// newTestServer returns a server for the clients admin, allowed every
+// operation, and backup, allowed to encrypt, and the buffer of its audit log
+func newTestServer(t *testing.T) (*httptest.Server, *bytes.Buffer) {
+	t.Helper()
+	kdf := fastKDF(t)
+	var audit bytes.Buffer
+	s := &server{
+		session: cryptdecrypt.NewSession(
+			cryptdecrypt.Credentials{Password: "serve", MaxKDF: &kdf},
+			&cryptdecrypt.Options{KDF: kdf},
+		),
+		clients: []serveClient{
+			{name: "admin", token: adminToken, ops: map[string]bool{"encrypt": true, "decrypt": true, "rekey": true}},
+			{name: "backup", token: backupToken, ops: map[string]bool{"encrypt": true}},
+		},
+		audit: &auditLog{enc: json.NewEncoder(&audit)},
+	}
+	mux := http.NewServeMux()
+	for _, op := range serveOps {
+		mux.HandleFunc("/"+op, s.handler(op))
+	}
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, &audit
+}
+
+// call sends one request and decodes the response
+func call(t *testing.T, ts *httptest.Server, method, op, token, body string) (int, serveResponse) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+"/"+op, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r serveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatalf("%s /%s: invalid response: %v", method, op, err)
+	}
+	return resp.StatusCode, r
+}
+
+func textBody(text string) string {
+	body, _ := json.Marshal(serveRequest{Text: text})
+	return string(body)
+}
+
+func TestServeStatus(t *testing.T) {
+	ts, audit := newTestServer(t)
+	expensive, err := cryptdecrypt.EncryptText([]byte("value"), "serve", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, op, token, body string
+		status                        int
+	}{
+		{"no token", http.MethodPost, "encrypt", "", textBody("value"), http.StatusUnauthorized},
+		{"unknown token", http.MethodPost, "encrypt", "unknown-token-0123456789", textBody("value"), http.StatusUnauthorized},
+		{"token prefix", http.MethodPost, "encrypt", adminToken[:len(adminToken)-1], textBody("value"), http.StatusUnauthorized},
+		{"operation not allowed", http.MethodPost, "decrypt", backupToken, textBody("value"), http.StatusForbidden},
+		{"GET", http.MethodGet, "encrypt", adminToken, "", http.StatusMethodNotAllowed},
+		{"invalid body", http.MethodPost, "encrypt", adminToken, "text", http.StatusBadRequest},
+		{"not an envelope", http.MethodPost, "decrypt", adminToken, textBody("value"), http.StatusUnprocessableEntity},
+		{"expensive KDF", http.MethodPost, "decrypt", adminToken, textBody(expensive), http.StatusUnprocessableEntity},
+		{"allowed", http.MethodPost, "encrypt", backupToken, textBody("value"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit.Reset()
+			status, resp := call(t, ts, tt.method, tt.op, tt.token, tt.body)
+			if status != tt.status {
+				t.Errorf("status %d, want %d (%q)", status, tt.status, resp.Error)
+			}
+			if (status == http.StatusOK) != (resp.Error == "") {
+				t.Errorf("status %d with error %q", status, resp.Error)
+			}
+
+			var entry auditEntry
+			if err := json.Unmarshal(audit.Bytes(), &entry); err != nil {
+				t.Fatalf("audit log %q: %v", audit, err)
+			}
+			if entry.Op != tt.op || entry.Status != status {
+				t.Errorf("audit entry %+v", entry)
+			}
+		})
+	}
+}
+
+func TestServeRoundTrip(t *testing.T) {
+	ts, audit := newTestServer(t)
+	const secret = "do not log me"
+
+	status, encrypted := call(t, ts, http.MethodPost, "encrypt", backupToken, textBody(secret))
+	if status != http.StatusOK {
+		t.Fatalf("encrypt: %d %q", status, encrypted.Error)
+	}
+	status, rekeyed := call(t, ts, http.MethodPost, "rekey", adminToken, textBody(encrypted.Text))
+	if status != http.StatusOK || rekeyed.Text == encrypted.Text {
+		t.Fatalf("rekey: %d %q", status, rekeyed.Error)
+	}
+	status, decrypted := call(t, ts, http.MethodPost, "decrypt", adminToken, textBody(rekeyed.Text))
+	if status != http.StatusOK || decrypted.Text != secret {
+		t.Fatalf("decrypt: %d %q %q", status, decrypted.Text, decrypted.Error)
+	}
+	call(t, ts, http.MethodPost, "decrypt", backupToken, textBody(encrypted.Text))
+
+	// The audit log names every request, but never a text
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("%d audit lines, want 4", len(lines))
+	}
+	for _, text := range []string{secret, encrypted.Text, rekeyed.Text} {
+		if strings.Contains(audit.String(), text) {
+			t.Errorf("audit log contains %q", text)
+		}
+	}
+	for i, want := range []string{"backup", "admin", "admin", "backup"} {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil || entry.Client != want {
+			t.Errorf("audit line %d = %s, want client %s", i, lines[i], want)
+		}
+	}
+}
+
+func TestServeBinary(t *testing.T) {
+	ts, _ := newTestServer(t)
+	data := base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 'k', 0x00, 0x80})
+	dataBody := func(text, data string) string {
+		body, _ := json.Marshal(serveRequest{Text: text, Data: data})
+		return string(body)
+	}
+
+	status, encrypted := call(t, ts, http.MethodPost, "encrypt", adminToken, dataBody("", data))
+	if status != http.StatusOK {
+		t.Fatalf("encrypt: %d %q", status, encrypted.Error)
+	}
+	status, rekeyed := call(t, ts, http.MethodPost, "rekey", adminToken, textBody(encrypted.Text))
+	if status != http.StatusOK {
+		t.Fatalf("rekey: %d %q", status, rekeyed.Error)
+	}
+	for _, text := range []string{encrypted.Text, rekeyed.Text} {
+		status, decrypted := call(t, ts, http.MethodPost, "decrypt", adminToken, textBody(text))
+		if status != http.StatusOK || decrypted.Text != "" || decrypted.Data != data {
+			t.Fatalf("decrypt: %d %+v, want data %q", status, decrypted, data)
+		}
+	}
+
+	for name, body := range map[string]string{
+		"text and data":  dataBody("text", data),
+		"invalid Base64": dataBody("", "not base64!"),
+	} {
+		if status, resp := call(t, ts, http.MethodPost, "encrypt", adminToken, body); status != http.StatusUnprocessableEntity {
+			t.Errorf("%s: %d %q", name, status, resp.Error)
+		}
+	}
+	if status, resp := call(t, ts, http.MethodPost, "decrypt", adminToken, dataBody("", data)); status != http.StatusUnprocessableEntity {
+		t.Errorf("decrypt with data: %d %q", status, resp.Error)
+	}
+}
+
+func TestListenLoopbackOnly(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", "192.0.2.1:8377", ":8377", "example.com:8377"} {
+		if l, err := listen(addr); err == nil {
+			l.Close()
+			t.Errorf("listen(%q) accepted", addr)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1:0", "localhost:0"} {
+		l, err := listen(addr)
+		if err != nil {
+			t.Errorf("listen(%q): %v", addr, err)
+			continue
+		}
+		l.Close()
+	}
+}
+
+func TestLoadClients(t *testing.T) {
+	tests := []struct {
+		name, content string
+		clients       map[string]string // name to comma-separated operations
+		error         string
+	}{
+		{
+			name:    "valid",
+			content: "# comment\n\nadmin " + adminToken + "\n  backup " + backupToken + " encrypt,rekey  \n",
+			clients: map[string]string{"admin": "decrypt,encrypt,rekey", "backup": "encrypt,rekey"},
+		},
+		{name: "empty", content: "# only a comment\n", error: "contains no clients"},
+		{name: "name only", content: "admin\n", error: ":1: expected"},
+		{name: "too many fields", content: "admin " + adminToken + " encrypt rekey\n", error: ":1: expected"},
+		{name: "short token", content: "admin short\n", error: "shorter than"},
+		{name: "unknown operation", content: "admin " + adminToken + " encrypt,sign\n", error: `unknown operation "sign"`},
+		{name: "duplicate token", content: "admin " + adminToken + "\nbackup " + adminToken + " encrypt\n", error: ":2: backup has the same token as admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			clients, err := loadClients(path)
+			if tt.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.error) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, c := range clients {
+				var ops []string
+				for _, op := range serveOps {
+					if c.ops[op] {
+						ops = append(ops, op)
+					}
+				}
+				slices.Sort(ops)
+				got[c.name] = strings.Join(ops, ",")
+			}
+			if len(got) != len(tt.clients) {
+				t.Fatalf("clients %v, want %v", got, tt.clients)
+			}
+			for name, ops := range tt.clients {
+				if got[name] != ops {
+					t.Errorf("%s may %q, want %q", name, got[name], ops)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxShareSize limits the secret, the pad makes the link as long as
+	// the secret
+	maxShareSize = 4096
+
+	// shareIDLength is the number of random bytes in the path of a link
+	shareIDLength = 16
+)
+
+// shareScript runs in the browser. The pad never reaches the server: the
+// fragment is not sent with requests and the script only removes it from
+// the address bar after the secret was shown.
+const shareScript = `
+const decode = s => Uint8Array.from(atob(s.replace(/-/g, "+").replace(/_/g, "/")), c => c.charCodeAt(0));
+const pad = location.hash.slice(1);
+const status = document.getElementById("status");
+const reveal = document.getElementById("reveal");
+if (pad === "") {
+	status.textContent = "The link is incomplete, the part after # is missing.";
+	reveal.disabled = true;
+}
+reveal.onclick = async () => {
+	reveal.disabled = true;
+	const resp = await fetch(location.pathname, {method: "POST"});
+	if (!resp.ok) {
+		status.textContent = await resp.text();
+		return;
+	}
+	const data = decode(await resp.text());
+	const key = decode(pad);
+	history.replaceState(null, "", location.pathname);
+	if (data.length !== key.length) {
+		status.textContent = "The link is damaged, the secret cannot be decrypted.";
+		return;
+	}
+	document.getElementById("secret").textContent = new TextDecoder().decode(data.map((b, i) => b ^ key[i]));
+	status.textContent = "This was the only view, the link no longer works. Copy the secret now.";
+};
+`
+
+// shareCSP only allows the inline script above and requests to the server
+var shareCSP = fmt.Sprintf("default-src 'none'; style-src 'unsafe-inline'; connect-src 'self'; script-src 'sha256-%s'",
+	base64.StdEncoding.EncodeToString(func() []byte { h := sha256.Sum256([]byte(shareScript)); return h[:] }()))
+
+// sharePage asks for a click before the secret is fetched, so link
+// previews of chat programs do not use up the one view
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="referrer" content="no-referrer">
+<title>cryptdecrypt secret</title>
+<style>body { font-family: sans-serif; max-width: 40em; margin: 2em auto; } pre { white-space: pre-wrap; word-break: break-all; background: #eee; padding: 1em; }</style>
+</head>
+<body>
+<p>Someone shared a secret with you. It can be shown exactly once.</p>
+<button id="reveal">Reveal secret</button>
+<noscript><p>JavaScript is required, the secret is decrypted in the browser.</p></noscript>
+<p id="status"></p>
+<pre id="secret"></pre>
+<script>{{.}}</script>
+</body>
+</html>
+`))
+
+// sharedSecret is the encrypted secret of one link, gone after the first
+// view
+type sharedSecret struct {
+	mu         sync.Mutex
+	path       string
+	ciphertext []byte
+	revealed   chan string // receives the address of the viewer
+}
+
+// runShare serves -text or -in once over HTTP on the local network. The
+// secret is encrypted with a one-time pad of the same length that is only
+// part of the link's fragment, so the server and the network never see it
+// in plain. WebCrypto is unavailable on plain HTTP, a pad needs nothing but
+// XOR in the browser.
+func runShare() {
+	secret, err := readShareSecret()
+	if err != nil {
+		log.Fatalf("Share error: %v", err)
+	}
+	s, pad, err := newSharedSecret(secret)
+	if err != nil {
+		log.Fatalf("Share error: %v", err)
+	}
+
+	// Colleagues need to reach the link, so the default is a random port
+	// on all interfaces instead of the loopback address of serve
+	addr := ":0"
+	if flagGiven("listen") {
+		addr = *listenAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Share error: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.path, s)
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	links, err := shareLinks(listener.Addr().(*net.TCPAddr), s.path+"#"+base64.RawURLEncoding.EncodeToString(pad))
+	clear(pad)
+	if err != nil {
+		log.Fatalf("Share error: %v", err)
+	}
+	for _, link := range links {
+		fmt.Println(link)
+	}
+	if *qrPath != "" {
+		if err := writeQR(*qrPath, links[0]); err != nil {
+			log.Fatalf("QR code error: %v", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "The link can be opened once within %s. Waiting...\n", *shareExpiry)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	viewer, err := s.wait(ctx, *shareExpiry, serveErr)
+
+	// Let the response to the viewer finish before exiting
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpServer.Shutdown(shutdown)
+
+	if err != nil {
+		log.Fatalf("Share error: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Revealed to %s, the link no longer works.\n", viewer)
+}
+
+// newSharedSecret encrypts secret with a new one-time pad of the same
+// length and a random path. It returns the pad for the link and clears
+// secret.
+func newSharedSecret(secret []byte) (*sharedSecret, []byte, error) {
+	pad := make([]byte, len(secret))
+	id := make([]byte, shareIDLength)
+	if _, err := rand.Read(pad); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, err
+	}
+	ciphertext := make([]byte, len(secret))
+	subtle.XORBytes(ciphertext, secret, pad)
+	clear(secret)
+	return &sharedSecret{
+		path:       "/s/" + base64.RawURLEncoding.EncodeToString(id),
+		ciphertext: ciphertext,
+		revealed:   make(chan string, 1),
+	}, pad, nil
+}
+
+// readShareSecret reads the text to share from -text or -in
+func readShareSecret() ([]byte, error) {
+	var secret []byte
+	switch {
+	case *text != "" && *inPath != "":
+		return nil, fmt.Errorf("use either -text or -in")
+	case *text != "":
+		secret = []byte(*text)
+	case *inPath != "":
+		in, err := openInput(*inPath)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		if secret, err = io.ReadAll(io.LimitReader(in, maxShareSize+1)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("the secret to share is required as -text or -in")
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the secret is empty")
+	}
+	if len(secret) > maxShareSize {
+		return nil, fmt.Errorf("the secret is larger than %d bytes, encrypt files with crypt instead", maxShareSize)
+	}
+	if !utf8.Valid(secret) {
+		return nil, fmt.Errorf("only text can be shown in the browser, encrypt binary files with crypt instead")
+	}
+	return secret, nil
+}
+
+// shareLinks returns the links for addr. A listener on all interfaces gets
+// one link for every address of the local network.
+func shareLinks(addr *net.TCPAddr, pathAndFragment string) ([]string, error) {
+	port := strconv.Itoa(addr.Port)
+	if !addr.IP.IsUnspecified() {
+		return []string{"http://" + net.JoinHostPort(addr.IP.String(), port) + pathAndFragment}, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		links = append(links, "http://"+net.JoinHostPort(ipNet.IP.String(), port)+pathAndFragment)
+	}
+	if len(links) == 0 {
+		return nil, errors.New("no network address found, use -listen")
+	}
+	return links, nil
+}
+
+// ServeHTTP shows the page on GET and hands out the ciphertext on the first
+// POST
+func (s *sharedSecret) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Security-Policy", shareCSP)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		sharePage.Execute(w, template.JS(shareScript))
+	case http.MethodPost:
+		s.mu.Lock()
+		ciphertext := s.ciphertext
+		s.ciphertext = nil
+		s.mu.Unlock()
+		if ciphertext == nil {
+			http.Error(w, "The secret was already revealed.", http.StatusGone)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, base64.RawURLEncoding.EncodeToString(ciphertext))
+		clear(ciphertext)
+		s.revealed <- r.RemoteAddr
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Use GET or POST.", http.StatusMethodNotAllowed)
+	}
+}
+
+// wait returns the address of the viewer once the secret was revealed. The
+// secret is destroyed in any case, also when the link expires, ctx is done
+// or the server fails with an error from serveErr.
+func (s *sharedSecret) wait(ctx context.Context, expiry time.Duration, serveErr <-chan error) (string, error) {
+	defer s.destroy()
+	select {
+	case viewer := <-s.revealed:
+		return viewer, nil
+	case err := <-serveErr:
+		return "", fmt.Errorf("%v, the secret is destroyed", err)
+	case <-time.After(expiry):
+	case <-ctx.Done():
+	}
+	return "", errors.New("the link was not opened, the secret is destroyed")
+}
+
+// destroy overwrites the ciphertext if it was not revealed
+func (s *sharedSecret) destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.ciphertext)
+	s.ciphertext = nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newShareServer serves a new shared secret and returns it with the pad
+func newShareServer(t *testing.T, secret string) (*httptest.Server, *sharedSecret, []byte) {
+	t.Helper()
+	s, pad, err := newSharedSecret([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(s.path, s)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, s, pad
+}
+
+// request sends a request without a body and returns the status and body
+func request(t *testing.T, ts *httptest.Server, method, path string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound && resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("%s: Cache-Control %q", method, resp.Header.Get("Cache-Control"))
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestShareRevealOnce(t *testing.T) {
+	const secret = "the secret ÿ"
+	ts, s, pad := newShareServer(t, secret)
+
+	// Viewing the page, e.g. by a link preview, does not use up the secret
+	for i := 0; i < 2; i++ {
+		status, page := request(t, ts, http.MethodGet, s.path)
+		if status != http.StatusOK || !strings.Contains(page, "Reveal secret") || strings.Contains(page, secret) {
+			t.Fatalf("GET: %d %q", status, page)
+		}
+	}
+	if status, _ := request(t, ts, http.MethodPut, s.path); status != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: %d", status)
+	}
+	if status, _ := request(t, ts, http.MethodPost, "/s/other"); status != http.StatusNotFound {
+		t.Errorf("POST to another path: %d", status)
+	}
+
+	status, body := request(t, ts, http.MethodPost, s.path)
+	if status != http.StatusOK {
+		t.Fatalf("first POST: %d %q", status, body)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || len(ciphertext) != len(pad) {
+		t.Fatalf("ciphertext %q: %v", body, err)
+	}
+	if string(ciphertext) == secret {
+		t.Fatal("the secret was sent in plain")
+	}
+	plaintext := make([]byte, len(pad))
+	subtle.XORBytes(plaintext, ciphertext, pad)
+	if string(plaintext) != secret {
+		t.Errorf("ciphertext XOR pad = %q, want %q", plaintext, secret)
+	}
+
+	viewer, err := s.wait(context.Background(), time.Minute, nil)
+	if err != nil || viewer == "" {
+		t.Errorf("wait = %q, %v", viewer, err)
+	}
+	if status, _ := request(t, ts, http.MethodPost, s.path); status != http.StatusGone {
+		t.Errorf("second POST: %d, want %d", status, http.StatusGone)
+	}
+}
+
+func TestShareDestroyed(t *testing.T) {
+	// Expiry, an interrupt and a failed server all destroy the secret
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := make(chan error, 1)
+	failed <- errors.New("listener closed")
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		serveErr chan error
+		error    string
+	}{
+		{"expired", context.Background(), nil, "not opened"},
+		{"interrupted", canceled, nil, "not opened"},
+		{"server failed", context.Background(), failed, "listener closed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, s, _ := newShareServer(t, "secret")
+			viewer, err := s.wait(tt.ctx, 10*time.Millisecond, tt.serveErr)
+			if err == nil || viewer != "" || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("wait = %q, %v, want an error containing %q", viewer, err, tt.error)
+			}
+			if s.ciphertext != nil {
+				t.Error("the ciphertext was kept")
+			}
+			if status, _ := request(t, ts, http.MethodPost, s.path); status != http.StatusGone {
+				t.Errorf("POST after destroy: %d, want %d", status, http.StatusGone)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// loadSigningKey reads -signing-key, nil if it is not given
+func loadSigningKey() (*cryptdecrypt.SigningKey, error) {
+	if *signingKeyPath == "" {
+		return nil, nil
+	}
+	keys, err := readKeyFile(*signingKeyPath, cryptdecrypt.ParseSigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// loadSigners collects the trusted signers from -signer and -signers-file
+func loadSigners() ([]*cryptdecrypt.VerifyingKey, error) {
+	var signers []*cryptdecrypt.VerifyingKey
+	for _, s := range signerFlags {
+		signer, err := cryptdecrypt.ParseVerifyingKey(s)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	if *signersFile != "" {
+		fromFile, err := readKeyFile(*signersFile, cryptdecrypt.ParseVerifyingKeys)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, fromFile...)
+	}
+	return signers, nil
+}
+
+// reportSignature tells on stderr who signed decrypted data
+func reportSignature(sig *cryptdecrypt.Signature, trusted bool) {
+	switch {
+	case sig == nil:
+	case trusted:
+		fmt.Fprintf(os.Stderr, "Good signature from %s\n", sig.Signer)
+	default:
+		fmt.Fprintf(os.Stderr, "Valid signature from %s, not checked against trusted signers (-signer, -signers-file)\n", sig.Signer)
+	}
+}
+
+// runSign writes a detached signature FILE.sig for every file, or prints the
+// signature of standard input for "-"
+func runSign(files []string) {
+	if len(files) == 0 || *signingKeyPath == "" {
+		fmt.Println("Usage: cryptdecrypt sign -signing-key KEY FILE... ('-' signs stdin)")
+		os.Exit(1)
+	}
+	key, err := loadSigningKey()
+	if err != nil {
+		log.Fatalf("Invalid signing key: %v", err)
+	}
+
+	for _, path := range files {
+		in, err := openInput(path)
+		if err != nil {
+			log.Fatalf("Sign error: %v", err)
+		}
+		sig, err := cryptdecrypt.Sign(in, key)
+		in.Close()
+		if err != nil {
+			log.Fatalf("Sign error: %s: %v", path, err)
+		}
+		if path == "-" {
+			fmt.Print(sig)
+			continue
+		}
+		if err := writeFileAtomic(path+".sig", []byte(sig.String()), 0644); err != nil {
+			log.Fatalf("Sign error: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: signature written to %s.sig\n", path, path)
+	}
+}
+
+// runVerify checks the detached signatures of the files against the
+// trusted signers
+func runVerify(files []string) {
+	if len(files) == 0 || (*sigPath != "" && len(files) != 1) {
+		fmt.Println("Usage: cryptdecrypt verify -signer KEY|-signers-file FILE [-sig FILE.sig] FILE...")
+		os.Exit(1)
+	}
+	signers, err := loadSigners()
+	if err != nil {
+		log.Fatalf("Invalid signers: %v", err)
+	}
+	if len(signers) == 0 {
+		log.Fatalf("Verify error: no trusted signers, use -signer or -signers-file")
+	}
+
+	// Report every file and continue after failures
+	failed := 0
+	for _, path := range files {
+		sig, err := verifyFile(path, signers)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: ERROR: %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: good signature from %s\n", path, sig.Signer)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d signatures could not be verified.", failed, len(files))
+	}
+}
+
+// verifyFile checks the signature of the file at path, read from -sig or
+// path.sig
+func verifyFile(path string, signers []*cryptdecrypt.VerifyingKey) (*cryptdecrypt.Signature, error) {
+	sigFile := *sigPath
+	if sigFile == "" {
+		if path == "-" {
+			return nil, fmt.Errorf("standard input needs -sig")
+		}
+		sigFile = path + ".sig"
+	}
+	text, err := os.ReadFile(sigFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("signature file %s not found", sigFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sig, err := cryptdecrypt.ParseSignature(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", sigFile, err)
+	}
+
+	in, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return sig, cryptdecrypt.Verify(in, sig, signers)
+}
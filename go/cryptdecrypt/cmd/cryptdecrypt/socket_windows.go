@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "net"
+
+// listenUnix creates a Unix socket, Windows has no umask to restrict it
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+	"golang.org/x/term"
+)
+
+// runVault implements 'vault init|set|get|list|rm'
+func runVault(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cryptdecrypt vault init|list [-vault FILE]")
+		fmt.Println("       cryptdecrypt vault set|get|rm NAME [-vault FILE]")
+		os.Exit(1)
+	}
+	path, err := vaultPath()
+	if err != nil {
+		log.Fatalf("Vault error: %v", err)
+	}
+
+	command, names := args[0], args[1:]
+	switch command {
+	case "init", "list":
+		if len(names) != 0 {
+			log.Fatalf("Vault error: %s takes no name", command)
+		}
+	case "set", "get", "rm":
+		if len(names) != 1 || names[0] == "" {
+			log.Fatalf("Vault error: %s needs exactly one NAME", command)
+		}
+	default:
+		log.Fatalf("Vault error: unknown vault command %q", command)
+	}
+
+	switch command {
+	case "init":
+		err = vaultInit(path)
+	case "set":
+		err = vaultSet(path, names[0])
+	case "get":
+		err = vaultGet(path, names[0])
+	case "list":
+		err = vaultList(path)
+	case "rm":
+		err = vaultRemove(path, names[0])
+	}
+	if err != nil {
+		log.Fatalf("Vault error: %v", err)
+	}
+}
+
+// vaultPath returns the vault file from -vault, $CRYPTDECRYPT_VAULT or the
+// default in the home directory
+func vaultPath() (string, error) {
+	if *vaultFile != "" {
+		return *vaultFile, nil
+	}
+	if path := os.Getenv("CRYPTDECRYPT_VAULT"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".cryptdecrypt.vault"), nil
+}
+
+// withVaultLock runs f while holding the lock of the vault at path. The lock
+// is a separate file, as the vault itself is replaced on every change.
+func withVaultLock(path string, f func() error) error {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("locking %s: %v", path, err)
+	}
+	return f()
+}
+
+// vaultCredentials reads the master password and the keyfile
+func vaultCredentials(prompt string, confirm bool) (cryptdecrypt.Credentials, error) {
+	pass, err := passwordFlags().read(prompt, confirm)
+	if err != nil {
+		return cryptdecrypt.Credentials{}, err
+	}
+	keyfile, err := loadKeyfile()
+	if err != nil {
+		return cryptdecrypt.Credentials{}, err
+	}
+	return cryptdecrypt.Credentials{Password: pass, Keyfile: keyfile}, nil
+}
+
+// openVault reads and decrypts the vault at path
+func openVault(path string, creds cryptdecrypt.Credentials) (*cryptdecrypt.Vault, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s does not exist, create it with 'cryptdecrypt vault init'", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cryptdecrypt.OpenVault(data, creds)
+}
+
+// updateVault opens the vault under its lock, applies change and writes the
+// result atomically, so concurrent changes are neither lost nor interleaved
+func updateVault(path string, creds cryptdecrypt.Credentials, change func(*cryptdecrypt.Vault) error) error {
+	return withVaultLock(path, func() error {
+		v, err := openVault(path, creds)
+		if err != nil {
+			return err
+		}
+		if err := change(v); err != nil {
+			return err
+		}
+		data, err := v.Marshal()
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data, 0600)
+	})
+}
+
+// vaultInit creates a new, empty vault
+func vaultInit(path string) error {
+	kdf, err := cryptdecrypt.NewKDFParams(*kdfName, *kdfMemory, *kdfTime, *kdfThreads)
+	if err != nil {
+		return err
+	}
+	creds, err := vaultCredentials("New master password: ", true)
+	if err != nil {
+		return err
+	}
+	checkStrength(creds.Password)
+	v, err := cryptdecrypt.NewVault(creds.Password, &cryptdecrypt.Options{KDF: kdf, Keyfile: creds.Keyfile})
+	if err != nil {
+		return err
+	}
+	data, err := v.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = withVaultLock(path, func() error {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+		return writeFileAtomic(path, data, 0600)
+	})
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Vault created at %s\n", path)
+	}
+	return err
+}
+
+// vaultSet stores a value from -text, standard input or a hidden prompt
+func vaultSet(path, name string) error {
+	creds, err := vaultCredentials("Master password: ", false)
+	if err != nil {
+		return err
+	}
+	value, err := readVaultValue(name)
+	if err != nil {
+		return err
+	}
+	return updateVault(path, creds, func(v *cryptdecrypt.Vault) error {
+		v.Set(name, value)
+		return nil
+	})
+}
+
+// readVaultValue reads the value to store, prompting twice on a terminal
+func readVaultValue(name string) (string, error) {
+	if *text != "" {
+		return *text, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+	}
+	return promptPassword(fmt.Sprintf("Value of %s: ", name), true)
+}
+
+// vaultGet prints the value of one entry
+func vaultGet(path, name string) error {
+	creds, err := vaultCredentials("Master password: ", false)
+	if err != nil {
+		return err
+	}
+	v, err := openVault(path, creds)
+	if err != nil {
+		return err
+	}
+	e, ok := v.Get(name)
+	if !ok {
+		return fmt.Errorf("no entry %q", name)
+	}
+	fmt.Println(e.Value)
+	return nil
+}
+
+// vaultList prints the names and modification times of all entries
+func vaultList(path string) error {
+	creds, err := vaultCredentials("Master password: ", false)
+	if err != nil {
+		return err
+	}
+	v, err := openVault(path, creds)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.List() {
+		fmt.Printf("%-30s %s\n", e.Name, e.Modified.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// vaultRemove deletes one entry
+func vaultRemove(path, name string) error {
+	creds, err := vaultCredentials("Master password: ", false)
+	if err != nil {
+		return err
+	}
+	return updateVault(path, creds, func(v *cryptdecrypt.Vault) error {
+		if !v.Delete(name) {
+			return fmt.Errorf("no entry %q", name)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,282 @@
+// Package cryptdecrypt encrypts data with a password or to age X25519 public
+// keys. It is the library behind the cryptdecrypt command.
+//
+// Password-based data is written as a versioned envelope that stores the
+// cipher, the KDF parameters and the salt, so it can always be decrypted with
+// nothing but the password. Encrypt and Decrypt stream data of any size in
+// authenticated chunks; Seal, EncryptText and DecryptText work on small values
+// in memory. ArmorEnvelope and NewArmorWriter turn envelopes into PEM-like
+// text with a checksum. Data encrypted to public keys uses the age v1 file
+// format. Sign and Verify handle detached Ed25519 signatures, and
+// Options.Signer embeds one in an envelope for DecryptSigned to verify.
+// EncryptOpenSSL and DecryptOpenSSL exchange data with 'openssl enc', which
+// Decrypt also recognizes.
+//
+// Decryption errors can be told apart with errors.Is:
+//
+//	err := cryptdecrypt.Decrypt(dst, src, password)
+//	switch {
+//	case errors.Is(err, cryptdecrypt.ErrWrongPassword):
+//	case errors.Is(err, cryptdecrypt.ErrCorrupted):
+//	case errors.Is(err, cryptdecrypt.ErrUnsupportedVersion):
+//	}
+package cryptdecrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrWrongPassword is returned when the key derived from the password
+	// does not authenticate any data. An authenticated cipher cannot tell
+	// this apart from a modified header or first chunk, which therefore
+	// also results in ErrWrongPassword.
+	ErrWrongPassword = errors.New("wrong password")
+
+	// ErrCorrupted is returned for data that is truncated, modified after
+	// the first chunk, or not encrypted by this package at all
+	ErrCorrupted = errors.New("corrupted data")
+
+	// ErrUnsupportedVersion is returned for data written by a newer version
+	// with a format version, flag or algorithm this version does not know
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+
+	// ErrNoIdentity is returned when data encrypted to public keys cannot be
+	// decrypted with any of the given identities
+	ErrNoIdentity = errors.New("no matching identity")
+)
+
+// Credentials are the secrets that can unlock encrypted data
+type Credentials struct {
+	Password   string
+	Keyfile    []byte // contents of the keyfile, see Options.Keyfile
+	Identities []*Identity
+
+	// Signers are the trusted signers. If set, decryption fails with
+	// ErrUnknownSigner unless the data carries a signature by one of them.
+	Signers []*VerifyingKey
+
+	// OpenSSL selects the key derivation for data in the OpenSSL format,
+	// nil selects the defaults of 'openssl enc -pbkdf2'
+	OpenSSL *OpenSSLOptions
+
+	// MaxKDF, if set, refuses envelopes whose key derivation needs more
+	// memory or work than these parameters with ErrKDFLimit, e.g. in a
+	// service that decrypts data sent by its clients
+	MaxKDF *KDFParams
+}
+
+// Encrypt reads plaintext from src until EOF and writes a streamed envelope
+// to dst. Memory use is bounded by the chunk size. A nil opts selects the
+// defaults.
+func Encrypt(dst io.Writer, src io.Reader, password string, opts *Options) error {
+	return encryptStream(dst, src, password, opts.withDefaults())
+}
+
+// Seal encrypts a small plaintext in memory and returns the binary envelope
+func Seal(plaintext []byte, password string, opts *Options) ([]byte, error) {
+	return sealEnvelope(plaintext, password, opts.withDefaults())
+}
+
+// EncryptText returns the envelope of plaintext in Base64, as printed by the
+// command for -text
+func EncryptText(plaintext []byte, password string, opts *Options) (string, error) {
+	envelope, err := Seal(plaintext, password, opts)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// EncryptToRecipients encrypts src to the public keys and writes a binary
+// age file to dst. Use Armor for a text version.
+func EncryptToRecipients(dst io.Writer, src io.Reader, recipients []*Recipient) error {
+	return encryptAge(dst, src, recipients)
+}
+
+// Decrypt decrypts an envelope written by Encrypt or Seal. If an error is
+// returned after the first chunk, dst has already received the plaintext of
+// the chunks before it and must be discarded.
+func Decrypt(dst io.Writer, src io.Reader, password string) error {
+	return DecryptWith(dst, src, Credentials{Password: password})
+}
+
+// DecryptWith detects whether src is an envelope or an age file, binary or
+// in one of the text forms, and decrypts it with the matching credentials
+func DecryptWith(dst io.Writer, src io.Reader, creds Credentials) error {
+	_, err := DecryptSigned(dst, src, creds)
+	return err
+}
+
+// DecryptSigned is DecryptWith that also returns the verified signature of
+// a signed envelope, nil for unsigned data
+func DecryptSigned(dst io.Writer, src io.Reader, creds Credentials) (*Signature, error) {
+	sig, err := decryptStream(dst, src, creds)
+	if err != nil {
+		return nil, err
+	}
+	return sig, checkSigned(sig, creds)
+}
+
+// checkSigned fails for unsigned data if trusted signers are required.
+// Signatures themselves are verified during decryption.
+func checkSigned(sig *Signature, creds Credentials) error {
+	if sig == nil && len(creds.Signers) > 0 {
+		return fmt.Errorf("%w: data is not signed", ErrUnknownSigner)
+	}
+	return nil
+}
+
+// DecryptText decrypts an armored or Base64 envelope, an armored age file or
+// a legacy 'salt:ciphertext' string with a password
+func DecryptText(text, password string) ([]byte, error) {
+	return DecryptTextWith(text, Credentials{Password: password})
+}
+
+// DecryptTextWith is DecryptText with identities for armored age files
+func DecryptTextWith(text string, creds Credentials) ([]byte, error) {
+	plaintext, _, err := DecryptTextSigned(text, creds)
+	return plaintext, err
+}
+
+// DecryptTextSigned is DecryptTextWith that also returns the verified
+// signature of a signed envelope, nil for unsigned data
+func DecryptTextSigned(text string, creds Credentials) ([]byte, *Signature, error) {
+	plaintext, sig, err := decryptText(text, creds)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, sig, checkSigned(sig, creds)
+}
+
+// Format identifies how data was encrypted
+type Format int
+
+const (
+	FormatEnvelope Format = iota + 1 // password-based envelope
+	FormatAge                        // age file encrypted to public keys
+	FormatLegacy                     // unauthenticated 'salt:ciphertext' of early versions
+	FormatOpenSSL                    // unauthenticated 'openssl enc -aes-256-cbc' output
+)
+
+// String returns a short description of the format
+func (f Format) String() string {
+	switch f {
+	case FormatEnvelope:
+		return "envelope"
+	case FormatAge:
+		return "age file"
+	case FormatLegacy:
+		return "legacy salt:ciphertext (AES-CBC)"
+	case FormatOpenSSL:
+		return "OpenSSL Salted__ (AES-CBC)"
+	default:
+		return fmt.Sprintf("format %d", int(f))
+	}
+}
+
+// Info describes encrypted data without decrypting it
+type Info struct {
+	Format   Format
+	Cipher   Cipher    // envelopes only
+	KDF      KDFParams // envelopes and legacy data
+	Streamed bool      // envelope written by Encrypt rather than Seal
+	Keyfile  bool      // a keyfile is required in addition to the password
+	Armored  bool      // envelope in the armored text form
+	Signed   bool      // plaintext carries an embedded signature
+}
+
+// String describes the format and algorithms, e.g. for reports
+func (i *Info) String() string {
+	if i.Format != FormatEnvelope {
+		return i.Format.String()
+	}
+	desc := fmt.Sprintf("envelope (%s, %s)", i.Cipher, i.KDF)
+	if i.Streamed {
+		desc = "streamed " + desc
+	}
+	if i.Keyfile {
+		desc += " with keyfile"
+	}
+	if i.Signed {
+		desc = "signed " + desc
+	}
+	if i.Armored {
+		desc = "armored " + desc
+	}
+	return desc
+}
+
+// Options returns the options that encrypt new data like the described data.
+// Legacy data is upgraded to an envelope with the default cipher. The
+// Keyfile field has to be filled in if Keyfile is set.
+func (i *Info) Options() *Options {
+	return &Options{Cipher: i.Cipher, KDF: i.KDF}
+}
+
+// Inspect reads the beginning of binary encrypted data from r and describes
+// it. r is read past the header, so it cannot be passed to Decrypt afterwards.
+func Inspect(r io.Reader) (*Info, error) {
+	in := bufio.NewReader(r)
+	prefix, _ := in.Peek(len(envelopeArmorHeader))
+	if isAge(prefix) {
+		return &Info{Format: FormatAge}, nil
+	}
+	if bytes.HasPrefix(prefix, []byte(openSSLMagic)) {
+		return &Info{Format: FormatOpenSSL}, nil
+	}
+	if bytes.HasPrefix(prefix, []byte(envelopeArmorHeader)) {
+		text, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		return InspectText(string(text))
+	}
+	h, _, err := readHeader(in)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		Format:   FormatEnvelope,
+		Cipher:   h.Cipher,
+		KDF:      h.KDF,
+		Streamed: h.Flags&flagStream != 0,
+		Keyfile:  h.Flags&flagKeyfile != 0,
+		Signed:   h.Flags&flagSigned != 0,
+	}, nil
+}
+
+// InspectText describes the text forms accepted by DecryptText
+func InspectText(text string) (*Info, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(text, ageArmorHeader):
+		return &Info{Format: FormatAge}, nil
+	case strings.HasPrefix(text, envelopeArmorHeader):
+		data, err := dearmorEnvelope(text)
+		if err != nil {
+			return nil, err
+		}
+		info, err := Inspect(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		info.Armored = true
+		return info, nil
+	case strings.HasPrefix(text, openSSLBase64Prefix):
+		return &Info{Format: FormatOpenSSL}, nil
+	case strings.Contains(text, ":"):
+		return &Info{Format: FormatLegacy, KDF: defaultKDFParams}, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not an encrypted string", ErrCorrupted)
+	}
+	return Inspect(bytes.NewReader(data))
+}
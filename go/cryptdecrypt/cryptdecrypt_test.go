@@ -0,0 +1,416 @@
+package cryptdecrypt_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// fastKDF returns cheap parameters, the defaults take a noticeable time
+func fastKDF(t *testing.T) cryptdecrypt.KDFParams {
+	t.Helper()
+	kdf, err := cryptdecrypt.NewKDFParams("scrypt", 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kdf
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRecipients(t *testing.T) {
+	alice, err := cryptdecrypt.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := cryptdecrypt.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := cryptdecrypt.ParseRecipient(alice.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	plaintext := randomBytes(t, 70000)
+	if err := cryptdecrypt.EncryptToRecipients(&encrypted, bytes.NewReader(plaintext), []*cryptdecrypt.Recipient{recipient}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{encrypted.Bytes(), []byte(cryptdecrypt.Armor(encrypted.Bytes()))} {
+		var decrypted bytes.Buffer
+		creds := cryptdecrypt.Credentials{Identities: []*cryptdecrypt.Identity{bob, alice}}
+		if err := cryptdecrypt.DecryptWith(&decrypted, bytes.NewReader(data), creds); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Error("plaintext differs")
+		}
+
+		creds = cryptdecrypt.Credentials{Identities: []*cryptdecrypt.Identity{bob}}
+		err := cryptdecrypt.DecryptWith(&bytes.Buffer{}, bytes.NewReader(data), creds)
+		if !errors.Is(err, cryptdecrypt.ErrNoIdentity) {
+			t.Errorf("wrong identity: got %v, want ErrNoIdentity", err)
+		}
+	}
+}
+
+func TestKeyfile(t *testing.T) {
+	var keyfile, other bytes.Buffer
+	if err := cryptdecrypt.GenerateKeyfile(&keyfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := cryptdecrypt.GenerateKeyfile(&other); err != nil {
+		t.Fatal(err)
+	}
+	opts := &cryptdecrypt.Options{KDF: fastKDF(t), Keyfile: keyfile.Bytes()}
+	text, err := cryptdecrypt.EncryptText([]byte("secret"), "pw", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := cryptdecrypt.InspectText(text); err != nil || !info.Keyfile {
+		t.Errorf("InspectText = %v, %v, want a keyfile envelope", info, err)
+	}
+
+	plaintext, err := cryptdecrypt.DecryptTextWith(text, cryptdecrypt.Credentials{Password: "pw", Keyfile: keyfile.Bytes()})
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("DecryptTextWith = %q, %v", plaintext, err)
+	}
+	if _, err := cryptdecrypt.DecryptText(text, "pw"); !errors.Is(err, cryptdecrypt.ErrKeyfileRequired) {
+		t.Errorf("without keyfile: got %v, want ErrKeyfileRequired", err)
+	}
+	_, err = cryptdecrypt.DecryptTextWith(text, cryptdecrypt.Credentials{Password: "pw", Keyfile: other.Bytes()})
+	if !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+		t.Errorf("wrong keyfile: got %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestArmor(t *testing.T) {
+	plaintext := randomBytes(t, 100000)
+	var armored bytes.Buffer
+	w, err := cryptdecrypt.NewArmorWriter(&armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cryptdecrypt.Encrypt(w, bytes.NewReader(plaintext), "pw", &cryptdecrypt.Options{KDF: fastKDF(t)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := cryptdecrypt.Decrypt(&decrypted, bytes.NewReader(armored.Bytes()), "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("plaintext differs")
+	}
+	info, err := cryptdecrypt.InspectText(armored.String())
+	if err != nil || !info.Armored || !info.Streamed {
+		t.Errorf("InspectText = %v, %v, want a streamed armored envelope", info, err)
+	}
+
+	// A typo in the text is caught by the checksum before decryption
+	mistyped := append([]byte(nil), armored.Bytes()...)
+	i := bytes.IndexByte(mistyped, '\n') + 10
+	if mistyped[i] == 'A' {
+		mistyped[i] = 'B'
+	} else {
+		mistyped[i] = 'A'
+	}
+	if err := cryptdecrypt.Decrypt(&bytes.Buffer{}, bytes.NewReader(mistyped), "pw"); !errors.Is(err, cryptdecrypt.ErrCorrupted) {
+		t.Errorf("mistyped armor: got %v, want ErrCorrupted", err)
+	}
+}
+
+// Every text form written by crypt is accepted by DecryptText and, saved to
+// a file, by Decrypt
+func TestDecryptTextForms(t *testing.T) {
+	opts := &cryptdecrypt.Options{KDF: fastKDF(t)}
+	envelope, err := cryptdecrypt.Seal([]byte(legacyVectors[0].plaintext), "correct horse", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base64Text, err := cryptdecrypt.EncryptText([]byte(legacyVectors[0].plaintext), "correct horse", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, ciphertext, _ := strings.Cut(legacyVectors[0].text, ":")
+
+	for name, text := range map[string]string{
+		"armored":         cryptdecrypt.ArmorEnvelope(envelope),
+		"base64":          base64Text + "\n",
+		"salt:ciphertext": legacyVectors[0].text,
+		"two-line legacy": "Salt: " + salt + "\nCiphertext: " + ciphertext + "\n",
+	} {
+		plaintext, err := cryptdecrypt.DecryptText(text, "correct horse")
+		if err != nil || string(plaintext) != legacyVectors[0].plaintext {
+			t.Errorf("%s: DecryptText = %q, %v", name, plaintext, err)
+		}
+		var decrypted bytes.Buffer
+		err = cryptdecrypt.Decrypt(&decrypted, strings.NewReader(text), "correct horse")
+		if err != nil || decrypted.String() != legacyVectors[0].plaintext {
+			t.Errorf("%s: Decrypt = %q, %v", name, decrypted.String(), err)
+		}
+	}
+}
+
+func TestVault(t *testing.T) {
+	v, err := cryptdecrypt.NewVault("master", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Set("db", "secret")
+	v.Set("api", "token")
+	v.Delete("api")
+	data, err := v.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := cryptdecrypt.OpenVault(data, cryptdecrypt.Credentials{Password: "master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := opened.Get("db"); !ok || e.Value != "secret" || e.Name != "db" {
+		t.Errorf("Get(db) = %+v, %v", e, ok)
+	}
+	if entries := opened.List(); len(entries) != 1 {
+		t.Errorf("List() has %d entries, want 1", len(entries))
+	}
+	if _, err := cryptdecrypt.OpenVault(data, cryptdecrypt.Credentials{Password: "wrong"}); !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+		t.Errorf("wrong password: got %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	alice, err := cryptdecrypt.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := cryptdecrypt.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := cryptdecrypt.ParseSigningKey(alice.String())
+	if err != nil || !parsed.Public().Equal(alice.Public()) {
+		t.Fatalf("ParseSigningKey = %v, %v", parsed, err)
+	}
+	trusted := []*cryptdecrypt.VerifyingKey{alice.Public()}
+
+	// Detached
+	data := randomBytes(t, 1000)
+	sig, err := cryptdecrypt.Sign(bytes.NewReader(data), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = cryptdecrypt.ParseSignature(sig.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cryptdecrypt.Verify(bytes.NewReader(data), sig, trusted); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	data[0] ^= 1
+	if err := cryptdecrypt.Verify(bytes.NewReader(data), sig, trusted); !errors.Is(err, cryptdecrypt.ErrBadSignature) {
+		t.Errorf("modified data: got %v, want ErrBadSignature", err)
+	}
+
+	// Embedded, streamed across chunk boundaries and single-shot
+	for _, size := range []int{0, 65536 - 50, 65536, 150000} {
+		plaintext := randomBytes(t, size)
+		var encrypted bytes.Buffer
+		opts := &cryptdecrypt.Options{KDF: fastKDF(t), Signer: alice}
+		if err := cryptdecrypt.Encrypt(&encrypted, bytes.NewReader(plaintext), "pw", opts); err != nil {
+			t.Fatal(err)
+		}
+		var decrypted bytes.Buffer
+		sig, err := cryptdecrypt.DecryptSigned(&decrypted, bytes.NewReader(encrypted.Bytes()), cryptdecrypt.Credentials{Password: "pw", Signers: trusted})
+		if err != nil || sig == nil || !sig.Signer.Equal(alice.Public()) {
+			t.Fatalf("%d bytes: DecryptSigned = %v, %v", size, sig, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("%d bytes: plaintext differs", size)
+		}
+		creds := cryptdecrypt.Credentials{Password: "pw", Signers: []*cryptdecrypt.VerifyingKey{bob.Public()}}
+		if err := cryptdecrypt.DecryptWith(&bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()), creds); !errors.Is(err, cryptdecrypt.ErrUnknownSigner) {
+			t.Errorf("%d bytes, other signer: got %v, want ErrUnknownSigner", size, err)
+		}
+	}
+
+	text, err := cryptdecrypt.EncryptText([]byte("secret"), "pw", &cryptdecrypt.Options{KDF: fastKDF(t), Signer: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, sig, err := cryptdecrypt.DecryptTextSigned(text, cryptdecrypt.Credentials{Password: "pw"})
+	if err != nil || string(plaintext) != "secret" || !sig.Signer.Equal(alice.Public()) {
+		t.Errorf("DecryptTextSigned = %q, %v, %v", plaintext, sig, err)
+	}
+	unsigned, err := cryptdecrypt.EncryptText([]byte("secret"), "pw", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cryptdecrypt.DecryptTextWith(unsigned, cryptdecrypt.Credentials{Password: "pw", Signers: trusted}); !errors.Is(err, cryptdecrypt.ErrUnknownSigner) {
+		t.Errorf("unsigned with trusted signers: got %v, want ErrUnknownSigner", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	signer, err := cryptdecrypt.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := cryptdecrypt.Credentials{Password: "pw", Signers: []*cryptdecrypt.VerifyingKey{signer.Public()}}
+	s := cryptdecrypt.NewSession(creds, &cryptdecrypt.Options{KDF: fastKDF(t), Signer: signer})
+
+	// Concurrent use, every value must come back with a verified signature
+	envelopes := make([]string, 20)
+	errs := make(chan error, len(envelopes))
+	for i := range envelopes {
+		go func() {
+			var err error
+			envelopes[i], err = s.EncryptText([]byte(fmt.Sprint("value ", i)))
+			errs <- err
+		}()
+	}
+	for range envelopes {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if envelopes[0] == envelopes[1] {
+		t.Error("two envelopes are identical")
+	}
+	for i, envelope := range envelopes {
+		plaintext, sig, err := s.DecryptText(envelope)
+		if err != nil || string(plaintext) != fmt.Sprint("value ", i) || sig == nil {
+			t.Errorf("DecryptText(%d) = %q, %v, %v", i, plaintext, sig, err)
+		}
+	}
+
+	// Envelopes from a session are ordinary envelopes and vice versa
+	if _, _, err := cryptdecrypt.DecryptTextSigned(envelopes[0], creds); err != nil {
+		t.Errorf("DecryptTextSigned: %v", err)
+	}
+	other, err := cryptdecrypt.EncryptText([]byte("other"), "pw", &cryptdecrypt.Options{KDF: fastKDF(t), Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, _, err := s.DecryptText(other); err != nil || string(plaintext) != "other" {
+		t.Errorf("DecryptText(other) = %q, %v", plaintext, err)
+	}
+
+	wrong := cryptdecrypt.NewSession(cryptdecrypt.Credentials{Password: "wrong"}, nil)
+	if _, _, err := wrong.DecryptText(envelopes[0]); !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+		t.Errorf("wrong password: got %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestOpenSSL(t *testing.T) {
+	plaintext := []byte("shared with openssl")
+	for _, opts := range []*cryptdecrypt.OpenSSLOptions{
+		nil,
+		{KDF: "pbkdf2", Digest: "sha512", Iterations: 1000, Base64: true},
+		{KDF: "evp", Digest: "md5"},
+	} {
+		data, err := cryptdecrypt.EncryptOpenSSL(plaintext, "pw", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := cryptdecrypt.DecryptOpenSSL(data, "pw", opts)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%+v: DecryptOpenSSL = %q, %v", opts, got, err)
+		}
+		var decrypted bytes.Buffer
+		err = cryptdecrypt.DecryptWith(&decrypted, bytes.NewReader(data), cryptdecrypt.Credentials{Password: "pw", OpenSSL: opts})
+		if err != nil || !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("%+v: DecryptWith = %q, %v", opts, decrypted.Bytes(), err)
+		}
+	}
+
+	// Produced by: echo -n hello | openssl enc -aes-256-cbc -pbkdf2 -a -pass pass:pw
+	const fromOpenSSL = "U2FsdGVkX18SarYk5NBT8uAW43SlUDa+naqzcMALQYw="
+	got, err := cryptdecrypt.DecryptText(fromOpenSSL, "pw")
+	if err != nil || string(got) != "hello" {
+		t.Errorf("DecryptText(openssl) = %q, %v", got, err)
+	}
+	info, err := cryptdecrypt.InspectText(fromOpenSSL)
+	if err != nil || info.Format != cryptdecrypt.FormatOpenSSL {
+		t.Errorf("InspectText(openssl) = %v, %v", info, err)
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	pass, bits, err := cryptdecrypt.GeneratePassword(20, "abcabc0123456789")
+	if err != nil || len(pass) != 20 || strings.Trim(pass, "abc0123456789") != "" {
+		t.Fatalf("GeneratePassword = %q, %v", pass, err)
+	}
+	// Duplicates do not count: 13 characters
+	if want := 20 * math.Log2(13); math.Abs(bits-want) > 1e-9 {
+		t.Errorf("entropy %.2f, want %.2f", bits, want)
+	}
+	if _, _, err := cryptdecrypt.GeneratePassword(10, "aaa"); err == nil {
+		t.Error("a single character was accepted")
+	}
+
+	phrase, bits, err := cryptdecrypt.GeneratePassphrase(6, " ")
+	if err != nil || len(strings.Fields(phrase)) != 6 || bits < 77 || bits > 78 {
+		t.Errorf("GeneratePassphrase = %q, %.1f, %v", phrase, bits, err)
+	}
+}
+
+func TestEstimateStrength(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		max      float64 // upper bound of the estimate
+		warning  string
+	}{
+		{"password", 10, "common password"},
+		{"P@ssw0rd", 15, "common password"},
+		{"drowssap", 10, "common password"},
+		// Below the 60 bits the README suggests for -min-entropy
+		{"Tr0ub4dor&3", 45, "common password"},
+		{"abcdefgh", 10, "sequence like abc or 123"},
+		{"ertzuiop", 12, "keyboard pattern"},
+		{"24.12.1990", 25, "date or year"},
+		{strings.Repeat("xyz!", 100), 30, "repeated characters"},
+		// Beyond the analysed window, repeats are found as well
+		{strings.Repeat("a", 10000) + "b", 30, "repeated characters"},
+		{strings.Repeat("abc", 3333) + "b", 30, "repeated characters"},
+		{"password" + strings.Repeat("7", 1000), 30, "repeated characters"},
+	} {
+		s := cryptdecrypt.EstimateStrength(tc.password)
+		if s.Entropy > tc.max || !strings.Contains(strings.Join(s.Warnings, ","), tc.warning) {
+			t.Errorf("EstimateStrength(%q) = %v, want at most %.0f bits and %q", tc.password, s, tc.max, tc.warning)
+		}
+	}
+
+	// Random passwords and passphrases are rated by their actual entropy
+	pass, bits, _ := cryptdecrypt.GeneratePassword(20, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	if s := cryptdecrypt.EstimateStrength(pass); s.Entropy < bits-25 {
+		t.Errorf("EstimateStrength(%q) = %v, generated with %.0f bits", pass, s, bits)
+	}
+	long, bits, _ := cryptdecrypt.GeneratePassword(1000, "abcdefghijklmnopqrstuvwxyz")
+	if s := cryptdecrypt.EstimateStrength(long); s.Entropy < bits*0.9 {
+		t.Errorf("EstimateStrength(%d random letters) = %v, generated with %.0f bits", len(long), s, bits)
+	}
+	phrase, bits, _ := cryptdecrypt.GeneratePassphrase(6, "-")
+	if s := cryptdecrypt.EstimateStrength(phrase); s.Entropy < bits-5 || s.Entropy > bits+15 {
+		t.Errorf("EstimateStrength(%q) = %v, generated with %.0f bits", phrase, s, bits)
+	}
+}
@@ -0,0 +1,146 @@
+package cryptdecrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decryptStream detects the format of src, an envelope, an age file or one
+// of the text formats, and writes the plaintext to dst. It returns the
+// verified signature of signed envelopes.
+func decryptStream(dst io.Writer, src io.Reader, creds Credentials) (*Signature, error) {
+	in := bufio.NewReader(src)
+	prefix, _ := in.Peek(len(envelopeArmorHeader))
+
+	// OpenSSL data is not streamable, CBC padding is checked at the end
+	if bytes.HasPrefix(prefix, []byte(openSSLMagic)) {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := DecryptOpenSSL(data, creds.Password, creds.OpenSSL)
+		if err != nil {
+			return nil, err
+		}
+		_, err = dst.Write(plaintext)
+		return nil, err
+	}
+
+	// Text, e.g. saved output of -text, is small and decrypted in memory
+	if isText(prefix) {
+		text, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, sig, err := decryptText(string(text), creds)
+		if err != nil {
+			return nil, err
+		}
+		_, err = dst.Write(plaintext)
+		return sig, err
+	}
+
+	if !isAge(prefix) {
+		if creds.Password == "" {
+			return nil, fmt.Errorf("%w: data is encrypted with a password, but none was given", ErrWrongPassword)
+		}
+		return decryptEnvelope(dst, in, creds)
+	}
+
+	if bytes.HasPrefix(prefix, []byte(ageArmorHeader)) {
+		armored, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		data, err := dearmorAge(string(armored))
+		if err != nil {
+			return nil, err
+		}
+		in = bufio.NewReader(bytes.NewReader(data))
+	}
+	if len(creds.Identities) == 0 {
+		return nil, fmt.Errorf("%w: data is encrypted to public keys, but no identity was given", ErrNoIdentity)
+	}
+	return nil, decryptAge(dst, in, creds.Identities)
+}
+
+// isAge reports whether data starting with prefix is a binary or armored
+// age file
+func isAge(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(ageArmorHeader)) || bytes.HasPrefix(prefix, []byte(ageIntro[:len(ageIntro)-1]))
+}
+
+// isText reports whether data starting with prefix is an armored or Base64
+// envelope or legacy output, as opposed to a binary envelope or an age file.
+// The random salt of a binary envelope can contain a ':' anywhere, so the
+// magic is checked first.
+func isText(prefix []byte) bool {
+	if bytes.HasPrefix(prefix, []byte(envelopeMagic)) {
+		return false
+	}
+	return bytes.HasPrefix(prefix, []byte(envelopeArmorHeader)) ||
+		bytes.HasPrefix(prefix, []byte(envelopeBase64Prefix)) ||
+		bytes.HasPrefix(prefix, []byte(openSSLBase64Prefix)) ||
+		bytes.HasPrefix(prefix, []byte(legacySaltLabel)) ||
+		bytes.IndexByte(prefix, ':') == base64.StdEncoding.EncodedLen(saltLength)
+}
+
+// decryptText decrypts an armored or Base64 envelope, an armored age file or
+// a legacy 'salt:ciphertext' string
+func decryptText(text string, creds Credentials) ([]byte, *Signature, error) {
+	text = strings.TrimSpace(text)
+
+	var data []byte
+	var err error
+	switch {
+	case strings.HasPrefix(text, ageArmorHeader):
+		data = []byte(text)
+	case strings.HasPrefix(text, envelopeArmorHeader):
+		if data, err = dearmorEnvelope(text); err != nil {
+			return nil, nil, err
+		}
+	case strings.HasPrefix(text, openSSLBase64Prefix):
+		plaintext, err := DecryptOpenSSL([]byte(text), creds.Password, creds.OpenSSL)
+		return plaintext, nil, err
+	case strings.Contains(text, ":"):
+		// Base64 never contains ':', so a colon marks the legacy CBC format
+		salt, ciphertext, err := splitSaltCiphertext(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		plaintext, err := decryptLegacy(salt, ciphertext, creds.Password)
+		return plaintext, nil, err
+	default:
+		if data, err = base64.StdEncoding.DecodeString(text); err != nil {
+			return nil, nil, fmt.Errorf("%w: error decoding envelope: %v", ErrCorrupted, err)
+		}
+	}
+
+	var plaintext bytes.Buffer
+	sig, err := decryptStream(&plaintext, bytes.NewReader(data), creds)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext.Bytes(), sig, nil
+}
+
+// splitSaltCiphertext splits input in the format 'salt:ciphertext' or the
+// "Salt:" and "Ciphertext:" lines printed by early versions
+func splitSaltCiphertext(input string) (string, string, error) {
+	if rest, ok := strings.CutPrefix(input, legacySaltLabel); ok {
+		salt, ciphertext, ok := strings.Cut(rest, legacyCiphertextLabel)
+		if !ok {
+			return "", "", fmt.Errorf("%w: %q line missing", ErrCorrupted, legacyCiphertextLabel)
+		}
+		return strings.TrimSpace(salt), strings.TrimSpace(ciphertext), nil
+	}
+	salt, ciphertext, ok := strings.Cut(input, ":")
+	if !ok {
+		return "", "", fmt.Errorf("%w: text must be in 'salt:ciphertext' format", ErrCorrupted)
+	}
+	return salt, ciphertext, nil
+}
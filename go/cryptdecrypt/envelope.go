@@ -0,0 +1,282 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Envelope layout (integers are big-endian):
+//
+//	magic      4 bytes  "CDEN"
+//	version    1 byte   envelopeVersion
+//	flags      1 byte   combination of the flag* constants
+//	cipher     1 byte   AES256GCM or ChaCha20Poly1305
+//	kdf        1 byte   Scrypt or Argon2id
+//	kdf params 12 bytes scrypt: log2(N), r, p; Argon2id: time, memory, threads
+//	salt       1 byte length + salt
+//	nonce      1 byte length + nonce
+//	chunk size 4 bytes  only present if flagStream is set
+//	payload    AEAD ciphertext including the authentication tag, or a
+//	           sequence of such chunks if flagStream is set (see stream.go)
+//
+// The complete header is passed to the AEAD as additional data, so any change
+// to the algorithm, KDF parameters, salt or nonce makes decryption fail. With
+// flagKeyfile the key is derived from the password and a keyfile (see
+// keyfile.go).
+const (
+	envelopeMagic   = "CDEN"
+	envelopeVersion = 1
+)
+
+const (
+	saltLength = 16 // Length of the salt
+	keyLength  = 32 // AES-256 key length
+)
+
+// Envelope flags
+const (
+	flagStream  byte = 1 << 0 // payload is split into authenticated chunks
+	flagKeyfile byte = 1 << 1 // key is derived from password and keyfile
+	flagSigned  byte = 1 << 2 // plaintext ends with a signature (see sign.go)
+
+	knownFlags = flagStream | flagKeyfile | flagSigned
+)
+
+// Cipher identifies the authenticated cipher of an envelope
+type Cipher byte
+
+// Cipher identifiers stored in the envelope header
+const (
+	AES256GCM        Cipher = 1
+	ChaCha20Poly1305 Cipher = 2
+)
+
+// cipherNames maps the -cipher flag values to envelope cipher identifiers
+var cipherNames = map[string]Cipher{
+	"aes-256-gcm":       AES256GCM,
+	"chacha20-poly1305": ChaCha20Poly1305,
+}
+
+// ParseCipher returns the cipher for a name as accepted by -cipher
+func ParseCipher(name string) (Cipher, error) {
+	c, ok := cipherNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown cipher %q", name)
+	}
+	return c, nil
+}
+
+// String returns the name of the cipher as accepted by ParseCipher
+func (c Cipher) String() string {
+	for name, id := range cipherNames {
+		if id == c {
+			return name
+		}
+	}
+	return fmt.Sprintf("cipher %d", byte(c))
+}
+
+// Options select the algorithms used for new data. The zero value selects
+// AES-256-GCM and the default scrypt parameters.
+type Options struct {
+	Cipher Cipher
+	KDF    KDFParams
+
+	// Keyfile holds the contents of a keyfile that is required in addition
+	// to the password, nil for none
+	Keyfile []byte
+
+	// Signer, if set, signs the plaintext inside the envelope
+	Signer *SigningKey
+}
+
+// withDefaults fills in the defaults for unset fields
+func (o *Options) withDefaults() Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.Cipher == 0 {
+		opts.Cipher = AES256GCM
+	}
+	if opts.KDF.Algorithm == 0 {
+		opts.KDF = defaultKDFParams
+	}
+	return opts
+}
+
+// header is the unencrypted part of an envelope
+type header struct {
+	Version byte
+	Flags   byte
+	Cipher  Cipher
+	KDF     KDFParams
+	Salt    []byte
+	Nonce   []byte
+
+	// ChunkSize is the plaintext size of each chunk of a streamed envelope
+	ChunkSize uint32
+}
+
+// marshal encodes the header in its binary form
+func (h *header) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(envelopeMagic)
+	buf.Write([]byte{h.Version, h.Flags, byte(h.Cipher), byte(h.KDF.Algorithm)})
+	for _, v := range h.KDF.values() {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.WriteByte(byte(len(h.Salt)))
+	buf.Write(h.Salt)
+	buf.WriteByte(byte(len(h.Nonce)))
+	buf.Write(h.Nonce)
+	if h.Flags&flagStream != 0 {
+		binary.Write(&buf, binary.BigEndian, h.ChunkSize)
+	}
+	return buf.Bytes()
+}
+
+// readHeader reads and validates an envelope header from r. It returns the
+// parsed header together with its raw bytes, which are needed as AEAD
+// additional data.
+func readHeader(r io.Reader) (*header, []byte, error) {
+	var raw bytes.Buffer
+	r = io.TeeReader(r, &raw)
+
+	fixed := make([]byte, len(envelopeMagic)+4+12)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, fmt.Errorf("%w: envelope too short", ErrCorrupted)
+	}
+	if string(fixed[:4]) != envelopeMagic {
+		return nil, nil, fmt.Errorf("%w: not a cryptdecrypt envelope", ErrCorrupted)
+	}
+	h := &header{
+		Version: fixed[4],
+		Flags:   fixed[5],
+		Cipher:  Cipher(fixed[6]),
+		KDF: kdfParamsFromValues(KDF(fixed[7]), [3]uint32{
+			binary.BigEndian.Uint32(fixed[8:]),
+			binary.BigEndian.Uint32(fixed[12:]),
+			binary.BigEndian.Uint32(fixed[16:]),
+		}),
+	}
+	if h.Version != envelopeVersion {
+		return nil, nil, fmt.Errorf("%w: envelope version %d", ErrUnsupportedVersion, h.Version)
+	}
+	if h.Flags&^knownFlags != 0 {
+		return nil, nil, fmt.Errorf("%w: envelope flags %#x", ErrUnsupportedVersion, h.Flags)
+	}
+	if h.Cipher != AES256GCM && h.Cipher != ChaCha20Poly1305 {
+		return nil, nil, fmt.Errorf("%w: cipher %d", ErrUnsupportedVersion, h.Cipher)
+	}
+	if err := h.KDF.validate(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrUnsupportedVersion, err)
+	}
+
+	var err error
+	if h.Salt, err = readLengthPrefixed(r); err != nil {
+		return nil, nil, fmt.Errorf("%w: error reading salt: %v", ErrCorrupted, err)
+	}
+	if h.Nonce, err = readLengthPrefixed(r); err != nil {
+		return nil, nil, fmt.Errorf("%w: error reading nonce: %v", ErrCorrupted, err)
+	}
+	if h.Flags&flagStream != 0 {
+		if err := binary.Read(r, binary.BigEndian, &h.ChunkSize); err != nil {
+			return nil, nil, fmt.Errorf("%w: error reading chunk size: %v", ErrCorrupted, err)
+		}
+		if h.ChunkSize < minChunkSize || h.ChunkSize > maxChunkSize {
+			return nil, nil, fmt.Errorf("%w: invalid chunk size %d", ErrCorrupted, h.ChunkSize)
+		}
+	}
+	return h, raw.Bytes(), nil
+}
+
+// readLengthPrefixed reads a field that is preceded by a one byte length
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	field := make([]byte, length[0])
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// newAEAD creates the authenticated cipher identified by id
+func newAEAD(id Cipher, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher %d", id)
+	}
+}
+
+// sealEnvelope encrypts plaintext with a password-derived key and returns the
+// complete binary envelope
+func sealEnvelope(plaintext []byte, password string, opts Options) ([]byte, error) {
+	h := &header{
+		Version: envelopeVersion,
+		Cipher:  opts.Cipher,
+		KDF:     opts.KDF,
+		Salt:    make([]byte, saltLength),
+	}
+	if opts.Keyfile != nil {
+		h.Flags |= flagKeyfile
+	}
+	if opts.Signer != nil {
+		h.Flags |= flagSigned
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return nil, err
+	}
+
+	key, err := envelopeKey(h, password, opts.Keyfile)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, h.Nonce); err != nil {
+		return nil, err
+	}
+
+	raw := h.marshal()
+	if opts.Signer != nil {
+		if plaintext, err = appendSignature(raw, plaintext, opts.Signer); err != nil {
+			return nil, err
+		}
+	}
+	return aead.Seal(raw, h.Nonce, plaintext, raw), nil
+}
+
+// openPayload decrypts a single-shot payload that follows the header
+func openPayload(aead cipher.AEAD, h *header, raw, payload []byte) ([]byte, error) {
+	if len(h.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce length %d", ErrCorrupted, len(h.Nonce))
+	}
+	plaintext, err := aead.Open(nil, h.Nonce, payload, raw)
+	if err != nil {
+		return nil, h.wrongKeyError()
+	}
+	return plaintext, nil
+}
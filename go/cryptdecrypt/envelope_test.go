@@ -0,0 +1,86 @@
+package cryptdecrypt_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+func TestSeal(t *testing.T) {
+	for _, c := range []cryptdecrypt.Cipher{cryptdecrypt.AES256GCM, cryptdecrypt.ChaCha20Poly1305} {
+		plaintext := randomBytes(t, 1000)
+		envelope, err := cryptdecrypt.Seal(plaintext, "pw", &cryptdecrypt.Options{Cipher: c, KDF: fastKDF(t)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decrypted bytes.Buffer
+		if err := cryptdecrypt.Decrypt(&decrypted, bytes.NewReader(envelope), "pw"); err != nil {
+			t.Fatalf("%v: Decrypt: %v", c, err)
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("%v: plaintext differs", c)
+		}
+		info, err := cryptdecrypt.Inspect(bytes.NewReader(envelope))
+		if err != nil || info.Streamed || info.Cipher != c {
+			t.Errorf("%v: Inspect = %v, %v", c, info, err)
+		}
+	}
+}
+
+func TestEncryptText(t *testing.T) {
+	text, err := cryptdecrypt.EncryptText([]byte("secret"), "pw", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := cryptdecrypt.DecryptText(text, "pw")
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("DecryptText = %q, %v", plaintext, err)
+	}
+	info, err := cryptdecrypt.InspectText(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != cryptdecrypt.FormatEnvelope || info.Streamed || info.Cipher != cryptdecrypt.AES256GCM {
+		t.Errorf("InspectText = %v", info)
+	}
+}
+
+// Every part of a single-shot envelope is authenticated
+func TestSealTampering(t *testing.T) {
+	envelope, err := cryptdecrypt.Seal([]byte("secret"), "pw", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte(nil), envelope...))
+	}
+
+	// Offsets: magic 0, version 4, flags 5, cipher 6, kdf 7, kdf params 8,
+	// salt length 20, salt 21, nonce length 37, nonce 38, payload 50
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"wrong magic", modified(func(d []byte) []byte { d[0] = 'X'; return d }), cryptdecrypt.ErrCorrupted},
+		{"newer version", modified(func(d []byte) []byte { d[4] = 2; return d }), cryptdecrypt.ErrUnsupportedVersion},
+		{"unknown kdf", modified(func(d []byte) []byte { d[7] = 9; return d }), cryptdecrypt.ErrUnsupportedVersion},
+		{"other cipher", modified(func(d []byte) []byte { d[6] = byte(cryptdecrypt.ChaCha20Poly1305); return d }), cryptdecrypt.ErrWrongPassword},
+		{"modified kdf params", modified(func(d []byte) []byte { d[19] ^= 2; return d }), cryptdecrypt.ErrWrongPassword},
+		{"modified salt", modified(func(d []byte) []byte { d[21] ^= 1; return d }), cryptdecrypt.ErrWrongPassword},
+		{"modified nonce", modified(func(d []byte) []byte { d[40] ^= 1; return d }), cryptdecrypt.ErrWrongPassword},
+		{"short nonce", modified(func(d []byte) []byte { d[37] = 11; return d }), cryptdecrypt.ErrCorrupted},
+		{"modified ciphertext", modified(func(d []byte) []byte { d[50] ^= 1; return d }), cryptdecrypt.ErrWrongPassword},
+		{"modified tag", modified(func(d []byte) []byte { d[len(d)-1] ^= 1; return d }), cryptdecrypt.ErrWrongPassword},
+		{"appended byte", append(modified(func(d []byte) []byte { return d }), 0), cryptdecrypt.ErrWrongPassword},
+		{"truncated salt", envelope[:30], cryptdecrypt.ErrCorrupted},
+	}
+	for _, tt := range tests {
+		err := cryptdecrypt.Decrypt(&bytes.Buffer{}, bytes.NewReader(tt.data), "pw")
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
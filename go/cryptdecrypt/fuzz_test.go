@@ -0,0 +1,151 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// unpadReference is the obvious, not constant-time version of unpad
+func unpadReference(data []byte) ([]byte, bool) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, false
+	}
+	padding := int(data[len(data)-1])
+	if padding < 1 || padding > aes.BlockSize {
+		return nil, false
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, false
+		}
+	}
+	return data[:len(data)-padding], true
+}
+
+func FuzzUnpad(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(pad([]byte("hello")))
+	f.Add(pad(make([]byte, aes.BlockSize)))
+	f.Add(append(make([]byte, 15), 0))
+	f.Add(append(bytes.Repeat([]byte{16}, 15), 17))
+	f.Add(append(bytes.Repeat([]byte{1}, 14), 2, 2))
+	f.Add(append(bytes.Repeat([]byte{3}, 14), 2, 3))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got, err := unpad(data)
+		want, ok := unpadReference(data)
+		if ok != (err == nil) || !bytes.Equal(got, want) {
+			t.Fatalf("unpad(%x) = %x, %v; want %x, %v", data, got, err, want, ok)
+		}
+		if err != nil && err != errInvalidPadding {
+			t.Fatalf("unpad(%x) failed with %v, want errInvalidPadding", data, err)
+		}
+	})
+}
+
+func FuzzSplitSaltCiphertext(f *testing.F) {
+	f.Add("lYp3+VOQCi9VtpTuop4c1Q==:DkcT5wFUbS4tf9s4AqFbAs6DvFWYD7R8hcFfuN6ycOM=")
+	f.Add("Salt: lYp3+VOQCi9VtpTuop4c1Q==\nCiphertext: DkcT5w==\n")
+	f.Add("Salt:")
+	f.Add(":")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		salt, ciphertext, err := splitSaltCiphertext(input)
+		if err != nil {
+			if !errors.Is(err, ErrCorrupted) {
+				t.Fatalf("splitSaltCiphertext(%q) failed with %v, want ErrCorrupted", input, err)
+			}
+			return
+		}
+		if !strings.HasPrefix(input, legacySaltLabel) && salt+":"+ciphertext != input {
+			t.Fatalf("splitSaltCiphertext(%q) = %q, %q", input, salt, ciphertext)
+		}
+	})
+}
+
+// fuzzKDF is cheap enough to try thousands of inputs per second
+var fuzzKDF = KDFParams{Algorithm: Scrypt, LogN: 4, R: 8, P: 1}
+
+// expensiveKDF reports whether decrypting data would derive a key with
+// costly parameters from its header, which the fuzzer would otherwise find
+// and spend its time on. Legacy data always uses the slow default KDF.
+func expensiveKDF(data []byte) bool {
+	info, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		info, err = InspectText(string(data))
+	}
+	if err != nil {
+		return false
+	}
+	switch {
+	case info.Format == FormatLegacy:
+		return true
+	case info.KDF.Algorithm == Scrypt:
+		return info.KDF.LogN > 10 || info.KDF.R > 8 || info.KDF.P > 1
+	case info.KDF.Algorithm == Argon2id:
+		return info.KDF.Memory > 1024 || info.KDF.Time > 2
+	}
+	return false
+}
+
+// decryptErrors are the errors malformed input may cause
+var decryptErrors = []error{ErrWrongPassword, ErrCorrupted, ErrUnsupportedVersion, ErrNoIdentity, ErrKeyfileRequired, ErrBadSignature, ErrUnknownSigner}
+
+func FuzzDecrypt(f *testing.F) {
+	opts := &Options{KDF: fuzzKDF}
+	plaintext := []byte("fuzz me")
+	sealed, err := Seal(plaintext, "pw", opts)
+	if err != nil {
+		f.Fatal(err)
+	}
+	var streamed bytes.Buffer
+	signer, err := GenerateSigningKey()
+	if err != nil {
+		f.Fatal(err)
+	}
+	if err := Encrypt(&streamed, bytes.NewReader(plaintext), "pw", &Options{KDF: fuzzKDF, Signer: signer}); err != nil {
+		f.Fatal(err)
+	}
+	text, err := EncryptText(plaintext, "pw", opts)
+	if err != nil {
+		f.Fatal(err)
+	}
+	openssl, err := EncryptOpenSSL(plaintext, "pw", &OpenSSLOptions{Iterations: 1})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(sealed)
+	f.Add(streamed.Bytes())
+	f.Add([]byte(text))
+	f.Add([]byte(ArmorEnvelope(sealed)))
+	f.Add(openssl)
+	f.Add([]byte(openSSLBase64Prefix))
+	f.Add([]byte(envelopeMagic))
+	f.Add([]byte{})
+
+	creds := Credentials{Password: "pw", OpenSSL: &OpenSSLOptions{Iterations: 1}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if expensiveKDF(data) {
+			t.Skip()
+		}
+		check := func(name string, err error) {
+			if err == nil {
+				return
+			}
+			for _, e := range decryptErrors {
+				if errors.Is(err, e) {
+					return
+				}
+			}
+			t.Fatalf("%s(%q) failed with an untyped error: %v", name, data, err)
+		}
+		check("Decrypt", DecryptWith(io.Discard, bytes.NewReader(data), creds))
+		_, err := DecryptTextWith(string(data), creds)
+		check("DecryptText", err)
+	})
+}
@@ -1,5 +1,13 @@
-module crypt
+module github.com/wiederda/Skripte/go/cryptdecrypt
 
 go 1.22.2
 
-require golang.org/x/crypto v0.28.0 // indirect
+require (
+	filippo.io/age v1.2.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.28.0
+	golang.org/x/term v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.26.0
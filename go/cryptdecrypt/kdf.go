@@ -0,0 +1,235 @@
+package cryptdecrypt
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifies the key derivation function of an envelope
+type KDF byte
+
+// KDF identifiers stored in the envelope header
+const (
+	Scrypt   KDF = 1
+	Argon2id KDF = 2
+)
+
+// kdfNames maps the -kdf flag values to envelope KDF identifiers
+var kdfNames = map[string]KDF{
+	"scrypt":   Scrypt,
+	"argon2id": Argon2id,
+}
+
+// Upper bounds for parameters read from an envelope, so that a crafted header
+// cannot make decryption allocate unbounded memory or run for hours
+const (
+	maxScryptLogN    = 22      // 4 GiB with r=8
+	maxScryptMemory  = 4 << 30 // 128*r*N bytes
+	maxScryptRP      = 512     // r*p, the work per element of N; r=8 with 64 threads
+	maxArgon2Memory  = 4 << 20 // 4 GiB in KiB
+	maxArgon2Time    = 64
+	maxKDFThreads    = 64
+	scryptBlockSizeR = 8
+)
+
+// KDFParams describes how the key was derived from the password. Only the
+// fields belonging to Algorithm are used and stored in the envelope.
+type KDFParams struct {
+	Algorithm KDF
+
+	// scrypt
+	LogN uint32 // cost parameter as a power of two
+	R    uint32 // block size
+	P    uint32 // parallelization
+
+	// Argon2id
+	Time    uint32 // number of passes
+	Memory  uint32 // memory in KiB
+	Threads uint32 // degree of parallelism
+}
+
+// defaultKDFParams are the scrypt parameters this tool has always used
+var defaultKDFParams = KDFParams{Algorithm: Scrypt, LogN: 15, R: scryptBlockSizeR, P: 1}
+
+// defaultArgon2Params follow the second recommendation of RFC 9106
+var defaultArgon2Params = KDFParams{Algorithm: Argon2id, Time: 3, Memory: 64 << 10, Threads: 4}
+
+// values returns the three header fields for the parameters
+func (p KDFParams) values() [3]uint32 {
+	if p.Algorithm == Argon2id {
+		return [3]uint32{p.Time, p.Memory, p.Threads}
+	}
+	return [3]uint32{p.LogN, p.R, p.P}
+}
+
+// kdfParamsFromValues is the inverse of KDFParams.values
+func kdfParamsFromValues(algorithm KDF, v [3]uint32) KDFParams {
+	if algorithm == Argon2id {
+		return KDFParams{Algorithm: algorithm, Time: v[0], Memory: v[1], Threads: v[2]}
+	}
+	return KDFParams{Algorithm: algorithm, LogN: v[0], R: v[1], P: v[2]}
+}
+
+// String describes the parameters in a human readable form
+func (p KDFParams) String() string {
+	switch p.Algorithm {
+	case Scrypt:
+		return fmt.Sprintf("scrypt N=2^%d r=%d p=%d (%d MiB)", p.LogN, p.R, p.P, (128*uint64(p.R)<<p.LogN)>>20)
+	case Argon2id:
+		return fmt.Sprintf("argon2id t=%d m=%d MiB p=%d", p.Time, p.Memory>>10, p.Threads)
+	default:
+		return fmt.Sprintf("unknown kdf %d", p.Algorithm)
+	}
+}
+
+// validate rejects parameters that are out of the supported range
+func (p KDFParams) validate() error {
+	switch p.Algorithm {
+	case Scrypt:
+		if p.LogN < 1 || p.LogN > maxScryptLogN || p.R < 1 || p.R > 64 || p.P < 1 || p.P > maxKDFThreads ||
+			128*uint64(p.R)<<p.LogN > maxScryptMemory || uint64(p.R)*uint64(p.P) > maxScryptRP {
+			return fmt.Errorf("unsupported scrypt parameters N=2^%d r=%d p=%d", p.LogN, p.R, p.P)
+		}
+	case Argon2id:
+		if p.Time < 1 || p.Time > maxArgon2Time || p.Threads < 1 || p.Threads > maxKDFThreads ||
+			p.Memory < 8*p.Threads || p.Memory > maxArgon2Memory {
+			return fmt.Errorf("unsupported argon2id parameters t=%d m=%d KiB p=%d", p.Time, p.Memory, p.Threads)
+		}
+	default:
+		return fmt.Errorf("unsupported key derivation function %d", p.Algorithm)
+	}
+	return nil
+}
+
+// ErrKDFLimit is returned for an envelope whose key derivation costs more
+// than Credentials.MaxKDF allows
+var ErrKDFLimit = errors.New("key derivation exceeds the allowed cost")
+
+// cost returns the memory in bytes and the work, the memory times the number
+// of passes over it, of deriving a key with the parameters
+func (p KDFParams) cost() (memory, work uint64) {
+	if p.Algorithm == Argon2id {
+		memory = uint64(p.Memory) << 10
+		return memory, memory * uint64(p.Time)
+	}
+	memory = 128 * uint64(p.R) << p.LogN
+	return memory, memory * uint64(p.P)
+}
+
+// exceeds reports whether deriving a key with p needs more memory or work
+// than with limit
+func (p KDFParams) exceeds(limit KDFParams) bool {
+	memory, work := p.cost()
+	maxMemory, maxWork := limit.cost()
+	return memory > maxMemory || work > maxWork
+}
+
+// deriveKey derives a key from the password and salt using the given parameters
+func deriveKey(password []byte, salt []byte, params KDFParams) ([]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	switch params.Algorithm {
+	case Argon2id:
+		return argon2.IDKey(password, salt, params.Time, params.Memory, uint8(params.Threads), keyLength), nil
+	default:
+		return scrypt.Key(password, salt, 1<<params.LogN, int(params.R), int(params.P), keyLength)
+	}
+}
+
+// NewKDFParams builds parameters from the -kdf-* flags. Zero values select
+// the defaults of the algorithm. memoryMiB sets the scrypt N via N*128*r bytes
+// and threads the scrypt parallelization; timeCost only applies to Argon2id.
+func NewKDFParams(name string, memoryMiB, timeCost, threads uint) (KDFParams, error) {
+	algorithm, ok := kdfNames[name]
+	if !ok {
+		return KDFParams{}, fmt.Errorf("unknown kdf %q", name)
+	}
+
+	// The flags are converted to uint32 below, so values out of range are
+	// refused before they could wrap into valid but weaker parameters
+	if threads > maxKDFThreads {
+		return KDFParams{}, fmt.Errorf("-kdf-threads %d is more than the supported %d", threads, maxKDFThreads)
+	}
+
+	var params KDFParams
+	switch algorithm {
+	case Scrypt:
+		params = defaultKDFParams
+		if timeCost != 0 {
+			return KDFParams{}, fmt.Errorf("-kdf-time only applies to argon2id")
+		}
+		if memoryMiB > maxScryptMemory>>20 {
+			return KDFParams{}, fmt.Errorf("-kdf-memory %d MiB is more than the supported %d MiB", memoryMiB, maxScryptMemory>>20)
+		}
+		if memoryMiB != 0 {
+			// N must be a power of two, so round down
+			params.LogN = uint32(bits.Len64(uint64(memoryMiB)<<20/(128*scryptBlockSizeR))) - 1
+		}
+		if threads != 0 {
+			params.P = uint32(threads)
+		}
+	case Argon2id:
+		params = defaultArgon2Params
+		if memoryMiB > maxArgon2Memory>>10 {
+			return KDFParams{}, fmt.Errorf("-kdf-memory %d MiB is more than the supported %d MiB", memoryMiB, maxArgon2Memory>>10)
+		}
+		if timeCost > maxArgon2Time {
+			return KDFParams{}, fmt.Errorf("-kdf-time %d is more than the supported %d", timeCost, maxArgon2Time)
+		}
+		if memoryMiB != 0 {
+			params.Memory = uint32(memoryMiB << 10)
+		}
+		if timeCost != 0 {
+			params.Time = uint32(timeCost)
+		}
+		if threads != 0 {
+			params.Threads = uint32(threads)
+		}
+	}
+	return params, params.validate()
+}
+
+// CalibrateKDF increases the cost of params until deriving a key takes about
+// target on this machine. For scrypt the memory (N) is doubled, for Argon2id
+// the memory is kept and the number of passes is raised.
+func CalibrateKDF(params KDFParams, target time.Duration) (KDFParams, error) {
+	salt := make([]byte, saltLength)
+	measure := func(p KDFParams) (time.Duration, error) {
+		start := time.Now()
+		_, err := deriveKey([]byte("calibration"), salt, p)
+		return time.Since(start), err
+	}
+
+	switch params.Algorithm {
+	case Scrypt:
+		params.LogN = 10
+		for {
+			elapsed, err := measure(params)
+			if err != nil {
+				return KDFParams{}, err
+			}
+			// Doubling N doubles the time, stop before overshooting
+			if 2*elapsed > target || params.LogN == maxScryptLogN {
+				return params, nil
+			}
+			params.LogN++
+		}
+	case Argon2id:
+		params.Time = 1
+		elapsed, err := measure(params)
+		if err != nil {
+			return KDFParams{}, err
+		}
+		// Time scales linearly with the number of passes
+		params.Time = uint32(min(max(int64(target/max(elapsed, time.Millisecond)), 1), maxArgon2Time))
+		return params, nil
+	default:
+		return KDFParams{}, fmt.Errorf("unsupported key derivation function %d", params.Algorithm)
+	}
+}
@@ -0,0 +1,151 @@
+package cryptdecrypt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+func TestNewKDFParams(t *testing.T) {
+	tests := []struct {
+		name                     string
+		memoryMiB, time, threads uint
+		want                     cryptdecrypt.KDFParams
+	}{
+		{"scrypt", 0, 0, 0, cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Scrypt, LogN: 15, R: 8, P: 1}},
+		{"scrypt", 64, 0, 0, cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Scrypt, LogN: 16, R: 8, P: 1}},
+		{"scrypt", 100, 0, 2, cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Scrypt, LogN: 16, R: 8, P: 2}},
+		{"argon2id", 0, 0, 0, cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Argon2id, Time: 3, Memory: 64 << 10, Threads: 4}},
+		{"argon2id", 256, 2, 1, cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Argon2id, Time: 2, Memory: 256 << 10, Threads: 1}},
+	}
+	for _, tt := range tests {
+		got, err := cryptdecrypt.NewKDFParams(tt.name, tt.memoryMiB, tt.time, tt.threads)
+		if err != nil || got != tt.want {
+			t.Errorf("NewKDFParams(%s, %d, %d, %d) = %v, %v, want %v", tt.name, tt.memoryMiB, tt.time, tt.threads, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name                     string
+		memoryMiB, time, threads uint
+	}{
+		{"pbkdf2", 0, 0, 0},
+		{"scrypt", 0, 3, 0},      // -kdf-time is argon2id only
+		{"scrypt", 8192, 0, 0},   // 8 GiB
+		{"scrypt", 4096, 0, 65},  // too many threads
+		{"argon2id", 8192, 0, 0}, // 8 GiB
+		{"argon2id", 0, 100, 0},  // too many passes
+		{"argon2id", 1, 0, 200},  // less than 8 KiB per thread
+		// Values that would wrap around when converted to uint32
+		{"argon2id", 4<<20 + 64, 0, 0},
+		{"argon2id", 0, 1<<32 + 1, 0},
+		{"argon2id", 0, 0, 1<<32 + 1},
+		{"scrypt", 1<<44 + 32, 0, 0},
+		{"scrypt", 0, 0, 1<<32 + 1},
+	} {
+		if p, err := cryptdecrypt.NewKDFParams(tt.name, tt.memoryMiB, tt.time, tt.threads); err == nil {
+			t.Errorf("NewKDFParams(%s, %d, %d, %d) = %v, want an error", tt.name, tt.memoryMiB, tt.time, tt.threads, p)
+		}
+	}
+}
+
+// The parameters are read from the envelope and checked before a key is
+// derived, so a crafted header cannot make decryption allocate gigabytes
+func TestKDFParamsFromHeader(t *testing.T) {
+	envelope, err := cryptdecrypt.Seal([]byte("secret"), "pw", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := cryptdecrypt.Inspect(bytes.NewReader(envelope))
+	if err != nil || info.KDF != fastKDF(t) {
+		t.Errorf("Inspect = %v, %v, want %v", info, err, fastKDF(t))
+	}
+
+	// log2(N), r and p are stored at offset 8 for scrypt
+	withParams := func(logN, r, p uint32) []byte {
+		d := append([]byte(nil), envelope...)
+		binary.BigEndian.PutUint32(d[8:], logN)
+		binary.BigEndian.PutUint32(d[12:], r)
+		binary.BigEndian.PutUint32(d[16:], p)
+		return d
+	}
+	for _, tt := range []struct {
+		name    string
+		logN, r uint32
+		p       uint32
+	}{
+		{"N too large", 23, 8, 1},
+		{"N zero", 0, 8, 1},
+		{"32 GiB through r", 22, 64, 1},
+		{"8 GiB through r", 20, 64, 1},
+		{"r*p too large", 10, 64, 64},
+		{"p too large", 10, 1, 65},
+	} {
+		err := cryptdecrypt.Decrypt(&bytes.Buffer{}, bytes.NewReader(withParams(tt.logN, tt.r, tt.p)), "pw")
+		if !errors.Is(err, cryptdecrypt.ErrUnsupportedVersion) {
+			t.Errorf("%s: got %v, want ErrUnsupportedVersion", tt.name, err)
+		}
+	}
+	// Accepted parameters only fail authentication
+	if err := cryptdecrypt.Decrypt(&bytes.Buffer{}, bytes.NewReader(withParams(10, 8, 2)), "pw"); !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+		t.Errorf("other p: got %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestCalibrateKDF(t *testing.T) {
+	start, err := cryptdecrypt.NewKDFParams("scrypt", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := cryptdecrypt.CalibrateKDF(start, time.Millisecond)
+	if err != nil || params.Algorithm != cryptdecrypt.Scrypt || params.LogN < 10 || params.LogN > 12 {
+		t.Errorf("CalibrateKDF = %v, %v, want the minimum N", params, err)
+	}
+}
+
+func TestMaxKDF(t *testing.T) {
+	limit := fastKDF(t)
+	cheap := cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Scrypt, LogN: limit.LogN - 1, R: 8, P: 1}
+	moreMemory := cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Scrypt, LogN: limit.LogN + 1, R: 8, P: 1}
+	moreWork := cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Scrypt, LogN: limit.LogN, R: 8, P: 4}
+	argon2 := cryptdecrypt.KDFParams{Algorithm: cryptdecrypt.Argon2id, Time: 1, Memory: 8 << 10, Threads: 1}
+
+	tests := []struct {
+		name    string
+		kdf     cryptdecrypt.KDFParams
+		refused bool
+	}{
+		{"same", limit, false},
+		{"cheaper", cheap, false},
+		{"more memory", moreMemory, true},
+		{"more work", moreWork, true},
+		{"argon2id", argon2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := cryptdecrypt.EncryptText([]byte("value"), "pw", &cryptdecrypt.Options{KDF: tt.kdf})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stream bytes.Buffer
+			if err := cryptdecrypt.Encrypt(&stream, bytes.NewReader([]byte("value")), "pw", &cryptdecrypt.Options{KDF: tt.kdf}); err != nil {
+				t.Fatal(err)
+			}
+
+			creds := cryptdecrypt.Credentials{Password: "pw", MaxKDF: &limit}
+			_, _, sessionErr := cryptdecrypt.NewSession(creds, nil).DecryptText(text)
+			_, textErr := cryptdecrypt.DecryptTextWith(text, creds)
+			streamErr := cryptdecrypt.DecryptWith(io.Discard, &stream, creds)
+			for _, err := range []error{sessionErr, textErr, streamErr} {
+				if refused := errors.Is(err, cryptdecrypt.ErrKDFLimit); refused != tt.refused || (!refused && err != nil) {
+					t.Errorf("got %v, refused %v", err, tt.refused)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Keyfiles are a second factor next to the password, as in KeePass: any file
+// can serve as a keyfile. A file of 64 hex digits, as written by
+// GenerateKeyfile, is used as a 32 byte key, any other file is hashed with
+// SHA-256. The KDF input then is
+//
+//	SHA-256(SHA-256(password) || keyfile key)
+//
+// and flagKeyfile in the envelope header records that the keyfile is needed.
+const keyfileKeySize = 32
+
+// ErrKeyfileRequired is returned when data was encrypted with a keyfile but
+// none was given
+var ErrKeyfileRequired = errors.New("a keyfile is required in addition to the password")
+
+// keyfileKey returns the key of a keyfile with the given contents
+func keyfileKey(contents []byte) ([]byte, error) {
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("keyfile is empty")
+	}
+	if trimmed := bytes.TrimSpace(contents); len(trimmed) == 2*keyfileKeySize {
+		if key, err := hex.DecodeString(string(trimmed)); err == nil {
+			return key, nil
+		}
+	}
+	sum := sha256.Sum256(contents)
+	return sum[:], nil
+}
+
+// envelopeKey derives the key of an envelope from the password, mixing in
+// the keyfile if the header requires one
+func envelopeKey(h *header, password string, keyfile []byte) ([]byte, error) {
+	if h.Flags&flagKeyfile == 0 {
+		return deriveKey([]byte(password), h.Salt, h.KDF)
+	}
+	if keyfile == nil {
+		return nil, ErrKeyfileRequired
+	}
+	key, err := keyfileKey(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	passwordHash := sha256.Sum256([]byte(password))
+	composite := sha256.Sum256(append(passwordHash[:], key...))
+	return deriveKey(composite[:], h.Salt, h.KDF)
+}
+
+// decryptionKey derives the key of an envelope from the credentials. The
+// parameters come from the data, so they are checked against MaxKDF first.
+func decryptionKey(h *header, creds Credentials) ([]byte, error) {
+	if creds.MaxKDF != nil && h.KDF.exceeds(*creds.MaxKDF) {
+		return nil, fmt.Errorf("%w: %s, at most %s", ErrKDFLimit, h.KDF, *creds.MaxKDF)
+	}
+	return envelopeKey(h, creds.Password, creds.Keyfile)
+}
+
+// wrongKeyError is the error for a key that does not authenticate the
+// envelope, which also names the keyfile if one is required
+func (h *header) wrongKeyError() error {
+	if h.Flags&flagKeyfile != 0 {
+		return fmt.Errorf("%w or keyfile", ErrWrongPassword)
+	}
+	return ErrWrongPassword
+}
+
+// GenerateKeyfile writes a new random keyfile of 64 hex digits to w
+func GenerateKeyfile(w io.Writer) error {
+	key := make([]byte, keyfileKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, hex.EncodeToString(key))
+	return err
+}
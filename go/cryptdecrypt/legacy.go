@@ -0,0 +1,112 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Labels of the two lines printed by early versions
+const (
+	legacySaltLabel       = "Salt:"
+	legacyCiphertextLabel = "Ciphertext:"
+)
+
+// pad adds PKCS7 padding to data
+func pad(data []byte) []byte {
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	padText := bytes.Repeat([]byte{byte(padding)}, padding)
+	return append(data, padText...)
+}
+
+// errInvalidPadding is the only error of unpad, whichever check failed
+var errInvalidPadding = errors.New("invalid padding")
+
+// unpad removes PKCS7 padding from data. The padding value and all padding
+// bytes are checked in constant time, so neither the error nor the time
+// taken tells an attacker which byte was wrong (a padding oracle).
+func unpad(data []byte) ([]byte, error) {
+	// The length is public, it does not depend on the key
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errInvalidPadding
+	}
+	last := data[len(data)-aes.BlockSize:]
+	padding := int(last[aes.BlockSize-1])
+
+	// good stays 1 as long as every check passes
+	good := subtle.ConstantTimeLessOrEq(1, padding) & subtle.ConstantTimeLessOrEq(padding, aes.BlockSize)
+	for i, b := range last {
+		inPadding := subtle.ConstantTimeLessOrEq(aes.BlockSize-i, padding)
+		good &= subtle.ConstantTimeSelect(inPadding, subtle.ConstantTimeByteEq(b, byte(padding)), 1)
+	}
+	if good != 1 {
+		return nil, errInvalidPadding
+	}
+	return data[:len(data)-padding], nil
+}
+
+// decryptLegacy decrypts the Base64-encoded salt and AES-256-CBC ciphertext
+// written by earlier versions of this tool. It offers no integrity protection
+// and is only kept so that existing 'salt:ciphertext' blobs can be read.
+func decryptLegacy(saltBase64, ciphertextBase64, password string) ([]byte, error) {
+	// Decode the Base64-encoded salt
+	salt, err := base64.StdEncoding.DecodeString(saltBase64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding salt: %v", ErrCorrupted, err)
+	}
+
+	// Decode the Base64-encoded ciphertext
+	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextBase64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding ciphertext: %v", ErrCorrupted, err)
+	}
+
+	// Derive the key
+	key, err := deriveKey([]byte(password), salt, defaultKDFParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check ciphertext length: IV and at least one padded block
+	if len(ciphertext) < 2*aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: invalid ciphertext length %d", ErrCorrupted, len(ciphertext))
+	}
+
+	// The IV is prepended to the ciphertext
+	return decryptCBC(key, ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:])
+}
+
+// encryptCBC encrypts plaintext with AES-256-CBC and PKCS7 padding
+func encryptCBC(key, iv, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := pad(bytes.Clone(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	return ciphertext, nil
+}
+
+// decryptCBC decrypts AES-256-CBC with PKCS7 padding. Without
+// authentication, bad padding is the only sign of a wrong password.
+func decryptCBC(key, iv, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: invalid ciphertext length %d", ErrCorrupted, len(ciphertext))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	plaintext, err = unpad(plaintext)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,108 @@
+package cryptdecrypt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+// Written by the original AES-256-CBC version of the tool with the password
+// "correct horse", as 'salt:ciphertext' of its "Salt:" and "Ciphertext:" lines
+var legacyVectors = []struct {
+	text      string
+	plaintext string
+}{
+	{
+		"lYp3+VOQCi9VtpTuop4c1Q==:DkcT5wFUbS4tf9s4AqFbAs6DvFWYD7R8hcFfuN6ycOM=",
+		"hello legacy",
+	},
+	{
+		// A full block of padding
+		"OcuE1x1SuHVpGlpgvh/ttA==:0CpMCwrmLnLWews8X/IUWzfnRg1QvJQNiEXTlPurlFSHgNu/1UfCImJ1Q2cssWid",
+		"exactly16bytes!!",
+	},
+	{
+		"h9hG/g2kBy+UNp2kturvbQ==:B0K8jcXzI/PfGIiDb5egZXAsKkLZQH/DL8RJvd2G0ZFVz/JGZB8NL/sPAThDwkpYdQDhTvMtyaZMezAJszzlMKwRds3SgBZbvIetfqDVcXc=",
+		"Ünïcödé and a longer text spanning several AES blocks.",
+	},
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	for _, v := range legacyVectors {
+		plaintext, err := cryptdecrypt.DecryptText(v.text, "correct horse")
+		if err != nil {
+			t.Fatalf("DecryptText(%q): %v", v.text, err)
+		}
+		if string(plaintext) != v.plaintext {
+			t.Errorf("DecryptText(%q) = %q, want %q", v.text, plaintext, v.plaintext)
+		}
+	}
+}
+
+func TestDecryptLegacyWrongPassword(t *testing.T) {
+	for _, v := range legacyVectors {
+		_, err := cryptdecrypt.DecryptText(v.text, "wrong horse")
+		if !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+			t.Errorf("DecryptText(%q) with wrong password: %v, want ErrWrongPassword", v.text, err)
+		}
+	}
+}
+
+func TestDecryptLegacyMalformed(t *testing.T) {
+	for _, text := range []string{
+		"not base64!:DkcT5wFUbS4tf9s4AqFbAs6DvFWYD7R8hcFfuN6ycOM=",
+		"lYp3+VOQCi9VtpTuop4c1Q==:not base64!",
+		"lYp3+VOQCi9VtpTuop4c1Q==:",
+		// IV only
+		"lYp3+VOQCi9VtpTuop4c1Q==:DkcT5wFUbS4tf9s4AqFbAg==",
+		// Not a multiple of the block size
+		"lYp3+VOQCi9VtpTuop4c1Q==:DkcT5wFUbS4tf9s4AqFbAs6DvFWYD7R8hcFfuN6ycA==",
+	} {
+		_, err := cryptdecrypt.DecryptText(text, "correct horse")
+		if !errors.Is(err, cryptdecrypt.ErrCorrupted) {
+			t.Errorf("DecryptText(%q): %v, want ErrCorrupted", text, err)
+		}
+	}
+}
+
+func TestInspectLegacy(t *testing.T) {
+	info, err := cryptdecrypt.InspectText(legacyVectors[0].text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != cryptdecrypt.FormatLegacy {
+		t.Errorf("Format = %v, want %v", info.Format, cryptdecrypt.FormatLegacy)
+	}
+}
+
+// Legacy strings are upgraded by decrypting them and encrypting the
+// plaintext into an envelope
+func TestUpgradeLegacy(t *testing.T) {
+	v := legacyVectors[0]
+	info, err := cryptdecrypt.InspectText(v.text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := cryptdecrypt.DecryptText(v.text, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := info.Options()
+	opts.KDF = fastKDF(t)
+	text, err := cryptdecrypt.EncryptText(plaintext, "new horse", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded, err := cryptdecrypt.InspectText(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.Format != cryptdecrypt.FormatEnvelope || upgraded.Cipher != cryptdecrypt.AES256GCM {
+		t.Errorf("upgraded to %v, want an AES-256-GCM envelope", upgraded)
+	}
+	got, err := cryptdecrypt.DecryptText(text, "new horse")
+	if err != nil || string(got) != v.plaintext {
+		t.Errorf("DecryptText = %q, %v, want %q", got, err, v.plaintext)
+	}
+}
@@ -0,0 +1,175 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The format of 'openssl enc -aes-256-cbc': "Salted__", an 8 byte salt and
+// the AES-256-CBC ciphertext with PKCS7 padding. Key and IV are both derived
+// from the password and salt, either with PBKDF2 (-pbkdf2) or with
+// EVP_BytesToKey. Like the legacy format it is not authenticated, so it is
+// only meant for exchanging data with openssl.
+const (
+	openSSLMagic        = "Salted__"
+	openSSLSaltLength   = 8
+	openSSLBase64Prefix = "U2FsdGVkX1" // Base64 of openSSLMagic, as written by -a
+
+	defaultOpenSSLIterations = 10000 // -pbkdf2 without -iter
+)
+
+// OpenSSLOptions select the key derivation of the OpenSSL format. The data
+// does not record it, so decryption needs the same options as encryption.
+type OpenSSLOptions struct {
+	KDF        string // "pbkdf2" (default, -pbkdf2) or "evp" (EVP_BytesToKey, without -pbkdf2)
+	Digest     string // -md: "sha256" (default since OpenSSL 1.1.0), "sha512", "sha1" or "md5"
+	Iterations int    // PBKDF2 iterations (-iter), 0 selects 10000
+	Base64     bool   // EncryptOpenSSL: write Base64 in lines of 64 characters like -a
+}
+
+// withDefaults returns a copy of o with defaults for unset fields. A nil o
+// selects all defaults.
+func (o *OpenSSLOptions) withDefaults() OpenSSLOptions {
+	var opts OpenSSLOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.KDF == "" {
+		opts.KDF = "pbkdf2"
+	}
+	if opts.Digest == "" {
+		opts.Digest = "sha256"
+	}
+	if opts.Iterations == 0 {
+		opts.Iterations = defaultOpenSSLIterations
+	}
+	return opts
+}
+
+// newDigest returns the hash function selected by the -md name
+func (o OpenSSLOptions) newDigest() (func() hash.Hash, error) {
+	switch o.Digest {
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	case "sha1":
+		return sha1.New, nil
+	case "md5":
+		return md5.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported OpenSSL digest %q, use sha256, sha512, sha1 or md5", o.Digest)
+	}
+}
+
+// keyIV derives the AES-256 key and the CBC IV from password and salt
+func (o OpenSSLOptions) keyIV(password string, salt []byte) ([]byte, []byte, error) {
+	digest, err := o.newDigest()
+	if err != nil {
+		return nil, nil, err
+	}
+	const size = 32 + aes.BlockSize
+
+	var material []byte
+	switch o.KDF {
+	case "pbkdf2":
+		if o.Iterations < 1 {
+			return nil, nil, fmt.Errorf("invalid PBKDF2 iteration count %d", o.Iterations)
+		}
+		material = pbkdf2.Key([]byte(password), salt, o.Iterations, size, digest)
+	case "evp":
+		// EVP_BytesToKey with one iteration: D_i = digest(D_i-1 || password || salt)
+		var block []byte
+		for len(material) < size {
+			h := digest()
+			h.Write(block)
+			h.Write([]byte(password))
+			h.Write(salt)
+			block = h.Sum(nil)
+			material = append(material, block...)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported OpenSSL key derivation %q, use pbkdf2 or evp", o.KDF)
+	}
+	return material[:32], material[32:size], nil
+}
+
+// String describes the options in terms of openssl enc flags
+func (o OpenSSLOptions) String() string {
+	if o.KDF == "pbkdf2" {
+		return fmt.Sprintf("-pbkdf2 -iter %d -md %s", o.Iterations, o.Digest)
+	}
+	return fmt.Sprintf("EVP_BytesToKey -md %s", o.Digest)
+}
+
+// EncryptOpenSSL encrypts plaintext like 'openssl enc -aes-256-cbc' with the
+// options, e.g. for colleagues who decrypt with
+// 'openssl enc -d -aes-256-cbc -pbkdf2'. A nil opts selects the defaults.
+func EncryptOpenSSL(plaintext []byte, password string, opts *OpenSSLOptions) ([]byte, error) {
+	o := opts.withDefaults()
+	salt := make([]byte, openSSLSaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, iv, err := o.keyIV(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptCBC(key, iv, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	data := append(append([]byte(openSSLMagic), salt...), ciphertext...)
+	if !o.Base64 {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	lines := &lineWriter{w: &buf}
+	enc := base64.NewEncoder(base64.StdEncoding, lines)
+	enc.Write(data)
+	enc.Close()
+	if lines.column > 0 {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptOpenSSL decrypts the output of 'openssl enc -aes-256-cbc', binary
+// or Base64 (-a). The options must match those used for encryption, a
+// mismatch is reported as ErrWrongPassword.
+func DecryptOpenSSL(data []byte, password string, opts *OpenSSLOptions) ([]byte, error) {
+	o := opts.withDefaults()
+	if text := strings.TrimSpace(string(data)); strings.HasPrefix(text, openSSLBase64Prefix) {
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: error decoding OpenSSL Base64: %v", ErrCorrupted, err)
+		}
+		data = decoded
+	}
+	if !bytes.HasPrefix(data, []byte(openSSLMagic)) || len(data) < len(openSSLMagic)+openSSLSaltLength {
+		return nil, fmt.Errorf("%w: not in OpenSSL %q format", ErrCorrupted, openSSLMagic)
+	}
+	salt := data[len(openSSLMagic) : len(openSSLMagic)+openSSLSaltLength]
+	key, iv, err := o.keyIV(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptCBC(key, iv, data[len(openSSLMagic)+openSSLSaltLength:])
+	if err == ErrWrongPassword {
+		return nil, fmt.Errorf("%w (or OpenSSL options other than %s)", ErrWrongPassword, o)
+	}
+	return plaintext, err
+}
@@ -0,0 +1,86 @@
+package cryptdecrypt
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// effWordlist is the EFF large wordlist for dice-generated passphrases,
+// 7776 lines of five dice rolls and a word:
+// https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+//
+//go:embed eff_large_wordlist.txt
+var effWordlist string
+
+// dicewareWords returns the words of the EFF list
+var dicewareWords = sync.OnceValue(func() []string {
+	var words []string
+	for _, line := range strings.Split(strings.TrimSpace(effWordlist), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			words = append(words, fields[1])
+		}
+	}
+	return words
+})
+
+// randomIndex returns a uniformly distributed random number in [0, n)
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// GeneratePassword returns a random password of length characters from
+// charset and its entropy in bits. Every character is chosen independently
+// and uniformly; duplicates in charset are ignored.
+func GeneratePassword(length int, charset string) (string, float64, error) {
+	var chars []rune
+	seen := map[rune]bool{}
+	for _, c := range charset {
+		if !seen[c] {
+			seen[c] = true
+			chars = append(chars, c)
+		}
+	}
+	if len(chars) < 2 {
+		return "", 0, fmt.Errorf("the character set needs at least 2 different characters")
+	}
+	if length < 1 {
+		return "", 0, fmt.Errorf("invalid password length %d", length)
+	}
+
+	password := make([]rune, length)
+	for i := range password {
+		j, err := randomIndex(len(chars))
+		if err != nil {
+			return "", 0, err
+		}
+		password[i] = chars[j]
+	}
+	return string(password), float64(length) * math.Log2(float64(len(chars))), nil
+}
+
+// GeneratePassphrase returns words random words of the EFF large wordlist
+// joined by separator and the entropy in bits, about 12.9 per word
+func GeneratePassphrase(words int, separator string) (string, float64, error) {
+	if words < 1 {
+		return "", 0, fmt.Errorf("invalid number of words %d", words)
+	}
+	list := dicewareWords()
+	chosen := make([]string, words)
+	for i := range chosen {
+		j, err := randomIndex(len(list))
+		if err != nil {
+			return "", 0, err
+		}
+		chosen[i] = list[j]
+	}
+	return strings.Join(chosen, separator), float64(words) * math.Log2(float64(len(list))), nil
+}
@@ -0,0 +1,245 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Secrets files are .env or YAML files in which only the values are
+// encrypted, so keys and comments stay readable and diffs stay meaningful:
+//
+//	DB_USER=ENC[v1,str,<base64 nonce and AES-256-GCM ciphertext>]
+//	cryptdecrypt_metadata=<base64 envelope header>.<base64 MAC>
+//
+// All values share one password-derived key. The KDF parameters and salt are
+// kept in an envelope header without payload in the metadata entry. Every
+// value is bound to its key path and type as additional data, and a MAC over
+// all paths and encrypted values detects swapped or removed entries.
+const (
+	secretsMetadataKey = "cryptdecrypt_metadata"
+	secretsValuePrefix = "ENC[v1,"
+	secretsValueSuffix = "]"
+)
+
+// SecretEntry is one value of a secrets file
+type SecretEntry struct {
+	Path  string // key, nested YAML keys are joined with '.'
+	Value string // plaintext or ENC[...]
+	Type  string // YAML type without '!!', always "str" for .env files
+	set   func(value, typ string)
+}
+
+// Set replaces the value in the underlying document
+func (e *SecretEntry) Set(value, typ string) {
+	e.Value, e.Type = value, typ
+	e.set(value, typ)
+}
+
+// encrypted reports whether the value is in the ENC[...] form
+func (e *SecretEntry) encrypted() bool {
+	return strings.HasPrefix(e.Value, secretsValuePrefix) && strings.HasSuffix(e.Value, secretsValueSuffix)
+}
+
+// SecretsFile is a parsed .env or YAML secrets file
+type SecretsFile interface {
+	// Entries returns the values in file order, without the metadata entry
+	Entries() []*SecretEntry
+	Metadata() string
+	SetMetadata(value string)
+	// StripMetadata removes the metadata entry, e.g. for a decrypted copy
+	StripMetadata()
+	Marshal() ([]byte, error)
+}
+
+// ParseSecrets parses data as YAML or .env depending on the file name
+func ParseSecrets(name string, data []byte) (SecretsFile, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return parseYAMLSecrets(data)
+	default:
+		return parseDotenv(data)
+	}
+}
+
+// SecretsKey encrypts and authenticates the values of one secrets file
+type SecretsKey struct {
+	header []byte
+	kdf    KDFParams
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewSecretsKey derives a fresh key with a new random salt
+func NewSecretsKey(password string, kdf KDFParams) (*SecretsKey, error) {
+	h := &header{
+		Version: envelopeVersion,
+		Cipher:  AES256GCM,
+		KDF:     kdf,
+		Salt:    make([]byte, saltLength),
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return nil, err
+	}
+	return deriveSecretsKey(h, h.marshal(), password)
+}
+
+// deriveSecretsKey derives the value and MAC keys for a header
+func deriveSecretsKey(h *header, raw []byte, password string) (*SecretsKey, error) {
+	master, err := deriveKey([]byte(password), h.Salt, h.KDF)
+	if err != nil {
+		return nil, err
+	}
+	valueKey, err := hkdfKey(master, h.Salt, "cryptdecrypt secrets values", keyLength)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := hkdfKey(master, h.Salt, "cryptdecrypt secrets mac", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h.Cipher, valueKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretsKey{header: raw, kdf: h.KDF, aead: aead, macKey: macKey}, nil
+}
+
+// OpenSecretsKey derives the key from the metadata of an encrypted file and
+// verifies the MAC over its values, which also detects a wrong password
+func OpenSecretsKey(doc SecretsFile, password string) (*SecretsKey, error) {
+	headerText, macText, ok := strings.Cut(doc.Metadata(), ".")
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid %s", ErrCorrupted, secretsMetadataKey)
+	}
+	raw, err := base64.StdEncoding.DecodeString(headerText)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s: %v", ErrCorrupted, secretsMetadataKey, err)
+	}
+	mac, err := base64.StdEncoding.DecodeString(macText)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s: %v", ErrCorrupted, secretsMetadataKey, err)
+	}
+	h, headerRaw, err := readHeader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveSecretsKey(h, headerRaw, password)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, key.mac(doc.Entries())) {
+		return nil, fmt.Errorf("%w: MAC mismatch, or the file was modified", ErrWrongPassword)
+	}
+	return key, nil
+}
+
+// KDF returns the key derivation parameters of the key
+func (k *SecretsKey) KDF() KDFParams {
+	return k.kdf
+}
+
+// mac authenticates the paths and encrypted values in file order
+func (k *SecretsKey) mac(entries []*SecretEntry) []byte {
+	mac := hmac.New(sha256.New, k.macKey)
+	mac.Write(k.header)
+	for _, e := range entries {
+		if e.encrypted() {
+			fmt.Fprintf(mac, "%s\x00%s\n", e.Path, e.Value)
+		}
+	}
+	return mac.Sum(nil)
+}
+
+// metadata returns the value of the metadata entry for the entries
+func (k *SecretsKey) metadata(entries []*SecretEntry) string {
+	return base64.StdEncoding.EncodeToString(k.header) + "." + base64.StdEncoding.EncodeToString(k.mac(entries))
+}
+
+// additionalData binds a value to its path and type
+func secretsAdditionalData(path, typ string) []byte {
+	return []byte(path + "\x00" + typ)
+}
+
+// encryptValue returns the ENC[...] form of a plaintext value
+func (k *SecretsKey) encryptValue(path, typ, plaintext string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(plaintext), secretsAdditionalData(path, typ))
+	return secretsValuePrefix + typ + "," + base64.StdEncoding.EncodeToString(sealed) + secretsValueSuffix, nil
+}
+
+// decryptValue returns the plaintext and type of an ENC[...] value
+func (k *SecretsKey) decryptValue(path, value string) (string, string, error) {
+	inner := strings.TrimSuffix(strings.TrimPrefix(value, secretsValuePrefix), secretsValueSuffix)
+	typ, encoded, ok := strings.Cut(inner, ",")
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s: invalid encrypted value", ErrCorrupted, path)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", "", fmt.Errorf("%w: %s: invalid encrypted value", ErrCorrupted, path)
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, secretsAdditionalData(path, typ))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: value failed authentication", ErrCorrupted, path)
+	}
+	return string(plaintext), typ, nil
+}
+
+// DecryptedValues maps the path and type of every value of a decrypted
+// secrets file to its plaintext and its former ciphertext
+type DecryptedValues map[string][2]string
+
+// EncryptSecrets encrypts all plaintext values of doc. Values that are
+// already encrypted are kept; values that are unchanged since DecryptSecrets
+// returned previous keep their ciphertext, so diffs only show edits.
+func EncryptSecrets(doc SecretsFile, key *SecretsKey, previous DecryptedValues) error {
+	for _, e := range doc.Entries() {
+		if e.encrypted() {
+			continue
+		}
+		if old, ok := previous[e.Path+"\x00"+e.Type]; ok && old[0] == e.Value {
+			e.Set(old[1], "str")
+			continue
+		}
+		value, err := key.encryptValue(e.Path, e.Type, e.Value)
+		if err != nil {
+			return err
+		}
+		e.Set(value, "str")
+	}
+	doc.SetMetadata(key.metadata(doc.Entries()))
+	return nil
+}
+
+// DecryptSecrets replaces every value of doc by its plaintext and returns
+// the values for EncryptSecrets. Unencrypted values are rejected, as they
+// would not be covered by the MAC.
+func DecryptSecrets(doc SecretsFile, key *SecretsKey) (DecryptedValues, error) {
+	previous := make(DecryptedValues)
+	for _, e := range doc.Entries() {
+		if !e.encrypted() {
+			return nil, fmt.Errorf("%s: value is not encrypted, run 'secrets encrypt' first", e.Path)
+		}
+		plaintext, typ, err := key.decryptValue(e.Path, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		previous[e.Path+"\x00"+typ] = [2]string{plaintext, e.Value}
+		e.Set(plaintext, typ)
+	}
+	doc.StripMetadata()
+	return previous, nil
+}
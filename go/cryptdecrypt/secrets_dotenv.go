@@ -0,0 +1,173 @@
+package cryptdecrypt
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dotenvLine is one line of a .env file. Lines without a key, i.e. comments
+// and blank lines, are kept verbatim in raw.
+type dotenvLine struct {
+	raw    string
+	export bool
+	key    string
+	value  string
+
+	// comment is an inline comment after the value including the
+	// whitespace before it, written back unchanged
+	comment string
+}
+
+// dotenvFile is a parsed .env file
+type dotenvFile struct {
+	lines []*dotenvLine
+}
+
+var dotenvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// dotenvPlainValue matches values that can be written without quotes
+var dotenvPlainValue = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=\[\]-]*$`)
+
+// parseDotenv parses KEY=VALUE lines with optional 'export' prefix, single
+// or double quoted values and comments
+func parseDotenv(data []byte) (*dotenvFile, error) {
+	f := &dotenvFile{}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	for i, raw := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			f.lines = append(f.lines, &dotenvLine{raw: raw})
+			continue
+		}
+
+		line := &dotenvLine{}
+		if rest, ok := strings.CutPrefix(trimmed, "export "); ok {
+			line.export = true
+			trimmed = strings.TrimSpace(rest)
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !dotenvKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		line.key = key
+
+		value = strings.TrimSpace(value)
+		if quoted, comment, ok := cutDotenvQuoted(value); ok {
+			line.comment = comment
+			if value[0] == '"' {
+				line.value = unescapeDotenv(quoted)
+			} else {
+				line.value = quoted
+			}
+		} else {
+			// Unquoted values end at an inline comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				line.comment = value[i:]
+				value = strings.TrimSpace(value[:i])
+			}
+			line.value = value
+		}
+		f.lines = append(f.lines, line)
+	}
+	return f, nil
+}
+
+// cutDotenvQuoted splits a single or double quoted value from an inline
+// comment after its closing quote. ok is false if value is not quoted or
+// something else follows the closing quote.
+func cutDotenvQuoted(value string) (quoted, comment string, ok bool) {
+	if value == "" || value[0] != '"' && value[0] != '\'' {
+		return "", "", false
+	}
+	end := 1
+	for ; end < len(value) && value[end] != value[0]; end++ {
+		if value[0] == '"' && value[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(value) {
+		return "", "", false
+	}
+	comment = value[end+1:]
+	if rest := strings.TrimSpace(comment); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", "", false
+	}
+	return value[1:end], comment, true
+}
+
+// unescapeDotenv resolves the escapes allowed in double quoted values
+func unescapeDotenv(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+// quoteDotenv writes a value so that parseDotenv reads it back unchanged
+func quoteDotenv(s string) string {
+	if dotenvPlainValue.MatchString(s) {
+		return s
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s)
+	return `"` + escaped + `"`
+}
+
+func (f *dotenvFile) Entries() []*SecretEntry {
+	var entries []*SecretEntry
+	for _, line := range f.lines {
+		if line.key == "" || line.key == secretsMetadataKey {
+			continue
+		}
+		line := line
+		entries = append(entries, &SecretEntry{
+			Path:  line.key,
+			Value: line.value,
+			Type:  "str",
+			set:   func(value, _ string) { line.value = value },
+		})
+	}
+	return entries
+}
+
+func (f *dotenvFile) Metadata() string {
+	for _, line := range f.lines {
+		if line.key == secretsMetadataKey {
+			return line.value
+		}
+	}
+	return ""
+}
+
+func (f *dotenvFile) SetMetadata(value string) {
+	for _, line := range f.lines {
+		if line.key == secretsMetadataKey {
+			line.value = value
+			return
+		}
+	}
+	f.lines = append(f.lines, &dotenvLine{key: secretsMetadataKey, value: value})
+}
+
+func (f *dotenvFile) StripMetadata() {
+	lines := f.lines[:0]
+	for _, line := range f.lines {
+		if line.key != secretsMetadataKey {
+			lines = append(lines, line)
+		}
+	}
+	f.lines = lines
+}
+
+func (f *dotenvFile) Marshal() ([]byte, error) {
+	var sb strings.Builder
+	for _, line := range f.lines {
+		if line.key == "" {
+			sb.WriteString(line.raw + "\n")
+			continue
+		}
+		if line.export {
+			sb.WriteString("export ")
+		}
+		sb.WriteString(line.key + "=" + quoteDotenv(line.value) + line.comment + "\n")
+	}
+	return []byte(sb.String()), nil
+}
@@ -0,0 +1,219 @@
+package cryptdecrypt_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+const dotenvSecrets = `# database
+export DB_USER=admin
+DB_PASSWORD="p4ss word with \"quotes\""
+
+API_TOKEN=abc.def-123
+MULTILINE="line 1\nline 2"
+SESSION_KEY="k3y value" # rotated monthly
+PLAIN=plain.value # inline comment
+`
+
+const yamlSecrets = `# service configuration
+database:
+  user: admin # inline comment
+  password: 's3cret: with colon'
+  port: 5432
+replicas:
+  - host: a.example.com
+  - host: b.example.com
+enabled: true
+`
+
+// encryptSecrets returns the encrypted form of a plaintext secrets file
+func encryptSecrets(t *testing.T, name, plaintext string) string {
+	t.Helper()
+	doc, err := cryptdecrypt.ParseSecrets(name, []byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cryptdecrypt.NewSecretsKey("pw", fastKDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cryptdecrypt.EncryptSecrets(doc, key, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// decryptSecrets returns the plaintext form of an encrypted secrets file
+func decryptSecrets(name, encrypted, password string) (string, error) {
+	doc, err := cryptdecrypt.ParseSecrets(name, []byte(encrypted))
+	if err != nil {
+		return "", err
+	}
+	key, err := cryptdecrypt.OpenSecretsKey(doc, password)
+	if err != nil {
+		return "", err
+	}
+	if _, err := cryptdecrypt.DecryptSecrets(doc, key); err != nil {
+		return "", err
+	}
+	data, err := doc.Marshal()
+	return string(data), err
+}
+
+func TestSecretsRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name, plaintext string
+		values          []string // must not appear in the encrypted file
+		visible         []string // must stay readable
+	}{
+		{"app.env", dotenvSecrets, []string{"admin", "p4ss", "abc.def-123", "line 1", "k3y", "plain.value"}, []string{"# database", "export DB_USER=", "API_TOKEN=", "# rotated monthly", "# inline comment"}},
+		{"app.yaml", yamlSecrets, []string{"admin", "s3cret", "5432", "a.example.com"}, []string{"# service configuration", "# inline comment", "replicas:", "port:"}},
+	} {
+		doc, err := cryptdecrypt.ParseSecrets(tt.name, []byte(tt.plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := doc.Marshal(); err != nil || string(data) != tt.plaintext {
+			t.Errorf("%s: Marshal after parsing =\n%s, %v", tt.name, data, err)
+		}
+		for _, e := range doc.Entries() {
+			if e.Path == "SESSION_KEY" && e.Value != "k3y value" || e.Path == "PLAIN" && e.Value != "plain.value" {
+				t.Errorf("%s: %s = %q", tt.name, e.Path, e.Value)
+			}
+		}
+
+		encrypted := encryptSecrets(t, tt.name, tt.plaintext)
+		for _, v := range tt.values {
+			if strings.Contains(encrypted, v) {
+				t.Errorf("%s: %q is readable in the encrypted file:\n%s", tt.name, v, encrypted)
+			}
+		}
+		for _, v := range tt.visible {
+			if !strings.Contains(encrypted, v) {
+				t.Errorf("%s: %q is missing in the encrypted file:\n%s", tt.name, v, encrypted)
+			}
+		}
+
+		decrypted, err := decryptSecrets(tt.name, encrypted, "pw")
+		if err != nil || decrypted != tt.plaintext {
+			t.Errorf("%s: decrypted =\n%s, %v", tt.name, decrypted, err)
+		}
+		if _, err := decryptSecrets(tt.name, encrypted, "wrong"); !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+			t.Errorf("%s, wrong password: got %v, want ErrWrongPassword", tt.name, err)
+		}
+	}
+}
+
+// Unchanged values keep their ciphertext when a decrypted file is encrypted
+// again, so only edited lines show up in a diff
+func TestSecretsKeepCiphertext(t *testing.T) {
+	encrypted := encryptSecrets(t, "app.env", dotenvSecrets)
+	doc, err := cryptdecrypt.ParseSecrets("app.env", []byte(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cryptdecrypt.OpenSecretsKey(doc, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := cryptdecrypt.DecryptSecrets(doc, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range doc.Entries() {
+		if e.Path == "API_TOKEN" {
+			e.Set("new token", e.Type)
+		}
+	}
+	if err := cryptdecrypt.EncryptSecrets(doc, key, previous); err != nil {
+		t.Fatal(err)
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, after := strings.Split(encrypted, "\n"), strings.Split(string(data), "\n")
+	if len(before) != len(after) {
+		t.Fatalf("line count changed:\n%s", data)
+	}
+	for i := range before {
+		changed := before[i] != after[i]
+		want := strings.HasPrefix(before[i], "API_TOKEN=") || strings.HasPrefix(before[i], "cryptdecrypt_metadata=")
+		if changed != want {
+			t.Errorf("line %d changed %v, want %v:\n%s\n%s", i+1, changed, want, before[i], after[i])
+		}
+	}
+	if decrypted, err := decryptSecrets("app.env", string(data), "pw"); err != nil || !strings.Contains(decrypted, `API_TOKEN="new token"`) {
+		t.Errorf("decrypted =\n%s, %v", decrypted, err)
+	}
+}
+
+// Entries of an encrypted file cannot be removed, reordered, swapped or
+// added in plain without the change being detected
+func TestSecretsTampering(t *testing.T) {
+	encrypted := encryptSecrets(t, "app.env", dotenvSecrets)
+	lines := strings.Split(strings.TrimSuffix(encrypted, "\n"), "\n")
+	find := func(prefix string) int {
+		for i, line := range lines {
+			if strings.HasPrefix(line, prefix) {
+				return i
+			}
+		}
+		t.Fatalf("no line %s in\n%s", prefix, encrypted)
+		return -1
+	}
+	user, pass, token := find("export DB_USER="), find("DB_PASSWORD="), find("API_TOKEN=")
+	modified := func(f func(lines []string) []string) string {
+		return strings.Join(f(append([]string(nil), lines...)), "\n") + "\n"
+	}
+	valueOf := func(i int) string {
+		_, value, _ := strings.Cut(lines[i], "=")
+		return value
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want error // nil for any error
+	}{
+		{"deleted entry", modified(func(l []string) []string { return append(l[:token], l[token+1:]...) }), cryptdecrypt.ErrWrongPassword},
+		{"reordered entries", modified(func(l []string) []string { l[user], l[pass] = l[pass], l[user]; return l }), cryptdecrypt.ErrWrongPassword},
+		{"swapped values", modified(func(l []string) []string {
+			l[pass], l[token] = "DB_PASSWORD="+valueOf(token), "API_TOKEN="+valueOf(pass)
+			return l
+		}), cryptdecrypt.ErrWrongPassword},
+		{"copied value", modified(func(l []string) []string { return append(l, "COPY="+valueOf(pass)) }), cryptdecrypt.ErrWrongPassword},
+		{"modified ciphertext", modified(func(l []string) []string {
+			l[token] = strings.Replace(l[token], "ENC[v1,str,", "ENC[v1,str,A", 1)
+			return l
+		}), cryptdecrypt.ErrWrongPassword},
+		{"replaced by plaintext", modified(func(l []string) []string { l[token] = "API_TOKEN=evil"; return l }), nil},
+		{"injected plaintext", modified(func(l []string) []string { return append(l, "LD_PRELOAD=/tmp/evil.so") }), nil},
+		{"missing metadata", modified(func(l []string) []string { return l[:len(l)-1] }), nil},
+	}
+	for _, tt := range tests {
+		decrypted, err := decryptSecrets("app.env", tt.data, "pw")
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: got %q, %v, want an error", tt.name, decrypted, err)
+		}
+	}
+
+	// The same in a nested YAML document
+	encrypted = encryptSecrets(t, "app.yaml", yamlSecrets)
+	injected := strings.Replace(encrypted, "database:\n", "database:\n  host: evil.example.com\n", 1)
+	if decrypted, err := decryptSecrets("app.yaml", injected, "pw"); err == nil {
+		t.Errorf("injected YAML value: got %q, want an error", decrypted)
+	}
+	removed := strings.Replace(encrypted, "enabled:", "#enabled:", 1)
+	if _, err := decryptSecrets("app.yaml", removed, "pw"); !errors.Is(err, cryptdecrypt.ErrWrongPassword) {
+		t.Errorf("removed YAML value: got %v, want ErrWrongPassword", err)
+	}
+}
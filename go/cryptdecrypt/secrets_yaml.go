@@ -0,0 +1,129 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlSecrets is a parsed YAML file. Comments and key order survive a round
+// trip because the document is kept as a node tree.
+type yamlSecrets struct {
+	doc yaml.Node
+}
+
+// parseYAMLSecrets parses a YAML document whose top level is a mapping
+func parseYAMLSecrets(data []byte) (*yamlSecrets, error) {
+	y := &yamlSecrets{}
+	if err := yaml.Unmarshal(data, &y.doc); err != nil {
+		return nil, err
+	}
+	if y.doc.Kind == 0 {
+		// Empty file
+		y.doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if len(y.doc.Content) != 1 || y.doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level of a YAML secrets file must be a mapping")
+	}
+	return y, nil
+}
+
+// root returns the top-level mapping
+func (y *yamlSecrets) root() *yaml.Node {
+	return y.doc.Content[0]
+}
+
+func (y *yamlSecrets) Entries() []*SecretEntry {
+	var entries []*SecretEntry
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if path == "" && key == secretsMetadataKey {
+					continue
+				}
+				if path != "" {
+					key = path + "." + key
+				}
+				walk(node.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				walk(child, path+"."+strconv.Itoa(i))
+			}
+		case yaml.ScalarNode:
+			if node.Tag == "!!null" {
+				return
+			}
+			node := node
+			entries = append(entries, &SecretEntry{
+				Path:  path,
+				Value: node.Value,
+				Type:  strings.TrimPrefix(node.ShortTag(), "!!"),
+				set: func(value, typ string) {
+					// Types of custom tags keep their leading '!'
+					if !strings.HasPrefix(typ, "!") {
+						typ = "!!" + typ
+					}
+					node.Value, node.Tag, node.Style = value, typ, 0
+				},
+			})
+		}
+	}
+	walk(y.root(), "")
+	return entries
+}
+
+// metadataIndex returns the index of the metadata key in the root mapping
+func (y *yamlSecrets) metadataIndex() int {
+	root := y.root()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == secretsMetadataKey {
+			return i
+		}
+	}
+	return -1
+}
+
+func (y *yamlSecrets) Metadata() string {
+	if i := y.metadataIndex(); i >= 0 {
+		return y.root().Content[i+1].Value
+	}
+	return ""
+}
+
+func (y *yamlSecrets) SetMetadata(value string) {
+	if i := y.metadataIndex(); i >= 0 {
+		y.root().Content[i+1].Value = value
+		return
+	}
+	root := y.root()
+	root.Content = append(root.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: secretsMetadataKey},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+func (y *yamlSecrets) StripMetadata() {
+	if i := y.metadataIndex(); i >= 0 {
+		root := y.root()
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+	}
+}
+
+func (y *yamlSecrets) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&y.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
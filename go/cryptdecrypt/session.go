@@ -0,0 +1,181 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Session encrypts and decrypts many small values with one password, e.g.
+// for batch jobs. Deriving the key is by design the expensive part, so a
+// session derives it once per salt: all values it encrypts share one salt
+// and differ in their random nonce, and the keys for decryption are cached
+// by salt and KDF parameters, the maxSessionKeys most recently used ones.
+// A Session is safe for concurrent use.
+type Session struct {
+	creds Credentials
+	opts  Options
+
+	encryptOnce sync.Once
+	encryptH    *header
+	encryptKey  []byte
+	encryptErr  error
+
+	mu   sync.Mutex
+	keys map[string]*list.Element // of *sessionKey
+	lru  *list.List               // most recently used first
+}
+
+// maxSessionKeys bounds the key cache, every distinct salt adds a key
+const maxSessionKeys = 256
+
+// sessionKey is a cached key, derived once even if several goroutines ask
+// for it at the same time
+type sessionKey struct {
+	id   string
+	once sync.Once
+	key  []byte
+	err  error
+}
+
+// NewSession returns a session for the credentials. opts select the
+// algorithms for encryption, nil selects the defaults.
+func NewSession(creds Credentials, opts *Options) *Session {
+	o := opts.withDefaults()
+	if o.Keyfile == nil {
+		o.Keyfile = creds.Keyfile
+	}
+	return &Session{creds: creds, opts: o, keys: map[string]*list.Element{}, lru: list.New()}
+}
+
+// key returns the cached key for an envelope header
+func (s *Session) key(h *header) ([]byte, error) {
+	id := sessionKeyID(h)
+	s.mu.Lock()
+	var k *sessionKey
+	if e, ok := s.keys[id]; ok {
+		s.lru.MoveToFront(e)
+		k = e.Value.(*sessionKey)
+	} else {
+		k = &sessionKey{id: id}
+		s.keys[id] = s.lru.PushFront(k)
+		if s.lru.Len() > maxSessionKeys {
+			oldest := s.lru.Remove(s.lru.Back()).(*sessionKey)
+			delete(s.keys, oldest.id)
+		}
+	}
+	s.mu.Unlock()
+
+	k.once.Do(func() {
+		k.key, k.err = decryptionKey(h, s.creds)
+	})
+	return k.key, k.err
+}
+
+// sessionKeyID identifies the key of a header in the cache
+func sessionKeyID(h *header) string {
+	return fmt.Sprintf("%x/%v/%d", h.Salt, h.KDF.values(), h.Flags&flagKeyfile)
+}
+
+// EncryptText encrypts plaintext into a Base64 envelope like the function
+// EncryptText, with the key of the session
+func (s *Session) EncryptText(plaintext []byte) (string, error) {
+	s.encryptOnce.Do(func() {
+		h := &header{
+			Version: envelopeVersion,
+			Cipher:  s.opts.Cipher,
+			KDF:     s.opts.KDF,
+			Salt:    make([]byte, saltLength),
+		}
+		if s.opts.Keyfile != nil {
+			h.Flags |= flagKeyfile
+		}
+		if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+			s.encryptErr = err
+			return
+		}
+		s.encryptH = h
+		s.encryptKey, s.encryptErr = envelopeKey(h, s.creds.Password, s.opts.Keyfile)
+	})
+	if s.encryptErr != nil {
+		return "", s.encryptErr
+	}
+
+	h := *s.encryptH
+	if s.opts.Signer != nil {
+		h.Flags |= flagSigned
+	}
+	aead, err := newAEAD(h.Cipher, s.encryptKey)
+	if err != nil {
+		return "", err
+	}
+	h.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, h.Nonce); err != nil {
+		return "", err
+	}
+	raw := h.marshal()
+	if s.opts.Signer != nil {
+		if plaintext, err = appendSignature(raw, plaintext, s.opts.Signer); err != nil {
+			return "", err
+		}
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(raw, h.Nonce, plaintext, raw)), nil
+}
+
+// DecryptText decrypts every text form accepted by DecryptTextSigned. Keys
+// of armored and Base64 envelopes are cached, other forms are decrypted
+// without the cache.
+func (s *Session) DecryptText(text string) ([]byte, *Signature, error) {
+	text = strings.TrimSpace(text)
+	var data []byte
+	var err error
+	switch {
+	case strings.HasPrefix(text, envelopeArmorHeader):
+		if data, err = dearmorEnvelope(text); err != nil {
+			return nil, nil, err
+		}
+	case strings.HasPrefix(text, envelopeBase64Prefix):
+		if data, err = base64.StdEncoding.DecodeString(text); err != nil {
+			return nil, nil, fmt.Errorf("%w: error decoding envelope: %v", ErrCorrupted, err)
+		}
+	default:
+		return DecryptTextSigned(text, s.creds)
+	}
+
+	h, raw, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Flags&flagStream != 0 {
+		return DecryptTextSigned(text, s.creds)
+	}
+	key, err := s.key(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := openPayload(aead, h, raw, data[len(raw):])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sig *Signature
+	if h.Flags&flagSigned != 0 {
+		var buf bytes.Buffer
+		verifier := newVerifyingWriter(&buf, raw)
+		verifier.Write(plaintext)
+		if sig, err = verifier.finish(s.creds.Signers); err != nil {
+			return nil, nil, err
+		}
+		plaintext = buf.Bytes()
+	}
+	return plaintext, sig, checkSigned(sig, s.creds)
+}
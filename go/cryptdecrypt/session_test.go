@@ -0,0 +1,43 @@
+package cryptdecrypt
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSessionKeyCache(t *testing.T) {
+	kdf, err := NewKDFParams("scrypt", 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(Credentials{Password: "pw"}, nil)
+	header := func(i int) *header {
+		return &header{KDF: kdf, Salt: []byte(fmt.Sprintf("salt %11d", i))}
+	}
+
+	first, err := s.key(header(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 2*maxSessionKeys; i++ {
+		if _, err := s.key(header(i)); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the first key in use, it must survive
+		if i%10 == 0 {
+			s.key(header(0))
+		}
+	}
+	if len(s.keys) != maxSessionKeys || s.lru.Len() != maxSessionKeys {
+		t.Fatalf("%d keys cached, %d in the list, want %d", len(s.keys), s.lru.Len(), maxSessionKeys)
+	}
+	if _, ok := s.keys[sessionKeyID(header(0))]; !ok {
+		t.Error("the most recently used key was evicted")
+	}
+	if _, ok := s.keys[sessionKeyID(header(1))]; ok {
+		t.Error("the least recently used key was kept")
+	}
+	if again, err := s.key(header(0)); err != nil || string(again) != string(first) {
+		t.Errorf("cached key differs: %v", err)
+	}
+}
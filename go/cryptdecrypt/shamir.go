@@ -0,0 +1,194 @@
+package cryptdecrypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Shamir secret sharing over GF(2^8): every byte of the secret is the
+// constant term of a random polynomial of degree threshold-1, and share i
+// holds the values of all polynomials at x=i. Any threshold shares
+// reconstruct the secret, fewer reveal nothing about it.
+//
+// Shares are printed as
+//
+//	CDSS1-<split id>-<threshold>-<index>-<base32 data>-<checksum>
+//
+// The split id tells shares of different splits apart and the checksum (the
+// first four bytes of SHA-256 over the text before it) catches typos.
+const sharePrefix = "CDSS1"
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Share is one part of a split secret
+type Share struct {
+	ID        [4]byte
+	Threshold byte
+	Index     byte
+	Data      []byte
+}
+
+// String returns the printable form of the share
+func (s *Share) String() string {
+	body := fmt.Sprintf("%s-%X-%d-%d-%s", sharePrefix, s.ID, s.Threshold, s.Index, shareEncoding.EncodeToString(s.Data))
+	sum := sha256.Sum256([]byte(body))
+	return body + "-" + strings.ToUpper(hex.EncodeToString(sum[:4]))
+}
+
+// ParseShare parses and verifies the printable form of a share
+func ParseShare(text string) (*Share, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	i := strings.LastIndexByte(text, '-')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed share")
+	}
+	body, checksum := text[:i], text[i+1:]
+	sum := sha256.Sum256([]byte(body))
+	if checksum != strings.ToUpper(hex.EncodeToString(sum[:4])) {
+		return nil, fmt.Errorf("share checksum mismatch, check for typos")
+	}
+
+	parts := strings.Split(body, "-")
+	if len(parts) != 5 || parts[0] != sharePrefix {
+		return nil, fmt.Errorf("malformed share")
+	}
+	s := &Share{}
+	id, err := hex.DecodeString(parts[1])
+	if err != nil || len(id) != len(s.ID) {
+		return nil, fmt.Errorf("malformed share id")
+	}
+	copy(s.ID[:], id)
+	threshold, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil || threshold < 2 {
+		return nil, fmt.Errorf("malformed share threshold")
+	}
+	index, err := strconv.ParseUint(parts[3], 10, 8)
+	if err != nil || index == 0 {
+		return nil, fmt.Errorf("malformed share index")
+	}
+	s.Threshold, s.Index = byte(threshold), byte(index)
+	if s.Data, err = shareEncoding.DecodeString(parts[4]); err != nil || len(s.Data) == 0 {
+		return nil, fmt.Errorf("malformed share data")
+	}
+	return s, nil
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial x^8+x^4+x^3+x+1.
+// It avoids lookup tables and branches on secret data.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = a<<1 ^ carry&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse as a^254
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 7; i++ {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// SplitSecret splits secret into n shares of which threshold are needed
+func SplitSecret(secret []byte, n, threshold int) ([]*Share, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("need 2 <= threshold <= shares <= 255")
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+
+	var id [4]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, err
+	}
+	shares := make([]*Share, n)
+	for i := range shares {
+		shares[i] = &Share{ID: id, Threshold: byte(threshold), Index: byte(i + 1), Data: make([]byte, len(secret))}
+	}
+
+	coefficients := make([]byte, threshold)
+	for pos, b := range secret {
+		coefficients[0] = b
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for _, s := range shares {
+			// Horner's method
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, s.Index) ^ coefficients[c]
+			}
+			s.Data[pos] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares reconstructs the secret from at least threshold shares
+func CombineShares(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares given")
+	}
+	first := shares[0]
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.ID != first.ID || s.Threshold != first.Threshold || len(s.Data) != len(first.Data) {
+			return nil, fmt.Errorf("shares belong to different splits")
+		}
+		if seen[s.Index] {
+			return nil, fmt.Errorf("share %d was given twice", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(shares) < int(first.Threshold) {
+		return nil, fmt.Errorf("%d shares are needed, only %d given", first.Threshold, len(shares))
+	}
+	shares = shares[:first.Threshold]
+
+	// Lagrange interpolation at x=0; subtraction is XOR in GF(2^8)
+	secret := make([]byte, len(first.Data))
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(sj.Index, gfInv(si.Index^sj.Index)))
+			}
+		}
+		for pos := range secret {
+			secret[pos] ^= gfMul(si.Data[pos], basis)
+		}
+	}
+	return secret, nil
+}
+
+// ParseShares reads shares from a file with one share per line; empty lines
+// and '#' comments as written by the split command are ignored
+func ParseShares(r io.Reader) ([]*Share, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var shares []*Share
+	for _, line := range lines {
+		s, err := ParseShare(line)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, nil
+}
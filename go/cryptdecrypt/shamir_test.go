@@ -0,0 +1,118 @@
+package cryptdecrypt_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+func TestShares(t *testing.T) {
+	secret := []byte("break glass password")
+	shares, err := cryptdecrypt.SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every combination of three shares, in any order, and more than three
+	for _, indexes := range [][]int{{0, 1, 2}, {0, 1, 3}, {0, 1, 4}, {0, 2, 3}, {0, 2, 4}, {0, 3, 4}, {1, 2, 3}, {1, 2, 4}, {1, 3, 4}, {2, 3, 4}, {4, 0, 2}, {3, 1, 0, 4}, {0, 1, 2, 3, 4}} {
+		var parsed []*cryptdecrypt.Share
+		for _, i := range indexes {
+			s, err := cryptdecrypt.ParseShare(shares[i].String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed = append(parsed, s)
+		}
+		combined, err := cryptdecrypt.CombineShares(parsed)
+		if err != nil || !bytes.Equal(combined, secret) {
+			t.Errorf("CombineShares(%v) = %q, %v, want %q", indexes, combined, err, secret)
+		}
+	}
+
+	if _, err := cryptdecrypt.CombineShares(shares[:2]); err == nil {
+		t.Error("CombineShares succeeded below the threshold")
+	}
+	if _, err := cryptdecrypt.CombineShares([]*cryptdecrypt.Share{shares[0], shares[1], shares[1]}); err == nil {
+		t.Error("CombineShares accepted a share twice")
+	}
+	other, err := cryptdecrypt.SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cryptdecrypt.CombineShares([]*cryptdecrypt.Share{shares[0], shares[1], other[2]}); err == nil {
+		t.Error("CombineShares mixed shares of two splits")
+	}
+	if bytes.Equal(shares[0].Data, other[0].Data) {
+		t.Error("two splits of the same secret produced the same share")
+	}
+}
+
+func TestSplitSecretParameters(t *testing.T) {
+	for _, tt := range []struct {
+		n, threshold int
+		secret       string
+	}{
+		{3, 1, "secret"},
+		{2, 3, "secret"},
+		{256, 2, "secret"},
+		{3, 2, ""},
+	} {
+		if _, err := cryptdecrypt.SplitSecret([]byte(tt.secret), tt.n, tt.threshold); err == nil {
+			t.Errorf("SplitSecret(%q, %d, %d) succeeded", tt.secret, tt.n, tt.threshold)
+		}
+	}
+	shares, err := cryptdecrypt.SplitSecret([]byte("x"), 255, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if combined, err := cryptdecrypt.CombineShares(shares); err != nil || string(combined) != "x" {
+		t.Errorf("255 of 255: CombineShares = %q, %v", combined, err)
+	}
+}
+
+func TestParseShare(t *testing.T) {
+	shares, err := cryptdecrypt.SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := shares[1].String()
+	if !strings.HasPrefix(text, "CDSS1-") {
+		t.Errorf("String() = %q", text)
+	}
+
+	// Typed in lower case and with surrounding space
+	s, err := cryptdecrypt.ParseShare("  " + strings.ToLower(text) + "\n")
+	if err != nil || s.Index != 2 || s.Threshold != 2 || s.ID != shares[1].ID || !bytes.Equal(s.Data, shares[1].Data) {
+		t.Errorf("ParseShare = %+v, %v", s, err)
+	}
+
+	// Every typo is caught by the checksum
+	for i := range text {
+		typo := []byte(text)
+		if typo[i] == 'A' {
+			typo[i] = 'B'
+		} else {
+			typo[i] = 'A'
+		}
+		if _, err := cryptdecrypt.ParseShare(string(typo)); err == nil {
+			t.Errorf("typo at %d was accepted: %s", i, typo)
+		}
+	}
+	for _, malformed := range []string{"", "CDSS1", text[:len(text)-1], strings.Replace(text, "CDSS1", "CDSS2", 1)} {
+		if _, err := cryptdecrypt.ParseShare(malformed); err == nil {
+			t.Errorf("ParseShare(%q) succeeded", malformed)
+		}
+	}
+
+	// The format of the split command: comments and blank lines
+	file := "# share 1 of 3\n" + shares[0].String() + "\n\n# share 3 of 3\n" + shares[2].String() + "\n"
+	parsed, err := cryptdecrypt.ParseShares(strings.NewReader(file))
+	if err != nil || len(parsed) != 2 {
+		t.Fatalf("ParseShares = %v, %v", parsed, err)
+	}
+	if combined, err := cryptdecrypt.CombineShares(parsed); err != nil || string(combined) != "secret" {
+		t.Errorf("CombineShares = %q, %v", combined, err)
+	}
+}
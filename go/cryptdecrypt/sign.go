@@ -0,0 +1,340 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// Signatures are Ed25519ph (RFC 8032) over the SHA-512 of the data, so files
+// of any size are signed in one pass. A detached signature is a small text
+// file:
+//
+//	signed-by: cdsign1...
+//	signature: <base64 signature>
+//
+// Envelopes with flagSigned carry a signature encrypted at the end of the
+// plaintext, as signer public key || signature (signatureTrailerSize bytes).
+// Decryption strips and verifies it. It covers the envelope header followed
+// by the plaintext and uses its own context, so a recipient cannot encrypt
+// the signed plaintext for someone else under the signer's name, and a
+// detached signature cannot be embedded.
+const (
+	signPublicHRP            = "cdsign"
+	signSecretHRP            = "CDSIGN-SECRET-KEY-"
+	signatureContext         = "cryptdecrypt signature v1"
+	envelopeSignatureContext = "cryptdecrypt envelope signature v1"
+	signatureTrailerSize     = ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+var (
+	// ErrBadSignature is returned when a signature does not match the data
+	ErrBadSignature = errors.New("bad signature")
+
+	// ErrUnknownSigner is returned when data is not signed by any of the
+	// trusted signers, or not signed at all
+	ErrUnknownSigner = errors.New("not signed by a trusted signer")
+)
+
+// SigningKey is an Ed25519 private key
+type SigningKey struct {
+	key ed25519.PrivateKey
+}
+
+// VerifyingKey is an Ed25519 public key
+type VerifyingKey struct {
+	key ed25519.PublicKey
+}
+
+// GenerateSigningKey creates a new random Ed25519 signing key
+func GenerateSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{key: key}, nil
+}
+
+// String returns the signing key as CDSIGN-SECRET-KEY-1...
+func (k *SigningKey) String() string {
+	s, _ := bech32Encode(signSecretHRP, k.key.Seed())
+	return strings.ToUpper(s)
+}
+
+// Public returns the verifying key of the signing key
+func (k *SigningKey) Public() *VerifyingKey {
+	return &VerifyingKey{key: k.key.Public().(ed25519.PublicKey)}
+}
+
+// String returns the verifying key as cdsign1...
+func (v *VerifyingKey) String() string {
+	s, _ := bech32Encode(signPublicHRP, v.key)
+	return s
+}
+
+// Equal reports whether both keys are the same
+func (v *VerifyingKey) Equal(other *VerifyingKey) bool {
+	return other != nil && v.key.Equal(other.key)
+}
+
+// ParseSigningKey parses a CDSIGN-SECRET-KEY-1... string
+func ParseSigningKey(s string) (*SigningKey, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signing key: %v", err)
+	}
+	if hrp != strings.ToLower(signSecretHRP) || len(data) != ed25519.SeedSize {
+		return nil, fmt.Errorf("malformed signing key: unexpected type %q", hrp)
+	}
+	return &SigningKey{key: ed25519.NewKeyFromSeed(data)}, nil
+}
+
+// ParseVerifyingKey parses a cdsign1... public key
+func ParseVerifyingKey(s string) (*VerifyingKey, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signer %q: %v", s, err)
+	}
+	if hrp != signPublicHRP || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("malformed signer %q: unexpected type %q", s, hrp)
+	}
+	return &VerifyingKey{key: data}, nil
+}
+
+// ParseSigningKeys reads a key file as written by WriteSigningKey
+func ParseSigningKeys(r io.Reader) ([]*SigningKey, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*SigningKey
+	for _, line := range lines {
+		key, err := ParseSigningKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing key found")
+	}
+	return keys, nil
+}
+
+// ParseVerifyingKeys reads a file with one cdsign1... key per line
+func ParseVerifyingKeys(r io.Reader) ([]*VerifyingKey, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*VerifyingKey
+	for _, line := range lines {
+		key, err := ParseVerifyingKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// WriteSigningKey writes a new signing key with its public key as comment
+func WriteSigningKey(w io.Writer, k *SigningKey) error {
+	_, err := fmt.Fprintf(w, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), k.Public(), k)
+	return err
+}
+
+// Signature is an Ed25519ph signature together with its signer
+type Signature struct {
+	Signer *VerifyingKey
+	Data   []byte
+}
+
+// String returns the detached signature file content
+func (s *Signature) String() string {
+	return fmt.Sprintf("signed-by: %s\nsignature: %s\n", s.Signer, base64.StdEncoding.EncodeToString(s.Data))
+}
+
+// ParseSignature parses a detached signature file
+func ParseSignature(text string) (*Signature, error) {
+	lines, err := keyLines(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for _, line := range lines {
+		name, value, _ := strings.Cut(line, ":")
+		fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	signer, err := ParseVerifyingKey(fields["signed-by"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(fields["signature"])
+	if err != nil || len(data) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: invalid signature line", ErrCorrupted)
+	}
+	return &Signature{Signer: signer, Data: data}, nil
+}
+
+// signatureOptions select Ed25519ph with the context of detached and
+// embedded signatures
+var (
+	signatureOptions         = &ed25519.Options{Hash: crypto.SHA512, Context: signatureContext}
+	envelopeSignatureOptions = &ed25519.Options{Hash: crypto.SHA512, Context: envelopeSignatureContext}
+)
+
+// sign signs a SHA-512 digest
+func (k *SigningKey) sign(digest []byte, opts *ed25519.Options) (*Signature, error) {
+	data, err := k.key.Sign(nil, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{Signer: k.Public(), Data: data}, nil
+}
+
+// verify checks the signature of a SHA-512 digest and, if trusted is not
+// empty, that the signer is one of the trusted keys
+func (s *Signature) verify(digest []byte, opts *ed25519.Options, trusted []*VerifyingKey) error {
+	if ed25519.VerifyWithOptions(s.Signer.key, digest, s.Data, opts) != nil {
+		return ErrBadSignature
+	}
+	if len(trusted) == 0 {
+		return nil
+	}
+	for _, t := range trusted {
+		if t.Equal(s.Signer) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signed by %s", ErrUnknownSigner, s.Signer)
+}
+
+// Sign reads r until EOF and returns a detached signature of its content
+func Sign(r io.Reader, key *SigningKey) (*Signature, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return key.sign(h.Sum(nil), signatureOptions)
+}
+
+// Verify reads r until EOF and checks the detached signature of its content.
+// trusted must contain at least one key, a signature by anyone else fails
+// with ErrUnknownSigner.
+func Verify(r io.Reader, sig *Signature, trusted []*VerifyingKey) error {
+	if len(trusted) == 0 {
+		return fmt.Errorf("%w: no trusted signers given", ErrUnknownSigner)
+	}
+	h := sha512.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	return sig.verify(h.Sum(nil), signatureOptions, trusted)
+}
+
+// envelopeHash returns the hash of an embedded signature, which starts with
+// the raw envelope header
+func envelopeHash(header []byte) hash.Hash {
+	h := sha512.New()
+	h.Write(header)
+	return h
+}
+
+// appendSignature returns a copy of plaintext followed by its signature
+// trailer, for envelopes that are sealed in one piece
+func appendSignature(header, plaintext []byte, key *SigningKey) ([]byte, error) {
+	h := envelopeHash(header)
+	h.Write(plaintext)
+	sig, err := key.sign(h.Sum(nil), envelopeSignatureOptions)
+	if err != nil {
+		return nil, err
+	}
+	signed := append([]byte(nil), plaintext...)
+	signed = append(signed, sig.Signer.key...)
+	return append(signed, sig.Data...), nil
+}
+
+// signingReader passes src through and appends the signature trailer of its
+// content and the envelope header at EOF
+type signingReader struct {
+	src     io.Reader
+	key     *SigningKey
+	hash    hash.Hash
+	trailer []byte // remaining trailer, nil until src is exhausted
+	done    bool
+}
+
+func newSigningReader(src io.Reader, key *SigningKey, header []byte) *signingReader {
+	return &signingReader{src: src, key: key, hash: envelopeHash(header)}
+}
+
+func (s *signingReader) Read(p []byte) (int, error) {
+	if !s.done {
+		n, err := s.src.Read(p)
+		s.hash.Write(p[:n])
+		if err != io.EOF {
+			return n, err
+		}
+		sig, err := s.key.sign(s.hash.Sum(nil), envelopeSignatureOptions)
+		if err != nil {
+			return n, err
+		}
+		s.trailer = append(append([]byte(nil), sig.Signer.key...), sig.Data...)
+		s.done = true
+		if n > 0 {
+			return n, nil
+		}
+	}
+	if len(s.trailer) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.trailer)
+	s.trailer = s.trailer[n:]
+	return n, nil
+}
+
+// verifyingWriter passes everything but the signature trailer on to dst
+type verifyingWriter struct {
+	dst  io.Writer
+	hash hash.Hash
+	tail []byte // last bytes written, possibly the trailer
+}
+
+func newVerifyingWriter(dst io.Writer, header []byte) *verifyingWriter {
+	return &verifyingWriter{dst: dst, hash: envelopeHash(header)}
+}
+
+func (v *verifyingWriter) Write(p []byte) (int, error) {
+	v.tail = append(v.tail, p...)
+	if n := len(v.tail) - signatureTrailerSize; n > 0 {
+		v.hash.Write(v.tail[:n])
+		if _, err := v.dst.Write(v.tail[:n]); err != nil {
+			return 0, err
+		}
+		v.tail = append(v.tail[:0], v.tail[n:]...)
+	}
+	return len(p), nil
+}
+
+// finish verifies the trailer after all plaintext was written
+func (v *verifyingWriter) finish(trusted []*VerifyingKey) (*Signature, error) {
+	if len(v.tail) != signatureTrailerSize {
+		return nil, fmt.Errorf("%w: signature missing", ErrCorrupted)
+	}
+	sig := &Signature{
+		Signer: &VerifyingKey{key: bytes.Clone(v.tail[:ed25519.PublicKeySize])},
+		Data:   bytes.Clone(v.tail[ed25519.PublicKeySize:]),
+	}
+	return sig, sig.verify(v.hash.Sum(nil), envelopeSignatureOptions, trusted)
+}
@@ -0,0 +1,88 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// sealSigned builds an envelope with flagSigned around plaintext that
+// already ends with a signature trailer, as someone would who wants to pass
+// on a signature that was made for another envelope
+func sealSigned(t *testing.T, plaintext []byte, password string) []byte {
+	t.Helper()
+	kdf, err := NewKDFParams("scrypt", 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &header{Version: envelopeVersion, Flags: flagSigned, Cipher: AES256GCM, KDF: kdf, Salt: make([]byte, saltLength), Nonce: make([]byte, 12)}
+	rand.Read(h.Salt)
+	rand.Read(h.Nonce)
+	key, err := envelopeKey(h, password, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := h.marshal()
+	return aead.Seal(raw, h.Nonce, plaintext, raw)
+}
+
+// An embedded signature only verifies in the envelope it was made for
+func TestSignatureBoundToEnvelope(t *testing.T) {
+	signer, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf, err := NewKDFParams("scrypt", 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("transfer 100 to Bob")
+	envelope, err := Seal(message, "for bob", &Options{KDF: kdf, Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := Credentials{Password: "for bob", Signers: []*VerifyingKey{signer.Public()}}
+	if _, err := DecryptSigned(&bytes.Buffer{}, bytes.NewReader(envelope), creds); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recipient decrypts the plaintext with the trailer and encrypts it
+	// for someone else
+	h, raw, err := readHeader(bytes.NewReader(envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := envelopeKey(h, "for bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := openPayload(aead, h, raw, envelope[len(raw):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarded := sealSigned(t, signed, "for carol")
+	creds = Credentials{Password: "for carol", Signers: []*VerifyingKey{signer.Public()}}
+	if _, err := DecryptSigned(&bytes.Buffer{}, bytes.NewReader(forwarded), creds); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("forwarded envelope: got %v, want ErrBadSignature", err)
+	}
+
+	// A detached signature of the plaintext is not accepted either
+	detached, err := Sign(bytes.NewReader(message), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer := append(append(bytes.Clone(message), detached.Signer.key...), detached.Data...)
+	embedded := sealSigned(t, trailer, "for carol")
+	if _, err := DecryptSigned(&bytes.Buffer{}, bytes.NewReader(embedded), creds); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("embedded detached signature: got %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,220 @@
+package cryptdecrypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Streamed envelopes split the plaintext into chunks of ChunkSize bytes that
+// are sealed independently (STREAM construction). The nonce of chunk i is
+//
+//	nonce prefix (header nonce) || uint32 big-endian i || last flag
+//
+// where the last flag is 1 only for the final chunk. Reordered chunks fail
+// authentication because of the counter, and a stream that was cut off at a
+// chunk boundary fails because no chunk carries the last flag.
+const (
+	defaultChunkSize = 64 * 1024
+	minChunkSize     = 1024
+	maxChunkSize     = 16 * 1024 * 1024
+
+	streamNonceSuffix = 5 // counter and last flag
+)
+
+// streamNonce builds the nonce for chunk counter of a stream
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+streamNonceSuffix)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// readChunk fills buf from r and reports whether this was the last chunk,
+// i.e. whether r is exhausted afterwards
+func readChunk(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	if _, err := r.Peek(1); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+// encryptStream reads plaintext from src until EOF and writes a streamed
+// envelope to dst. Memory use is bounded by the chunk size.
+func encryptStream(dst io.Writer, src io.Reader, password string, opts Options) error {
+	h := &header{
+		Version:   envelopeVersion,
+		Flags:     flagStream,
+		Cipher:    opts.Cipher,
+		KDF:       opts.KDF,
+		Salt:      make([]byte, saltLength),
+		ChunkSize: defaultChunkSize,
+	}
+	if opts.Keyfile != nil {
+		h.Flags |= flagKeyfile
+	}
+	if opts.Signer != nil {
+		h.Flags |= flagSigned
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return err
+	}
+
+	key, err := envelopeKey(h, password, opts.Keyfile)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		return err
+	}
+
+	h.Nonce = make([]byte, aead.NonceSize()-streamNonceSuffix)
+	if _, err := io.ReadFull(rand.Reader, h.Nonce); err != nil {
+		return err
+	}
+
+	raw := h.marshal()
+	if _, err := dst.Write(raw); err != nil {
+		return err
+	}
+	if opts.Signer != nil {
+		src = newSigningReader(src, opts.Signer, raw)
+	}
+	return sealChunks(dst, src, aead, h.Nonce, int(h.ChunkSize), raw)
+}
+
+// sealChunks encrypts src chunk by chunk with the STREAM construction.
+// additionalData is authenticated with every chunk.
+func sealChunks(dst io.Writer, src io.Reader, aead cipher.AEAD, prefix []byte, chunkSize int, additionalData []byte) error {
+	in := bufio.NewReaderSize(src, chunkSize)
+	plaintext := make([]byte, chunkSize)
+	ciphertext := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, last, err := readChunk(in, plaintext)
+		if err != nil {
+			return err
+		}
+
+		nonce := streamNonce(prefix, counter, last)
+		ciphertext = aead.Seal(ciphertext[:0], nonce, plaintext[:n], additionalData)
+		if _, err := dst.Write(ciphertext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == math.MaxUint32 {
+			return fmt.Errorf("input too large")
+		}
+	}
+}
+
+// decryptEnvelope reads an envelope from in and writes the plaintext to dst.
+// Single-shot envelopes are decrypted in memory, streamed envelopes chunk by
+// chunk. For streamed envelopes dst may already have received the leading
+// chunks when an error is returned, so callers must discard the output then.
+// The embedded signature of signed envelopes is verified and returned.
+func decryptEnvelope(dst io.Writer, in *bufio.Reader, creds Credentials) (*Signature, error) {
+	h, raw, err := readHeader(in)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := decryptionKey(h, creds)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var verifier *verifyingWriter
+	if h.Flags&flagSigned != 0 {
+		verifier = newVerifyingWriter(dst, raw)
+		dst = verifier
+	}
+
+	if h.Flags&flagStream == 0 {
+		payload, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := openPayload(aead, h, raw, payload)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(h.Nonce) != aead.NonceSize()-streamNonceSuffix {
+			return nil, fmt.Errorf("%w: invalid nonce length %d", ErrCorrupted, len(h.Nonce))
+		}
+		if err := openChunks(dst, in, aead, h.Nonce, int(h.ChunkSize), raw, h.wrongKeyError()); err != nil {
+			return nil, err
+		}
+	}
+
+	if verifier == nil {
+		return nil, nil
+	}
+	return verifier.finish(creds.Signers)
+}
+
+// openChunks authenticates and decrypts the chunks written by sealChunks.
+// firstErr is returned if the first chunk fails, which for a password-derived
+// key most likely means a wrong password; later failures are ErrCorrupted.
+func openChunks(dst io.Writer, in *bufio.Reader, aead cipher.AEAD, prefix []byte, chunkSize int, additionalData []byte, firstErr error) error {
+	ciphertext := make([]byte, chunkSize+aead.Overhead())
+	plaintext := make([]byte, 0, chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, last, err := readChunk(in, ciphertext)
+		if err != nil {
+			return err
+		}
+		if n < aead.Overhead() {
+			return fmt.Errorf("%w: encrypted stream is truncated", ErrCorrupted)
+		}
+
+		nonce := streamNonce(prefix, counter, last)
+		plaintext, err = aead.Open(plaintext[:0], nonce, ciphertext[:n], additionalData)
+		if err != nil {
+			if counter == 0 {
+				return firstErr
+			}
+			if last {
+				// Either the final chunk was modified or the stream was cut
+				// off right after a chunk that is not the final one
+				return fmt.Errorf("%w: chunk %d failed authentication or the encrypted stream is truncated", ErrCorrupted, counter)
+			}
+			return fmt.Errorf("%w: chunk %d failed authentication", ErrCorrupted, counter)
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == math.MaxUint32 {
+			return fmt.Errorf("%w: encrypted stream is too long", ErrCorrupted)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cryptdecrypt_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/wiederda/Skripte/go/cryptdecrypt"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	argon2, err := cryptdecrypt.NewKDFParams("argon2id", 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []*cryptdecrypt.Options{
+		{Cipher: cryptdecrypt.AES256GCM, KDF: fastKDF(t)},
+		{Cipher: cryptdecrypt.ChaCha20Poly1305, KDF: argon2},
+	} {
+		// Sizes around the 64 KiB chunk boundary
+		for _, size := range []int{0, 1, 65535, 65536, 65537, 200000} {
+			plaintext := randomBytes(t, size)
+			var encrypted, decrypted bytes.Buffer
+			if err := cryptdecrypt.Encrypt(&encrypted, bytes.NewReader(plaintext), "pw", opts); err != nil {
+				t.Fatalf("%v, %d bytes: Encrypt: %v", opts.Cipher, size, err)
+			}
+			if err := cryptdecrypt.Decrypt(&decrypted, &encrypted, "pw"); err != nil {
+				t.Fatalf("%v, %d bytes: Decrypt: %v", opts.Cipher, size, err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plaintext) {
+				t.Errorf("%v, %d bytes: plaintext differs", opts.Cipher, size)
+			}
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := cryptdecrypt.Encrypt(&buf, bytes.NewReader(randomBytes(t, 150000)), "pw", &cryptdecrypt.Options{KDF: fastKDF(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := buf.Bytes()
+	modified := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte(nil), encrypted...))
+	}
+
+	// Header with the chunk size, two full chunks and the last one
+	const headerSize, sealedChunk = 54, 65536 + 16
+	chunks := [][]byte{
+		encrypted[:headerSize],
+		encrypted[headerSize : headerSize+sealedChunk],
+		encrypted[headerSize+sealedChunk : headerSize+2*sealedChunk],
+		encrypted[headerSize+2*sealedChunk:],
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		want     error
+	}{
+		{"wrong password", encrypted, "wrong", cryptdecrypt.ErrWrongPassword},
+		{"modified salt", modified(func(d []byte) []byte { d[25] ^= 1; return d }), "pw", cryptdecrypt.ErrWrongPassword},
+		{"modified last chunk", modified(func(d []byte) []byte { d[len(d)-1] ^= 1; return d }), "pw", cryptdecrypt.ErrCorrupted},
+		{"truncated", encrypted[:len(encrypted)-100], "pw", cryptdecrypt.ErrCorrupted},
+		{"truncated at chunk boundary", encrypted[:len(encrypted)-(150000-2*65536)-16], "pw", cryptdecrypt.ErrCorrupted},
+		{"truncated header", encrypted[:10], "pw", cryptdecrypt.ErrCorrupted},
+		{"dropped chunk", join(chunks[0], chunks[1], chunks[3]), "pw", cryptdecrypt.ErrCorrupted},
+		{"swapped chunks", join(chunks[0], chunks[1], chunks[3], chunks[2]), "pw", cryptdecrypt.ErrCorrupted},
+		{"appended chunk", join(chunks[0], chunks[1], chunks[1], chunks[2], chunks[3]), "pw", cryptdecrypt.ErrCorrupted},
+		{"not encrypted", []byte("just some text"), "pw", cryptdecrypt.ErrCorrupted},
+		{"newer version", modified(func(d []byte) []byte { d[4] = 2; return d }), "pw", cryptdecrypt.ErrUnsupportedVersion},
+		{"unknown flag", modified(func(d []byte) []byte { d[5] |= 0x80; return d }), "pw", cryptdecrypt.ErrUnsupportedVersion},
+		{"unknown cipher", modified(func(d []byte) []byte { d[6] = 9; return d }), "pw", cryptdecrypt.ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		err := cryptdecrypt.Decrypt(&bytes.Buffer{}, bytes.NewReader(tt.data), tt.password)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
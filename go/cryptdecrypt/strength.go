@@ -0,0 +1,467 @@
+package cryptdecrypt
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The strength estimate follows zxcvbn: the password is split into known
+// patterns (common passwords, words, sequences, repeats, keyboard rows,
+// dates) and random characters so that the total number of guesses is
+// minimal. The entropy is log2 of that number; patterns an attacker tries
+// first contribute only a few bits however long they are.
+
+// maxStrengthAnalysis limits the pattern search. Longer passwords are
+// analysed in windows of this size, and a group repeated beyond a window is
+// taken as one repeat however long it is.
+const maxStrengthAnalysis = 256
+
+// separatorBits is the cost of one separator between words, the few common
+// ones are tried first
+const separatorBits = 2
+
+// commonPasswordList holds frequent passwords, most frequent first
+//
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// Warnings for the patterns found in a password
+const (
+	warnCommon   = "common password"
+	warnWord     = "dictionary word"
+	warnSequence = "sequence like abc or 123"
+	warnRepeat   = "repeated characters"
+	warnKeyboard = "keyboard pattern"
+	warnDate     = "date or year"
+	warnShort    = "shorter than 10 characters"
+)
+
+// strengthDictionaries maps lowercase words to the log2 of their guess rank
+var strengthDictionaries = sync.OnceValue(func() map[string]float64 {
+	ranks := map[string]float64{}
+	wordBits := math.Log2(float64(len(dicewareWords())))
+	for _, w := range dicewareWords() {
+		ranks[w] = wordBits
+	}
+	for i, p := range strings.Fields(commonPasswordList) {
+		ranks[p] = math.Log2(float64(i + 2))
+	}
+	return ranks
+})
+
+// leetSubstitutions maps characters to the letters they commonly replace
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'0': 'o', '5': 's', '$': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// keyboardRows are adjacent keys of QWERTY and QWERTZ layouts
+var keyboardRows = []string{
+	"1234567890", "qwertyuiop", "qwertzuiop", "asdfghjkl", "zxcvbnm", "yxcvbnm",
+}
+
+// datePatterns match days, months and years with an optional separator
+var datePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(0?[1-9]|[12][0-9]|3[01])([./-]?)(0?[1-9]|1[0-2])([./-]?)((19|20)?[0-9]{2})`),
+	regexp.MustCompile(`^((19|20)[0-9]{2})([./-]?)(0?[1-9]|1[0-2])([./-]?)(0?[1-9]|[12][0-9]|3[01])`),
+	regexp.MustCompile(`^(19[0-9]{2}|20[0-3][0-9])`),
+}
+
+// Strength is an estimate of how hard a password is to guess
+type Strength struct {
+	Entropy  float64  // log2 of the estimated number of guesses
+	Warnings []string // patterns that make the password easier to guess
+}
+
+// Rating describes the entropy in words. The thresholds assume an offline
+// attack against the slow key derivation of an envelope.
+func (s Strength) Rating() string {
+	switch {
+	case s.Entropy < 30:
+		return "very weak"
+	case s.Entropy < 45:
+		return "weak"
+	case s.Entropy < 60:
+		return "fair"
+	case s.Entropy < 80:
+		return "strong"
+	default:
+		return "very strong"
+	}
+}
+
+// String returns e.g. "18 bits (very weak): common password"
+func (s Strength) String() string {
+	desc := fmt.Sprintf("%.0f bits (%s)", s.Entropy, s.Rating())
+	if len(s.Warnings) > 0 {
+		desc += ": " + strings.Join(s.Warnings, ", ")
+	}
+	return desc
+}
+
+// strengthMatch is a pattern covering password[start:end]
+type strengthMatch struct {
+	start, end int
+	entropy    float64
+	warning    string
+}
+
+// EstimateStrength estimates how many guesses the password takes
+func EstimateStrength(password string) Strength {
+	runes := []rune(password)
+	perChar := math.Log2(float64(charsetSize(runes)))
+	if len(runes) <= maxStrengthAnalysis {
+		s := analyzeStrength(runes, perChar)
+		if len(runes) < 10 {
+			s.Warnings = append(s.Warnings, warnShort)
+		}
+		return s
+	}
+
+	var s Strength
+	for start := 0; start < len(runes); {
+		var part Strength
+		if period, count := repeatRun(runes[start:]); count > 0 {
+			base := EstimateStrength(string(runes[start : start+period]))
+			part = Strength{Entropy: base.Entropy + math.Log2(float64(count)), Warnings: append(base.Warnings, warnRepeat)}
+			start += period * count
+		} else {
+			end := min(start+maxStrengthAnalysis, len(runes))
+			part = analyzeStrength(runes[start:end], perChar)
+			start = end
+		}
+		s.Entropy += part.Entropy
+		for _, w := range part.Warnings {
+			if w != warnShort && !slices.Contains(s.Warnings, w) {
+				s.Warnings = append(s.Warnings, w)
+			}
+		}
+	}
+	return s
+}
+
+// analyzeStrength splits the password into the patterns and random
+// characters of perChar bits each that take the fewest guesses
+func analyzeStrength(runes []rune, perChar float64) Strength {
+	// best[i] is the minimal entropy of runes[:i], via[i] the last match
+	matches := findMatches(runes)
+	best := make([]float64, len(runes)+1)
+	via := make([]*strengthMatch, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = best[i-1] + perChar
+		via[i] = nil
+		for j := range matches {
+			m := &matches[j]
+			if m.end == i && best[m.start]+m.entropy < best[i] {
+				best[i] = best[m.start] + m.entropy
+				via[i] = m
+			}
+		}
+	}
+
+	s := Strength{Entropy: best[len(runes)]}
+	seen := map[string]bool{}
+	for i := len(runes); i > 0; {
+		m := via[i]
+		if m == nil {
+			i--
+			continue
+		}
+		if m.warning != "" && !seen[m.warning] {
+			seen[m.warning] = true
+			s.Warnings = append([]string{m.warning}, s.Warnings...)
+		}
+		i = m.start
+	}
+	return s
+}
+
+// charsetSize estimates the size of the character set a brute-force attack
+// on the password has to try
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			size += class.size
+		}
+	}
+	return max(size, 2)
+}
+
+// findMatches returns all patterns found in the password
+func findMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	matches = append(matches, dictionaryMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	for i, r := range runes {
+		if strings.ContainsRune(" -_.,", r) {
+			matches = append(matches, strengthMatch{i, i + 1, separatorBits, ""})
+		}
+	}
+	return matches
+}
+
+// repeatRun finds a group that is repeated from the start of runes beyond
+// the first maxStrengthAnalysis characters and returns its length and the
+// number of repetitions, 0 repetitions if there is none
+func repeatRun(runes []rune) (int, int) {
+	if len(runes) <= maxStrengthAnalysis {
+		return 0, 0
+	}
+	window := runes[:maxStrengthAnalysis]
+	// prefix[i] is the longest proper prefix of window[:i+1] that is also
+	// its suffix (Knuth-Morris-Pratt), the shortest period of the window is
+	// what remains of it
+	prefix := make([]int, len(window))
+	for i := 1; i < len(window); i++ {
+		k := prefix[i-1]
+		for k > 0 && window[i] != window[k] {
+			k = prefix[k-1]
+		}
+		if window[i] == window[k] {
+			k++
+		}
+		prefix[i] = k
+	}
+	period := len(window) - prefix[len(window)-1]
+	if 2*period > len(window) {
+		return 0, 0
+	}
+	end := period
+	for end < len(runes) && runes[end] == runes[end-period] {
+		end++
+	}
+	return period, end / period
+}
+
+// dictionaryMatches finds common passwords and words, also capitalized,
+// reversed or with digits and symbols for letters
+func dictionaryMatches(runes []rune) []strengthMatch {
+	const maxWordLength = 16
+	dict := strengthDictionaries()
+	var matches []strengthMatch
+	for i := range runes {
+		for j := i + 3; j <= min(len(runes), i+maxWordLength); j++ {
+			word := runes[i:j]
+			lower := strings.ToLower(string(word))
+			bits, extra, ok := dictionaryLookup(dict, lower)
+			if !ok {
+				// Reversed like drowssap, one more bit for trying both
+				if bits, extra, ok = dictionaryLookup(dict, reverse(lower)); !ok {
+					continue
+				}
+				extra++
+			}
+			extra += caseBits(word)
+			warning := warnWord
+			if bits < math.Log2(float64(len(strings.Fields(commonPasswordList))+2)) {
+				warning = warnCommon
+			}
+			matches = append(matches, strengthMatch{i, j, bits + extra, warning})
+		}
+	}
+	return matches
+}
+
+// dictionaryLookup returns the rank bits of a lowercase word and one extra
+// bit per substitution like p4ssw0rd that had to be undone
+func dictionaryLookup(dict map[string]float64, lower string) (float64, float64, bool) {
+	if bits, ok := dict[lower]; ok {
+		return bits, 0, true
+	}
+	unleet := []rune(lower)
+	substituted := 0
+	for k, r := range unleet {
+		if l, ok := leetSubstitutions[r]; ok {
+			unleet[k] = l
+			substituted++
+		}
+	}
+	if substituted == 0 {
+		return 0, 0, false
+	}
+	bits, ok := dict[string(unleet)]
+	return bits, float64(substituted), ok
+}
+
+// caseBits estimates the guesses added by upper-case letters: one bit for
+// the common forms Word and WORD, otherwise one bit per letter
+func caseBits(word []rune) float64 {
+	upper, letters := 0, 0
+	for _, r := range word {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case upper == letters || (upper == 1 && unicode.IsUpper(word[0])):
+		return 1
+	default:
+		return float64(letters)
+	}
+}
+
+// sequenceMatches finds runs like abc, 9876 or ACEG of at least 3
+// characters with a constant step of 1 or 2
+func sequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i+2 < len(runes); {
+		step := runes[i+1] - runes[i]
+		j := i + 1
+		for j+1 < len(runes) && runes[j+1]-runes[j] == step && sameClass(runes[j+1], runes[i]) {
+			j++
+		}
+		if n := j - i + 1; n >= 3 && step != 0 && step >= -2 && step <= 2 && sameClass(runes[i+1], runes[i]) {
+			bits := math.Log2(float64(charsetSize(runes[i:i+1]))) + math.Log2(float64(n))
+			if strings.ContainsRune("aAzZ019", runes[i]) {
+				bits = 1 + math.Log2(float64(n))
+			}
+			if step < 0 {
+				bits++
+			}
+			matches = append(matches, strengthMatch{i, j + 1, bits, warnSequence})
+			i = j
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// sameClass reports whether both are lower-case letters, upper-case letters
+// or digits
+func sameClass(a, b rune) bool {
+	class := func(r rune) int {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 1
+		case r >= 'A' && r <= 'Z':
+			return 2
+		case r >= '0' && r <= '9':
+			return 3
+		}
+		return 0
+	}
+	return class(a) != 0 && class(a) == class(b)
+}
+
+// repeatMatches finds a character or a group of characters repeated
+// directly, like aaaa or abcabc
+func repeatMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := range runes {
+		for size := 1; i+2*size <= len(runes); size++ {
+			base := string(runes[i : i+size])
+			// Only the longest repeat of a group that is not itself
+			// repeated, aaaa is "a" four times rather than "aa" twice
+			if i >= size && string(runes[i-size:i]) == base || strings.Contains((base + base)[1:len(base)*2-1], base) {
+				continue
+			}
+			count := 1
+			for i+(count+1)*size <= len(runes) && string(runes[i+count*size:i+(count+1)*size]) == base {
+				count++
+			}
+			if count < 2 || (size == 1 && count < 3) {
+				continue
+			}
+			bits := EstimateStrength(base).Entropy + math.Log2(float64(count))
+			matches = append(matches, strengthMatch{i, i + count*size, bits, warnRepeat})
+		}
+	}
+	return matches
+}
+
+// keyboardMatches finds at least 4 adjacent keys of a keyboard row, in
+// either direction
+func keyboardMatches(runes []rune) []strengthMatch {
+	const keys = 47
+	var matches []strengthMatch
+	lower := []rune(strings.ToLower(string(runes)))
+	for _, row := range keyboardRows {
+		for _, r := range []string{row, reverse(row)} {
+			for i := range lower {
+				j := i
+				for j < len(lower) && strings.Contains(r, string(lower[i:j+1])) {
+					j++
+				}
+				if j-i >= 4 {
+					bits := math.Log2(float64(keys*(j-i))) + caseBits(runes[i:j])
+					if r != row {
+						bits++
+					}
+					matches = append(matches, strengthMatch{i, j, bits, warnKeyboard})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// reverse returns s backwards
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// dateMatches finds dates like 24.12.1990, 19901224 and years like 1987
+func dateMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := range runes {
+		rest := string(runes[i:])
+		for k, pattern := range datePatterns {
+			m := pattern.FindStringSubmatch(rest)
+			if m == nil {
+				continue
+			}
+			bits := math.Log2(140) // years 1900 to 2039
+			if k < 2 {
+				bits += math.Log2(31 * 12)
+				if strings.ContainsAny(m[0], "./-") {
+					bits += 2
+				}
+			}
+			n := len([]rune(m[0]))
+			// Only whole numbers count, 2024 in 120245 is no year
+			if i+n < len(runes) && unicode.IsDigit(runes[i+n]) || i > 0 && unicode.IsDigit(runes[i-1]) && k == 2 {
+				continue
+			}
+			matches = append(matches, strengthMatch{i, i + n, bits, warnDate})
+		}
+	}
+	return matches
+}
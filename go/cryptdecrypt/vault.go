@@ -0,0 +1,149 @@
+package cryptdecrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// A vault is a single envelope whose payload is a JSON document of named
+// secrets, so names are as confidential as values:
+//
+//	{"version":1,"entries":{"db":{"value":"...","modified":"2024-..."}}}
+//
+// The key is derived once when the vault is opened. Marshal keeps the salt
+// and KDF parameters and only draws a new nonce, so saving costs no second
+// KDF run.
+const vaultVersion = 1
+
+// VaultEntry is one named secret of a vault
+type VaultEntry struct {
+	Name     string    `json:"-"`
+	Value    string    `json:"value"`
+	Modified time.Time `json:"modified"`
+}
+
+// vaultDocument is the JSON payload of a vault
+type vaultDocument struct {
+	Version int                    `json:"version"`
+	Entries map[string]*VaultEntry `json:"entries"`
+}
+
+// Vault is an opened vault. It is not safe for concurrent use, callers
+// serialize access to the file themselves.
+type Vault struct {
+	h   *header
+	key []byte
+	doc vaultDocument
+}
+
+// NewVault creates an empty vault protected by the password and, if
+// opts.Keyfile is set, the keyfile. A nil opts selects the defaults.
+func NewVault(password string, opts *Options) (*Vault, error) {
+	o := opts.withDefaults()
+	h := &header{
+		Version: envelopeVersion,
+		Cipher:  o.Cipher,
+		KDF:     o.KDF,
+		Salt:    make([]byte, saltLength),
+	}
+	if o.Keyfile != nil {
+		h.Flags |= flagKeyfile
+	}
+	if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+		return nil, err
+	}
+	key, err := envelopeKey(h, password, o.Keyfile)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{h: h, key: key, doc: vaultDocument{Version: vaultVersion, Entries: map[string]*VaultEntry{}}}, nil
+}
+
+// OpenVault decrypts a vault written by Marshal
+func OpenVault(data []byte, creds Credentials) (*Vault, error) {
+	h, raw, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if h.Flags&flagStream != 0 {
+		return nil, fmt.Errorf("%w: not a vault", ErrCorrupted)
+	}
+	key, err := decryptionKey(h, creds)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openPayload(aead, h, raw, data[len(raw):])
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Vault{h: h, key: key}
+	if err := json.Unmarshal(plaintext, &v.doc); err != nil {
+		return nil, fmt.Errorf("%w: not a vault: %v", ErrCorrupted, err)
+	}
+	if v.doc.Version != vaultVersion {
+		return nil, fmt.Errorf("%w: vault version %d", ErrUnsupportedVersion, v.doc.Version)
+	}
+	if v.doc.Entries == nil {
+		v.doc.Entries = map[string]*VaultEntry{}
+	}
+	for name, e := range v.doc.Entries {
+		e.Name = name
+	}
+	return v, nil
+}
+
+// Marshal encrypts the vault with a new nonce and returns the envelope
+func (v *Vault) Marshal() ([]byte, error) {
+	plaintext, err := json.Marshal(v.doc)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(v.h.Cipher, v.key)
+	if err != nil {
+		return nil, err
+	}
+	v.h.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, v.h.Nonce); err != nil {
+		return nil, err
+	}
+	raw := v.h.marshal()
+	return aead.Seal(raw, v.h.Nonce, plaintext, raw), nil
+}
+
+// Get returns the entry with the given name
+func (v *Vault) Get(name string) (*VaultEntry, bool) {
+	e, ok := v.doc.Entries[name]
+	return e, ok
+}
+
+// Set adds or replaces the entry with the given name
+func (v *Vault) Set(name, value string) {
+	v.doc.Entries[name] = &VaultEntry{Name: name, Value: value, Modified: time.Now().UTC().Truncate(time.Second)}
+}
+
+// Delete removes the entry with the given name and reports whether it existed
+func (v *Vault) Delete(name string) bool {
+	_, ok := v.doc.Entries[name]
+	delete(v.doc.Entries, name)
+	return ok
+}
+
+// List returns all entries sorted by name
+func (v *Vault) List() []*VaultEntry {
+	entries := make([]*VaultEntry, 0, len(v.doc.Entries))
+	for _, e := range v.doc.Entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
@@ -1,15 +1,15 @@
 $env:GOOS = "linux"
 $env:GOARCH = "amd64"
-go build -o ./linux/stegano main.go
+go build -o ./linux/stegano .
 
 $env:GOOS = "windows"
 $env:GOARCH = "amd64"
-go build -o ./windows/stegano.exe main.go
+go build -o ./windows/stegano.exe .
 
 $env:GOOS = "darwin"
 $env:GOARCH = "amd64"
-go build -o ./macos/amd64/stegano main.go
+go build -o ./macos/amd64/stegano .
 
 $env:GOOS = "darwin"
 $env:GOARCH = "arm64"
-go build -o ./macos/arm64/stegano main.go
\ No newline at end of file
+go build -o ./macos/arm64/stegano .
\ No newline at end of file
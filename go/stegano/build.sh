@@ -1,7 +1,7 @@
 #!/bin/bash
 
-GOOS=linux GOARCH=amd64 go build -o ./linux/stegano main.go
-GOOS=windows GOARCH=amd64 go build -o ./windows/stegano.exe main.go
-GOOS=darwin GOARCH=amd64 go build -o ./macos/amd64/stegano main.go
-GOOS=darwin GOARCH=arm64 go build -o ./macos/arm64/stegano main.go
+GOOS=linux GOARCH=amd64 go build -o ./linux/stegano .
+GOOS=windows GOARCH=amd64 go build -o ./windows/stegano.exe .
+GOOS=darwin GOARCH=amd64 go build -o ./macos/amd64/stegano .
+GOOS=darwin GOARCH=arm64 go build -o ./macos/arm64/stegano .
 
@@ -2,4 +2,7 @@ module stegano
 
 go 1.23.3
 
-require golang.org/x/image v0.22.0 // indirect
+require (
+	golang.org/x/crypto v0.28.0
+	golang.org/x/image v0.22.0
+)
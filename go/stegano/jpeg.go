@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math/bits"
+	"os"
+)
+
+// JPEG-Träger: Pixel-LSBs überstehen die verlustbehaftete Kompression nicht,
+// deshalb werden die Bits hier in die quantisierten DCT-Koeffizienten
+// geschrieben (wie JSteg). Genutzt wird das LSB des Betrags der
+// AC-Koeffizienten mit Betrag ab 2: 0 und ±1 bleiben unverändert, so bleiben
+// die Nullfolgen und die Größenklassen der Huffman-Symbole gleich. Die
+// Datei wird mit ihren eigenen Tabellen neu kodiert, alle Segmente vor den
+// Bilddaten werden unverändert übernommen.
+//
+// Unterstützt wird sequentielles JPEG mit Huffman-Kodierung und einem Scan,
+// wie es Kameras und image/jpeg schreiben. Andere JPEGs werden neu
+// komprimiert.
+
+// errUnsupportedJPEG wird für JPEGs gemeldet, deren Koeffizienten nicht
+// gelesen werden können
+var errUnsupportedJPEG = errors.New("unsupported JPEG")
+
+// jpegQuality ist die Qualität für Träger, die erst komprimiert werden müssen
+const jpegQuality = 90
+
+// maxJPEGPixels begrenzt die Größe aus dem SOF-Segment. Jeder Block belegt
+// 256 Bytes, ein gefälschter Header soll nicht Gigabytes anfordern.
+const maxJPEGPixels = 1 << 26
+
+// huffmanTable ist eine Huffman-Tabelle aus einem DHT-Segment
+type huffmanTable struct {
+	symbols []byte
+	minCode [17]int32
+	maxCode [17]int32 // -1, wenn es keine Codes dieser Länge gibt
+	valPtr  [17]int32
+	codes   [256]huffmanCode
+}
+
+// huffmanCode ist der Code eines Symbols, length 0 für fehlende Symbole
+type huffmanCode struct {
+	code   uint16
+	length uint8
+}
+
+// Funktion zum Aufbau der kanonischen Codes aus der Anzahl der Codes je Länge
+func newHuffmanTable(counts []byte, symbols []byte) (*huffmanTable, error) {
+	t := &huffmanTable{symbols: symbols}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		t.valPtr[l] = k
+		t.minCode[l] = code
+		t.maxCode[l] = -1
+		if n > 0 {
+			if code+n > 1<<l {
+				return nil, fmt.Errorf("%w: invalid Huffman table", errUnsupportedJPEG)
+			}
+			for i := int32(0); i < n; i++ {
+				t.codes[symbols[k+i]] = huffmanCode{uint16(code + i), uint8(l)}
+			}
+			code += n
+			k += n
+			t.maxCode[l] = code - 1
+		}
+		code <<= 1
+	}
+	return t, nil
+}
+
+// jpegComponent ist eine Farbkomponente mit Abtastfaktoren und Tabellen
+type jpegComponent struct {
+	id     byte
+	h, v   int
+	dc, ac *huffmanTable
+}
+
+// jpegCarrier hält die Koeffizienten eines JPEGs in der Reihenfolge der
+// Bilddaten
+type jpegCarrier struct {
+	header   []byte // alle Segmente bis einschließlich SOS
+	restart  int    // MCUs zwischen Restart-Markern, 0 ohne
+	comps    []*jpegComponent
+	mcuComps []int // Komponente jedes Blocks einer MCU
+	mcus     int
+	blocks   [][64]int32 // in Zickzack-Reihenfolge
+	usable   []int       // Block*64+Index der nutzbaren Koeffizienten
+}
+
+func (c *jpegCarrier) slots() int {
+	return len(c.usable)
+}
+
+func (c *jpegCarrier) bit(i int) byte {
+	v := c.blocks[c.usable[i]/64][c.usable[i]%64]
+	if v < 0 {
+		v = -v
+	}
+	return byte(v & 1)
+}
+
+func (c *jpegCarrier) setBit(i int, b byte) {
+	v := &c.blocks[c.usable[i]/64][c.usable[i]%64]
+	if *v < 0 {
+		*v = -(-*v&^1 | int32(b))
+	} else {
+		*v = *v&^1 | int32(b)
+	}
+}
+
+// isJPEG erkennt JPEG-Dateien am SOI-Marker
+func isJPEG(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0xFF && data[1] == 0xD8
+}
+
+// Funktion zum Erzeugen des JPEG-Trägers für encode: die Koeffizienten der
+// Eingabe, wenn sie ein lesbares JPEG ist, sonst die des neu komprimierten
+// Bildes
+func jpegCarrierFor(imagePath string, img image.Image) (*jpegCarrier, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if isJPEG(data) {
+		c, err := parseJPEG(data)
+		if err == nil {
+			return c, nil
+		}
+		fmt.Printf("Input JPEG cannot be used directly (%v), recompressing with quality %d\n", err, jpegQuality)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("unable to encode image: %v", err)
+	}
+	return parseJPEG(buf.Bytes())
+}
+
+// Funktion zum Lesen der Koeffizienten eines JPEGs
+func parseJPEG(data []byte) (*jpegCarrier, error) {
+	if !isJPEG(data) {
+		return nil, fmt.Errorf("%w: missing SOI marker", errUnsupportedJPEG)
+	}
+	c := &jpegCarrier{}
+	var dcTables, acTables [4]*huffmanTable
+	var frame []*jpegComponent
+	var width, height int
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, fmt.Errorf("%w: truncated or invalid marker", errUnsupportedJPEG)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Füllbytes vor einem Marker
+			pos++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("%w: truncated segment", errUnsupportedJPEG)
+		}
+		seg := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == 0xC4: // DHT
+			for len(seg) > 0 {
+				if len(seg) < 17 || seg[0]&0x0F > 3 {
+					return nil, fmt.Errorf("%w: invalid DHT segment", errUnsupportedJPEG)
+				}
+				total := 0
+				for _, n := range seg[1:17] {
+					total += int(n)
+				}
+				if total > 256 || len(seg) < 17+total {
+					return nil, fmt.Errorf("%w: invalid DHT segment", errUnsupportedJPEG)
+				}
+				t, err := newHuffmanTable(seg[1:17], seg[17:17+total])
+				if err != nil {
+					return nil, err
+				}
+				if seg[0]>>4 == 0 {
+					dcTables[seg[0]&0x0F] = t
+				} else {
+					acTables[seg[0]&0x0F] = t
+				}
+				seg = seg[17+total:]
+			}
+		case marker == 0xDD: // DRI
+			if len(seg) < 2 {
+				return nil, fmt.Errorf("%w: invalid DRI segment", errUnsupportedJPEG)
+			}
+			c.restart = int(binary.BigEndian.Uint16(seg))
+		case marker == 0xC0 || marker == 0xC1: // SOF, sequentiell mit Huffman
+			if len(seg) < 6 || seg[0] != 8 {
+				return nil, fmt.Errorf("%w: only 8 bit precision is supported", errUnsupportedJPEG)
+			}
+			height = int(binary.BigEndian.Uint16(seg[1:]))
+			width = int(binary.BigEndian.Uint16(seg[3:]))
+			n := int(seg[5])
+			if height == 0 || width == 0 || n == 0 || n > 4 || len(seg) < 6+3*n {
+				return nil, fmt.Errorf("%w: invalid SOF segment", errUnsupportedJPEG)
+			}
+			if width*height > maxJPEGPixels {
+				return nil, fmt.Errorf("%w: %dx%d pixels, at most %d are supported", errUnsupportedJPEG, width, height, maxJPEGPixels)
+			}
+			for i := 0; i < n; i++ {
+				comp := &jpegComponent{id: seg[6+3*i], h: int(seg[7+3*i] >> 4), v: int(seg[7+3*i] & 0x0F)}
+				if comp.h < 1 || comp.h > 4 || comp.v < 1 || comp.v > 4 {
+					return nil, fmt.Errorf("%w: invalid sampling factors", errUnsupportedJPEG)
+				}
+				frame = append(frame, comp)
+			}
+		case marker == 0xC2 || marker == 0xC6 || marker == 0xCA || marker == 0xCE:
+			return nil, fmt.Errorf("%w: progressive JPEG", errUnsupportedJPEG)
+		case marker >= 0xC3 && marker <= 0xCF && marker != 0xC8 && marker != 0xCC:
+			return nil, fmt.Errorf("%w: lossless, hierarchical or arithmetic coded JPEG", errUnsupportedJPEG)
+		case marker == 0xDA: // SOS
+			if frame == nil {
+				return nil, fmt.Errorf("%w: SOS before SOF", errUnsupportedJPEG)
+			}
+			if len(seg) < 1 {
+				return nil, fmt.Errorf("%w: invalid SOS segment", errUnsupportedJPEG)
+			}
+			n := int(seg[0])
+			if n != len(frame) || len(seg) < 1+2*n+3 {
+				return nil, fmt.Errorf("%w: multiple scans", errUnsupportedJPEG)
+			}
+			for i := 0; i < n; i++ {
+				var comp *jpegComponent
+				for _, f := range frame {
+					if f.id == seg[1+2*i] {
+						comp = f
+					}
+				}
+				td, ta := seg[2+2*i]>>4, seg[2+2*i]&0x0F
+				if comp == nil || td > 3 || ta > 3 || dcTables[td] == nil || acTables[ta] == nil {
+					return nil, fmt.Errorf("%w: invalid SOS segment", errUnsupportedJPEG)
+				}
+				comp.dc, comp.ac = dcTables[td], acTables[ta]
+				c.comps = append(c.comps, comp)
+			}
+			c.header = data[:pos]
+			c.layout(width, height)
+			if err := c.decodeScan(data[pos:]); err != nil {
+				return nil, err
+			}
+			return c, nil
+		case marker == 0xD9 || marker == 0xD8 || marker >= 0xD0 && marker <= 0xD7:
+			return nil, fmt.Errorf("%w: unexpected marker %02X", errUnsupportedJPEG, marker)
+		}
+		// APPn, COM, DQT und weitere Segmente werden nur übernommen
+	}
+}
+
+// Funktion zur Berechnung der MCUs: bei mehreren Komponenten enthält eine
+// MCU h*v Blöcke jeder Komponente, bei einer Komponente genau einen Block
+func (c *jpegCarrier) layout(width, height int) {
+	if len(c.comps) == 1 {
+		c.mcuComps = []int{0}
+		c.mcus = ((width + 7) / 8) * ((height + 7) / 8)
+		return
+	}
+	hMax, vMax := 1, 1
+	for _, comp := range c.comps {
+		hMax, vMax = max(hMax, comp.h), max(vMax, comp.v)
+	}
+	for i, comp := range c.comps {
+		for j := 0; j < comp.h*comp.v; j++ {
+			c.mcuComps = append(c.mcuComps, i)
+		}
+	}
+	c.mcus = ((width + 8*hMax - 1) / (8 * hMax)) * ((height + 8*vMax - 1) / (8 * vMax))
+}
+
+// bitReader liest die Bits der Bilddaten ohne die Stuffing-Bytes
+type bitReader struct {
+	data []byte
+	pos  int
+	acc  byte
+	n    uint
+}
+
+var errJPEGData = fmt.Errorf("%w: corrupted image data", errUnsupportedJPEG)
+
+func (r *bitReader) bit() (int32, error) {
+	if r.n == 0 {
+		if r.pos >= len(r.data) {
+			return 0, errJPEGData
+		}
+		b := r.data[r.pos]
+		r.pos++
+		if b == 0xFF {
+			if r.pos >= len(r.data) || r.data[r.pos] != 0x00 {
+				return 0, errJPEGData
+			}
+			r.pos++
+		}
+		r.acc, r.n = b, 8
+	}
+	r.n--
+	return int32(r.acc>>r.n) & 1, nil
+}
+
+// Funktion zum Lesen von s Bits als Wert der Größenklasse s
+func (r *bitReader) receive(s byte) (int32, error) {
+	var v int32
+	for i := byte(0); i < s; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	if s > 0 && v < 1<<(s-1) {
+		v -= 1<<s - 1
+	}
+	return v, nil
+}
+
+func (r *bitReader) decode(t *huffmanTable) (byte, error) {
+	var code int32
+	for l := 1; l <= 16; l++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | b
+		if code <= t.maxCode[l] {
+			return t.symbols[t.valPtr[l]+code-t.minCode[l]], nil
+		}
+	}
+	return 0, errJPEGData
+}
+
+// Funktion zum Überspringen eines Restart-Markers
+func (r *bitReader) restart() error {
+	r.n = 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xFF || r.data[r.pos+1] < 0xD0 || r.data[r.pos+1] > 0xD7 {
+		return errJPEGData
+	}
+	r.pos += 2
+	return nil
+}
+
+// Funktion zum Dekodieren aller Blöcke des Scans
+func (c *jpegCarrier) decodeScan(data []byte) error {
+	r := &bitReader{data: data}
+	pred := make([]int32, len(c.comps))
+	// Die Blöcke wachsen mit den gelesenen Daten, ein abgeschnittenes JPEG
+	// belegt nur so viel Speicher, wie es Daten hat
+	for m := 0; m < c.mcus; m++ {
+		if c.restart > 0 && m > 0 && m%c.restart == 0 {
+			if err := r.restart(); err != nil {
+				return err
+			}
+			clear(pred)
+		}
+		for _, ci := range c.mcuComps {
+			var block [64]int32
+			comp := c.comps[ci]
+			s, err := r.decode(comp.dc)
+			if err != nil {
+				return err
+			}
+			if s > 11 {
+				return errJPEGData
+			}
+			diff, err := r.receive(s)
+			if err != nil {
+				return err
+			}
+			pred[ci] += diff
+			block[0] = pred[ci]
+			for k := 1; k < 64; k++ {
+				rs, err := r.decode(comp.ac)
+				if err != nil {
+					return err
+				}
+				run, size := int(rs>>4), rs&0x0F
+				if size == 0 {
+					if run != 15 {
+						break // EOB
+					}
+					k += 15
+					continue
+				}
+				k += run
+				if k > 63 || size > 10 {
+					return errJPEGData
+				}
+				if block[k], err = r.receive(size); err != nil {
+					return err
+				}
+			}
+			c.blocks = append(c.blocks, block)
+		}
+	}
+
+	// Nach dem Scan darf nur noch EOI folgen
+	rest := data[r.pos:]
+	for len(rest) >= 2 && rest[0] == 0xFF && rest[1] == 0xFF {
+		rest = rest[1:]
+	}
+	if len(rest) < 2 || rest[0] != 0xFF || rest[1] != 0xD9 {
+		return fmt.Errorf("%w: data after the scan, e.g. multiple scans", errUnsupportedJPEG)
+	}
+
+	for b := range c.blocks {
+		for k := 1; k < 64; k++ {
+			if v := c.blocks[b][k]; v >= 2 || v <= -2 {
+				c.usable = append(c.usable, b*64+k)
+			}
+		}
+	}
+	return nil
+}
+
+// bitWriter schreibt Bits mit Stuffing-Bytes nach 0xFF
+type bitWriter struct {
+	buf bytes.Buffer
+	acc uint32
+	n   uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.buf.WriteByte(b)
+		if b == 0xFF {
+			w.buf.WriteByte(0x00)
+		}
+		w.n -= 8
+	}
+}
+
+// Funktion zum Auffüllen des letzten Bytes mit Einsen
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+func (w *bitWriter) emit(t *huffmanTable, symbol byte) error {
+	c := t.codes[symbol]
+	if c.length == 0 {
+		return fmt.Errorf("symbol %02X is missing in the Huffman table", symbol)
+	}
+	w.write(uint32(c.code), uint(c.length))
+	return nil
+}
+
+// Funktion zur Berechnung von Größenklasse und Bits eines Werts
+func category(v int32) (byte, uint32) {
+	m := v
+	if m < 0 {
+		m = -m
+	}
+	s := byte(bits.Len32(uint32(m)))
+	if v < 0 {
+		v += 1<<s - 1
+	}
+	return s, uint32(v)
+}
+
+// Funktion zum Schreiben des JPEGs mit den geänderten Koeffizienten
+func (c *jpegCarrier) encode() ([]byte, error) {
+	w := &bitWriter{}
+	w.buf.Write(c.header)
+	pred := make([]int32, len(c.comps))
+	b := 0
+	for m := 0; m < c.mcus; m++ {
+		if c.restart > 0 && m > 0 && m%c.restart == 0 {
+			w.flush()
+			w.buf.Write([]byte{0xFF, 0xD0 + byte((m/c.restart-1)%8)})
+			clear(pred)
+		}
+		for _, ci := range c.mcuComps {
+			comp := c.comps[ci]
+			block := &c.blocks[b]
+			b++
+			s, v := category(block[0] - pred[ci])
+			pred[ci] = block[0]
+			if err := w.emit(comp.dc, s); err != nil {
+				return nil, err
+			}
+			w.write(v, uint(s))
+			run := 0
+			for k := 1; k < 64; k++ {
+				if block[k] == 0 {
+					run++
+					continue
+				}
+				for ; run > 15; run -= 16 {
+					if err := w.emit(comp.ac, 0xF0); err != nil {
+						return nil, err
+					}
+				}
+				s, v := category(block[k])
+				if err := w.emit(comp.ac, byte(run<<4)|s); err != nil {
+					return nil, err
+				}
+				w.write(v, uint(s))
+				run = 0
+			}
+			if run > 0 {
+				if err := w.emit(comp.ac, 0x00); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	w.flush()
+	w.buf.Write([]byte{0xFF, 0xD9})
+	return w.buf.Bytes(), nil
+}
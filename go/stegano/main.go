@@ -1,16 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/jpeg"
+	_ "image/jpeg" // Import für JPEG-Unterstützung
 	"image/png"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"golang.org/x/crypto/scrypt"
 	"golang.org/x/image/bmp"
 	_ "golang.org/x/image/bmp" // Import für BMP-Unterstützung
 )
@@ -24,14 +34,36 @@ func displayHelp() {
 	fmt.Println("  encode <input_image> <message> <output_image>")
 	fmt.Println("      Encodes the given message into the specified image and saves it.")
 	fmt.Println()
-	fmt.Println("  decode <input_image>")
-	fmt.Println("      Decodes and prints the hidden message from the specified image.")
+	fmt.Println("  encode -file <file> <input_image> <output_image>")
+	fmt.Println("      Encodes any file with its name, size and modification time.")
+	fmt.Println()
+	fmt.Println("  decode [-out <dir>] <input_image>")
+	fmt.Println("      Decodes and prints the hidden message from the specified image,")
+	fmt.Println("      or restores a hidden file into the directory (default: current).")
+	fmt.Println("      Images without a message are reported as 'no payload present'.")
+	fmt.Println()
+	fmt.Println("  capacity [-channels <rgba>] <input_image>")
+	fmt.Println("      Prints how many bytes the image can hold as JPEG and as PNG/BMP")
+	fmt.Println("      with 1 to 4 bits per channel (-channels as for encode).")
 	fmt.Println()
 	fmt.Println("Supported Formats:")
 	fmt.Println("  Input: PNG, BMP, JPG/JPEG")
-	fmt.Println("  Output: PNG, BMP, JPG/JPEG")
+	fmt.Println("  Output: PNG, BMP (pixel LSBs), JPG/JPEG (DCT coefficients of the")
+	fmt.Println("  input JPEG, or of the input compressed with quality 90)")
+	fmt.Println("  Every output is decoded again after saving to verify the payload.")
 	fmt.Println()
 	fmt.Println("Options:")
+	fmt.Println("  -bits <1-4>, -channels <rgba>")
+	fmt.Println("      encode/decode: Bits per channel and channels used in PNG/BMP output")
+	fmt.Println("      (default: 1 bit of r, g and b). More bits hold more but change the")
+	fmt.Println("      image more, encode reports the PSNR and the SSIM of the brightness.")
+	fmt.Println("      Decode needs the same.")
+	fmt.Println("      The alpha channel needs PNG output, BMP files are written without it.")
+	fmt.Println()
+	fmt.Println("  -password <password>")
+	fmt.Println("      encode/decode: Encrypts the payload (AES-256-GCM) and scatters it")
+	fmt.Println("      over the image in an order only the password reproduces.")
+	fmt.Println()
 	fmt.Println("  --help")
 	fmt.Println("      Displays this help message.")
 	fmt.Println()
@@ -39,9 +71,22 @@ func displayHelp() {
 	fmt.Println("  Encode a message:")
 	fmt.Println("      ./stegano encode input.jpg \"Hidden message\" output.png")
 	fmt.Println()
+	fmt.Println("  Hide a file:")
+	fmt.Println("      ./stegano encode -file secret.pdf input.png output.png")
+	fmt.Println()
+	fmt.Println("  Encrypt with a password:")
+	fmt.Println("      ./stegano encode -password \"secret\" input.png \"Hidden message\" output.png")
+	fmt.Println()
+	fmt.Println("  More capacity with 2 bits of every channel:")
+	fmt.Println("      ./stegano encode -bits 2 -channels rgba -file secret.pdf input.png output.png")
+	fmt.Println("      ./stegano decode -bits 2 -channels rgba output.png")
+	fmt.Println()
 	fmt.Println("  Decode a message:")
 	fmt.Println("     ./stegano decode output.png")
 	fmt.Println()
+	fmt.Println("  Restore a hidden file:")
+	fmt.Println("     ./stegano decode -out restored/ output.png")
+	fmt.Println()
 }
 
 // Funktion zum Speichern des Bildes im richtigen Format
@@ -56,9 +101,6 @@ func saveImage(outputPath string, img image.Image) error {
 	switch ext {
 	case ".png":
 		err = png.Encode(outputFile, img)
-	case ".jpg", ".jpeg":
-		// JPEG mit Qualität 90 speichern
-		err = jpeg.Encode(outputFile, img, &jpeg.Options{Quality: 90})
 	case ".bmp":
 		// BMP wird durch die go-x-image/bmp unterstützt
 		err = bmp.Encode(outputFile, img)
@@ -72,139 +114,595 @@ func saveImage(outputPath string, img image.Image) error {
 	return nil
 }
 
-// Funktion zum Einbetten einer Nachricht in ein Bild
-func encodeImage(imagePath, message, outputPath string) error {
+// Aufbau der eingebetteten Daten: Magic, Version, Länge und CRC32 der
+// Nutzdaten, danach die Nutzdaten selbst. Damit übersteht jedes Byte (auch
+// 0xFF) den Weg durch das Bild, und decode erkennt Bilder ohne Nutzdaten.
+const (
+	payloadMagic   = "STEG"
+	payloadVersion = 2
+	headerSize     = len(payloadMagic) + 1 + 4 + 4
+)
+
+// errNoPayload wird gemeldet, wenn das Bild keine Nutzdaten enthält
+var errNoPayload = errors.New("no payload present")
+
+// Funktion zum Laden eines Bildes als NRGBA. NRGBA speichert die Farben
+// ohne Vormultiplikation mit Alpha, so bleiben die LSBs auch bei
+// transparenten Pixeln beim Speichern als PNG erhalten.
+func loadImage(imagePath string) (*image.NRGBA, string, error) {
 	// Bilddatei öffnen
 	imgFile, err := os.Open(imagePath)
 	if err != nil {
-		return fmt.Errorf("unable to open image: %v", err)
+		return nil, "", fmt.Errorf("unable to open image: %v", err)
 	}
 	defer imgFile.Close()
 
 	// Bild dekodieren
 	srcImg, format, err := image.Decode(imgFile)
 	if err != nil {
-		return fmt.Errorf("unable to decode image: %v", err)
+		return nil, "", fmt.Errorf("unable to decode image: %v", err)
 	}
-	fmt.Printf("Input image format: %s\n", format)
 
-	// In RGBA-Format umwandeln
+	// In NRGBA-Format umwandeln, Pixel für Pixel und damit verlustfrei
 	bounds := srcImg.Bounds()
-	img := image.NewRGBA(bounds)
-	draw.Draw(img, bounds, srcImg, image.Point{}, draw.Src)
-
-	// Nachricht in Binärdaten umwandeln
-	binaryMessage := stringToBinary(message) + "11111111" // Delimiter hinzufügen
-
-	// Binärnachricht einbetten
-	dataIndex := 0
-	for y := bounds.Min.Y; y < bounds.Max.Y && dataIndex < len(binaryMessage); y++ {
-		for x := bounds.Min.X; x < bounds.Max.X && dataIndex < len(binaryMessage); x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-			r8, g8, b8 := uint8(r>>8), uint8(g>>8), uint8(b>>8)
-
-			// LSBs ändern
-			if dataIndex < len(binaryMessage) {
-				r8 = (r8 & 0xFE) | (binaryMessage[dataIndex] - '0')
-				dataIndex++
-			}
-			if dataIndex < len(binaryMessage) {
-				g8 = (g8 & 0xFE) | (binaryMessage[dataIndex] - '0')
-				dataIndex++
-			}
-			if dataIndex < len(binaryMessage) {
-				b8 = (b8 & 0xFE) | (binaryMessage[dataIndex] - '0')
-				dataIndex++
-			}
+	img := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBAModel.Convert(srcImg.At(x, y)).(color.NRGBA))
+		}
+	}
+	return img, format, nil
+}
 
-			// Pixel setzen
-			img.Set(x, y, color.RGBA{r8, g8, b8, uint8(a >> 8)})
+// carrier nimmt einzelne Bits auf: die Kanäle eines Bildes oder die
+// Koeffizienten eines JPEGs
+type carrier interface {
+	slots() int
+	bit(i int) byte
+	setBit(i int, b byte)
+}
+
+// pixelLayout legt fest, wie viele Bits welcher Kanäle ein Pixel aufnimmt.
+// Mehr Bits und Kanäle erhöhen die Kapazität und die sichtbare Veränderung.
+type pixelLayout struct {
+	bits     int   // niedrigste Bits je Kanal, 1 bis 4
+	channels []int // Kanäle in Pix-Reihenfolge: 0 R, 1 G, 2 B, 3 A
+}
+
+// defaultLayout ist ein Bit in R, G und B wie in allen älteren Versionen
+var defaultLayout = pixelLayout{bits: 1, channels: []int{0, 1, 2}}
+
+// Funktion zum Lesen von -bits und -channels. Die Reihenfolge der
+// Buchstaben spielt keine Rolle, beschrieben wird immer R, G, B, A.
+func parseLayout(bits int, channels string) (pixelLayout, error) {
+	if bits < 1 || bits > 4 {
+		return pixelLayout{}, fmt.Errorf("invalid number of bits %d, use 1 to 4", bits)
+	}
+	layout := pixelLayout{bits: bits}
+	for i, name := range "rgba" {
+		if strings.ContainsRune(strings.ToLower(channels), name) {
+			layout.channels = append(layout.channels, i)
 		}
 	}
+	if len(layout.channels) == 0 || strings.Trim(strings.ToLower(channels), "rgba") != "" {
+		return pixelLayout{}, fmt.Errorf("invalid channels %q, use letters of 'rgba'", channels)
+	}
+	return layout, nil
+}
 
-	// Bild speichern
-	err = saveImage(outputPath, img)
+func (l pixelLayout) isDefault() bool {
+	return l.bits == defaultLayout.bits && slices.Equal(l.channels, defaultLayout.channels)
+}
+
+// imageCarrier schreibt in die niedrigsten Bits der gewählten Kanäle. Die
+// Bits eines Kanals liegen nebeneinander, angefangen beim LSB.
+type imageCarrier struct {
+	img *image.NRGBA
+	pixelLayout
+}
+
+func (c imageCarrier) slots() int {
+	bounds := c.img.Bounds()
+	return bounds.Dx() * bounds.Dy() * len(c.channels) * c.bits
+}
+
+// Position in img.Pix und Bit des i-ten Platzes
+func (c imageCarrier) locate(i int) (int, uint) {
+	perPixel := len(c.channels) * c.bits
+	rest := i % perPixel
+	return i/perPixel*4 + c.channels[rest/c.bits], uint(rest % c.bits)
+}
+
+func (c imageCarrier) bit(i int) byte {
+	j, plane := c.locate(i)
+	return c.img.Pix[j] >> plane & 1
+}
+
+func (c imageCarrier) setBit(i int, b byte) {
+	j, plane := c.locate(i)
+	c.img.Pix[j] = c.img.Pix[j]&^(1<<plane) | b<<plane
+}
+
+// Kapazität des Trägers in Bytes ohne Passwort
+func capacity(c carrier) int {
+	return c.slots() / 8
+}
+
+// bitOrder bildet die Nummer eines Bits auf die Nummer seines Platzes ab
+type bitOrder func(i int) int
+
+// Ohne Passwort werden die Plätze der Reihe nach beschrieben
+func sequentialOrder(i int) int {
+	return i
+}
+
+// Funktion zum Schreiben von data ab Byte offset in den Träger
+func embedBytes(c carrier, order bitOrder, offset int, data []byte) {
+	for i := 0; i < len(data)*8; i++ {
+		c.setBit(order(offset*8+i), data[i/8]>>(7-i%8)&1)
+	}
+}
+
+// Funktion zum Lesen von n Bytes ab Byte offset aus dem Träger
+func extractBytes(c carrier, order bitOrder, offset, n int) []byte {
+	data := make([]byte, n)
+	for i := 0; i < n*8; i++ {
+		data[i/8] |= c.bit(order(offset*8+i)) << (7 - i%8)
+	}
+	return data
+}
+
+// Verschlüsselung mit -password: Das Salt steht in den ersten Plätzen, alles
+// Weitere (Nonce, Länge und AES-256-GCM-Chiffrat) in einer aus dem Passwort
+// abgeleiteten Reihenfolge über den ganzen Träger verteilt. Ohne Passwort
+// sieht beides wie das Rauschen der LSBs aus.
+const (
+	saltSize           = 16
+	nonceSize          = 12
+	encryptionOverhead = saltSize + nonceSize + 4 + 16 // Salt, Nonce, Länge, GCM-Tag
+)
+
+// errWrongPassword wird gemeldet, wenn die Entschlüsselung fehlschlägt. Ein
+// falsches Passwort und ein Bild ohne Nutzdaten sind nicht unterscheidbar.
+var errWrongPassword = errors.New("wrong password or no payload present")
+
+// Funktion zum Ableiten der Schlüssel für Reihenfolge und Verschlüsselung
+// mit scrypt (N=2^15, r=8, p=1 wie bei cryptdecrypt)
+func deriveKeys(password string, salt []byte) (orderKey, aeadKey []byte, err error) {
+	key, err := scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 64)
 	if err != nil {
-		return fmt.Errorf("error saving image: %v", err)
+		return nil, nil, err
 	}
+	return key[:32], key[32:], nil
+}
 
-	fmt.Println("Message encoded and image saved as", outputPath)
-	return nil
+// keyedOrder ist eine geheime Permutation der Plätze [0, n): ein
+// Feistel-Netzwerk mit AES als Rundenfunktion über die nächste Zweierpotenz
+// mit gerader Bitzahl. Werte ab n werden erneut permutiert (Cycle Walking),
+// so braucht auch ein großer Träger keine Tabelle im Speicher.
+type keyedOrder struct {
+	block    cipher.Block
+	n        int
+	halfBits uint
 }
 
-// Funktion zum Dekodieren einer Nachricht aus einem Bild
-func decodeImage(imagePath string) (string, error) {
-	// Bilddatei öffnen
-	imgFile, err := os.Open(imagePath)
+func newKeyedOrder(key []byte, n int) (*keyedOrder, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", fmt.Errorf("unable to open image: %v", err)
+		return nil, err
 	}
-	defer imgFile.Close()
+	halfBits := uint(1)
+	for 1<<(2*halfBits) < n {
+		halfBits++
+	}
+	return &keyedOrder{block: block, n: n, halfBits: halfBits}, nil
+}
 
-	// Bild dekodieren
-	srcImg, _, err := image.Decode(imgFile)
+// Funktion zum Permutieren von i in [0, n)
+func (o *keyedOrder) permute(i int) int {
+	x := uint64(i)
+	for {
+		x = o.feistel(x)
+		if x < uint64(o.n) {
+			return int(x)
+		}
+	}
+}
+
+// Vier Feistel-Runden über die zwei Hälften von x
+func (o *keyedOrder) feistel(x uint64) uint64 {
+	mask := uint64(1)<<o.halfBits - 1
+	left, right := x>>o.halfBits, x&mask
+	var in, out [aes.BlockSize]byte
+	for round := byte(0); round < 4; round++ {
+		binary.BigEndian.PutUint64(in[:8], right)
+		in[8] = round
+		o.block.Encrypt(out[:], in[:])
+		left, right = right, left^(binary.BigEndian.Uint64(out[:8])&mask)
+	}
+	return left<<o.halfBits | right
+}
+
+// Funktion zum Erzeugen der Reihenfolge für die Plätze nach dem Salt
+func passwordOrder(c carrier, orderKey []byte) (bitOrder, int, error) {
+	n := c.slots() - saltSize*8
+	if n < 8 {
+		return nil, 0, fmt.Errorf("image too small")
+	}
+	o, err := newKeyedOrder(orderKey, n)
 	if err != nil {
-		return "", fmt.Errorf("unable to decode image: %v", err)
+		return nil, 0, err
 	}
+	return func(i int) int { return saltSize*8 + o.permute(i) }, n / 8, nil
+}
 
-	// In RGBA-Format umwandeln
-	bounds := srcImg.Bounds()
-	img := image.NewRGBA(bounds)
-	draw.Draw(img, bounds, srcImg, image.Point{}, draw.Src)
+// Art der Nutzdaten ab Version 2: eine Nachricht oder eine Datei mit Name,
+// Größe und Änderungszeit
+const (
+	kindMessage = 0
+	kindFile    = 1
 
-	// Binäre Nachricht extrahieren
-	var binaryMessage strings.Builder
-	delimiter := "11111111"
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			r8, g8, b8 := uint8(r>>8), uint8(g>>8), uint8(b>>8)
+	// fileInfoSize ist die Größe der festen Felder einer Datei: Länge des
+	// Namens (2), Größe (8) und Änderungszeit (8)
+	fileInfoSize = 2 + 8 + 8
+)
 
-			// LSBs extrahieren
-			binaryMessage.WriteByte(r8&1 + '0')
-			binaryMessage.WriteByte(g8&1 + '0')
-			binaryMessage.WriteByte(b8&1 + '0')
+// hiddenData ist eine Nachricht oder, wenn name gesetzt ist, eine Datei
+type hiddenData struct {
+	name    string
+	modTime time.Time
+	content []byte
+}
 
-			// Prüfen, ob das Delimiter erreicht ist
-			if strings.HasSuffix(binaryMessage.String(), delimiter) {
-				goto EndDecoding
-			}
+// Funktion zum Lesen einer Datei, die versteckt werden soll
+func readHiddenFile(filePath string) (hiddenData, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return hiddenData{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return hiddenData{}, fmt.Errorf("%s is not a regular file", filePath)
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return hiddenData{}, err
+	}
+	return hiddenData{name: info.Name(), modTime: info.ModTime(), content: content}, nil
+}
+
+// Funktion zum Serialisieren der Nutzdaten: Art, bei Dateien die Metadaten,
+// danach der Inhalt
+func (d hiddenData) marshal() []byte {
+	if d.name == "" {
+		return append([]byte{kindMessage}, d.content...)
+	}
+	data := make([]byte, 1+fileInfoSize, 1+fileInfoSize+len(d.name)+len(d.content))
+	data[0] = kindFile
+	binary.BigEndian.PutUint16(data[1:], uint16(len(d.name)))
+	binary.BigEndian.PutUint64(data[3:], uint64(len(d.content)))
+	binary.BigEndian.PutUint64(data[11:], uint64(d.modTime.Unix()))
+	data = append(data, d.name...)
+	return append(data, d.content...)
+}
+
+// Funktion zum Lesen der Nutzdaten. Version 1 enthielt nur Nachrichten.
+func unmarshalHiddenData(version byte, data []byte) (hiddenData, error) {
+	if version == 1 {
+		return hiddenData{content: data}, nil
+	}
+	if len(data) == 0 {
+		return hiddenData{}, fmt.Errorf("payload corrupted: empty")
+	}
+	switch data[0] {
+	case kindMessage:
+		return hiddenData{content: data[1:]}, nil
+	case kindFile:
+		if len(data) < 1+fileInfoSize {
+			return hiddenData{}, fmt.Errorf("payload corrupted: file header truncated")
 		}
+		nameLength := int(binary.BigEndian.Uint16(data[1:]))
+		size := binary.BigEndian.Uint64(data[3:])
+		modTime := time.Unix(int64(binary.BigEndian.Uint64(data[11:])), 0)
+		rest := data[1+fileInfoSize:]
+		if nameLength > len(rest) || size != uint64(len(rest)-nameLength) {
+			return hiddenData{}, fmt.Errorf("payload corrupted: file size does not match")
+		}
+		return hiddenData{name: string(rest[:nameLength]), modTime: modTime, content: rest[nameLength:]}, nil
+	default:
+		return hiddenData{}, fmt.Errorf("unsupported payload kind %d", data[0])
+	}
+}
+
+// Funktion zum Wiederherstellen einer Datei in dir. Vom Namen wird nur der
+// letzte Teil verwendet, damit keine Datei außerhalb von dir entsteht, und
+// vorhandene Dateien werden nicht überschrieben.
+func restoreFile(dir string, d hiddenData) (string, error) {
+	name := filepath.Base(filepath.FromSlash(strings.ReplaceAll(d.name, "\\", "/")))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid file name %q", d.name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	outputPath := filepath.Join(dir, name)
+	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(d.content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", err
+	}
+	// Die Änderungszeit ist nur Komfort, ein Fehler wird ignoriert
+	os.Chtimes(outputPath, d.modTime, d.modTime)
+	return outputPath, nil
+}
+
+// Funktion zum Zusammensetzen von Header und Nutzdaten
+func buildPayload(data []byte) []byte {
+	payload := make([]byte, headerSize, headerSize+len(data))
+	copy(payload, payloadMagic)
+	payload[len(payloadMagic)] = payloadVersion
+	binary.BigEndian.PutUint32(payload[len(payloadMagic)+1:], uint32(len(data)))
+	binary.BigEndian.PutUint32(payload[len(payloadMagic)+5:], crc32.ChecksumIEEE(data))
+	return append(payload, data...)
+}
+
+// Funktion zum Prüfen, dass die Ausgabe nicht das Eingabebild ist. Sonst
+// würde das Original überschrieben und bei einer fehlgeschlagenen Prüfung
+// gelöscht.
+func checkDistinct(inputPath, outputPath string) error {
+	outInfo, err := os.Stat(outputPath)
+	if err != nil {
+		// Eine fehlende Ausgabe kann nicht die Eingabe sein
+		return nil
+	}
+	inInfo, err := os.Stat(inputPath)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(inInfo, outInfo) {
+		return fmt.Errorf("%s is the input image, write the output to another file", outputPath)
+	}
+	return nil
+}
+
+// Funktion zum Einbetten einer Nachricht oder Datei in ein Bild,
+// verschlüsselt und verteilt, wenn password gesetzt ist. JPEG-Ausgaben
+// nehmen die Bits in den DCT-Koeffizienten auf, alle anderen in den Pixeln
+// nach layout.
+func encodeImage(imagePath string, hidden hiddenData, outputPath, password string, layout pixelLayout) error {
+	if err := checkDistinct(imagePath, outputPath); err != nil {
+		return err
+	}
+	img, format, err := loadImage(imagePath)
+	if err != nil {
+		return err
 	}
-EndDecoding:
+	fmt.Printf("Input image format: %s\n", format)
+	original := image.NewNRGBA(img.Bounds())
+	copy(original.Pix, img.Pix)
 
-	// Delimiter entfernen und Binärdaten in Text umwandeln
-	messageBits := strings.TrimSuffix(binaryMessage.String(), delimiter)
-	var message strings.Builder
-	for i := 0; i < len(messageBits); i += 8 {
-		if i+8 > len(messageBits) {
-			break
+	var c carrier = imageCarrier{img, layout}
+	save := func() error { return saveImage(outputPath, img) }
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	if ext == ".bmp" && slices.Contains(layout.channels, 3) {
+		return fmt.Errorf("-channels with 'a' needs PNG output, BMP files are written without the alpha channel")
+	}
+	if ext == ".jpg" || ext == ".jpeg" {
+		if !layout.isDefault() {
+			return fmt.Errorf("-bits and -channels only apply to PNG and BMP output, JPEG output uses the DCT coefficients")
 		}
-		byteStr := messageBits[i : i+8]
-		val, _ := binaryToDecimal(byteStr)
-		message.WriteByte(byte(val))
+		jc, err := jpegCarrierFor(imagePath, img)
+		if err != nil {
+			return err
+		}
+		c = jc
+		save = func() error {
+			data, err := jc.encode()
+			if err != nil {
+				return fmt.Errorf("unable to encode image: %v", err)
+			}
+			return os.WriteFile(outputPath, data, 0644)
+		}
+	}
+
+	// Header und Nutzdaten müssen ins Bild passen, geprüft wird vor jeder
+	// Änderung am Bild
+	payload := buildPayload(hidden.marshal())
+	required := len(payload)
+	if password != "" {
+		required += encryptionOverhead
+	}
+	if required > capacity(c) {
+		return fmt.Errorf("payload too large: %d bytes, the image holds at most %d", required, capacity(c))
+	}
+	fmt.Printf("Using %d of %d bytes\n", required, capacity(c))
+	if err := embedPayload(c, payload, password); err != nil {
+		return err
+	}
+
+	// Bild speichern
+	err = save()
+	if err != nil {
+		return fmt.Errorf("error saving image: %v", err)
+	}
+
+	// Das gespeicherte Bild muss die Nutzdaten unverändert enthalten
+	check, err := decodeImage(outputPath, password, layout)
+	if err != nil || check.name != hidden.name || !bytes.Equal(check.content, hidden.content) {
+		os.Remove(outputPath)
+		return fmt.Errorf("verification failed, the saved image does not contain the payload (%v), %s was removed", err, outputPath)
+	}
+
+	// Veränderung gegenüber dem Original, wie sie im gespeicherten Bild steht
+	saved, _, err := loadImage(outputPath)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Distortion: PSNR %s, luma SSIM %.5f\n", formatPSNR(psnr(original, saved)), ssim(original, saved))
 
-	return message.String(), nil
+	if hidden.name != "" {
+		fmt.Printf("File %s (%d bytes) encoded and image saved as %s\n", hidden.name, len(hidden.content), outputPath)
+	} else {
+		fmt.Println("Message encoded and image saved as", outputPath)
+	}
+	return nil
 }
 
-// Hilfsfunktionen
-func stringToBinary(str string) string {
-	var binaryString strings.Builder
-	for _, char := range str {
-		binaryString.WriteString(fmt.Sprintf("%08b", char))
+// Funktion zum Einbetten der Nutzdaten, mit password verschlüsselt hinter
+// einem Salt
+func embedPayload(c carrier, payload []byte, password string) error {
+	if password == "" {
+		embedBytes(c, sequentialOrder, 0, payload)
+		return nil
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	orderKey, aeadKey, err := deriveKeys(password, salt)
+	if err != nil {
+		return err
+	}
+	order, _, err := passwordOrder(c, orderKey)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealPayload(aeadKey, payload)
+	if err != nil {
+		return err
 	}
-	return binaryString.String()
+	embedBytes(c, sequentialOrder, 0, salt)
+	embedBytes(c, order, 0, sealed)
+	return nil
 }
 
-func binaryToDecimal(binStr string) (int, error) {
-	var decimalValue int
-	for i, bit := range binStr {
-		if bit == '1' {
-			decimalValue += 1 << (7 - i)
+// Funktion zum Verschlüsseln der Nutzdaten: Nonce, Länge des Chiffrats und
+// das Chiffrat, die Länge ist als zusätzliche Daten authentifiziert
+func sealPayload(aeadKey, payload []byte) ([]byte, error) {
+	aead, err := newAEAD(aeadKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, nonceSize+4, nonceSize+4+len(payload)+aead.Overhead())
+	if _, err := rand.Read(sealed[:nonceSize]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(sealed[nonceSize:], uint32(len(payload)+aead.Overhead()))
+	return aead.Seal(sealed, sealed[:nonceSize], payload, sealed[nonceSize:]), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Funktion zum Laden des Trägers: die Koeffizienten eines JPEGs, sonst die
+// Pixel nach layout
+func loadCarrier(imagePath string, layout pixelLayout) (carrier, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open image: %v", err)
+	}
+	if isJPEG(data) {
+		if !layout.isDefault() {
+			return nil, fmt.Errorf("-bits and -channels do not apply to JPEG images")
 		}
+		return parseJPEG(data)
 	}
-	return decimalValue, nil
+	img, _, err := loadImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	return imageCarrier{img, layout}, nil
+}
+
+// Funktion zum Dekodieren einer Nachricht oder Datei aus einem Bild
+func decodeImage(imagePath, password string, layout pixelLayout) (hiddenData, error) {
+	c, err := loadCarrier(imagePath, layout)
+	if err != nil {
+		return hiddenData{}, err
+	}
+	if password != "" {
+		return decodeEncrypted(c, password)
+	}
+	if capacity(c) < headerSize {
+		return hiddenData{}, errNoPayload
+	}
+
+	// Erst den Header lesen, um die Länge zu kennen
+	payload := extractBytes(c, sequentialOrder, 0, headerSize)
+	if string(payload[:len(payloadMagic)]) == payloadMagic {
+		length := int(binary.BigEndian.Uint32(payload[len(payloadMagic)+1:]))
+		if length <= capacity(c)-headerSize {
+			payload = extractBytes(c, sequentialOrder, 0, headerSize+length)
+		}
+	}
+	return parsePayload(payload)
+}
+
+// Funktion zum Entschlüsseln der mit -password eingebetteten Nutzdaten
+func decodeEncrypted(c carrier, password string) (hiddenData, error) {
+	if capacity(c) < encryptionOverhead+headerSize {
+		return hiddenData{}, errWrongPassword
+	}
+	salt := extractBytes(c, sequentialOrder, 0, saltSize)
+	orderKey, aeadKey, err := deriveKeys(password, salt)
+	if err != nil {
+		return hiddenData{}, err
+	}
+	order, available, err := passwordOrder(c, orderKey)
+	if err != nil {
+		return hiddenData{}, err
+	}
+
+	// Mit falschem Passwort ist die Länge zufällig
+	prefix := extractBytes(c, order, 0, nonceSize+4)
+	length := int(binary.BigEndian.Uint32(prefix[nonceSize:]))
+	if length > available-nonceSize-4 {
+		return hiddenData{}, errWrongPassword
+	}
+	aead, err := newAEAD(aeadKey)
+	if err != nil {
+		return hiddenData{}, err
+	}
+	ciphertext := extractBytes(c, order, nonceSize+4, length)
+	payload, err := aead.Open(nil, prefix[:nonceSize], ciphertext, prefix[nonceSize:])
+	if err != nil {
+		return hiddenData{}, errWrongPassword
+	}
+	return parsePayload(payload)
+}
+
+// Funktion zum Prüfen von Header und Prüfsumme der Nutzdaten
+func parsePayload(payload []byte) (hiddenData, error) {
+	if len(payload) < headerSize || string(payload[:len(payloadMagic)]) != payloadMagic {
+		return hiddenData{}, errNoPayload
+	}
+	version := payload[len(payloadMagic)]
+	if version < 1 || version > payloadVersion {
+		return hiddenData{}, fmt.Errorf("unsupported payload version %d", version)
+	}
+	length := int(binary.BigEndian.Uint32(payload[len(payloadMagic)+1:]))
+	checksum := binary.BigEndian.Uint32(payload[len(payloadMagic)+5:])
+	if length != len(payload)-headerSize {
+		return hiddenData{}, fmt.Errorf("payload corrupted: length %d exceeds the available data", length)
+	}
+
+	// Prüfsumme vergleichen
+	data := payload[headerSize:]
+	if crc32.ChecksumIEEE(data) != checksum {
+		return hiddenData{}, fmt.Errorf("payload corrupted: CRC mismatch")
+	}
+	return unmarshalHiddenData(version, data)
 }
 
 // Hauptfunktion
@@ -217,27 +715,113 @@ func main() {
 	action := os.Args[1]
 
 	if action == "encode" {
-		if len(os.Args) != 5 {
+		flags := flag.NewFlagSet("encode", flag.ExitOnError)
+		filePath := flags.String("file", "", "File to hide instead of a message")
+		password := flags.String("password", "", "Password to encrypt and scatter the payload with")
+		bits := flags.Int("bits", 1, "Bits per channel (1-4) for PNG/BMP output")
+		channels := flags.String("channels", "rgb", "Channels for PNG/BMP output, any letters of 'rgba'")
+		flags.Parse(os.Args[2:])
+		args := flags.Args()
+		layout, err := parseLayout(*bits, *channels)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		var hidden hiddenData
+		if *filePath != "" {
+			if len(args) != 2 {
+				fmt.Println("Error: Incorrect number of arguments for 'encode -file'.")
+				fmt.Println("Usage: go run . encode -file <file> [-password <password>] [-bits <1-4>] [-channels <rgba>] <input_image> <output_image>")
+				return
+			}
+			if hidden, err = readHiddenFile(*filePath); err != nil {
+				fmt.Println("Error reading file:", err)
+				return
+			}
+			args = []string{args[0], "", args[1]}
+		} else if len(args) != 3 {
 			fmt.Println("Error: Incorrect number of arguments for 'encode'.")
-			fmt.Println("Usage: go run main.go encode <input_image> <message> <output_image>")
+			fmt.Println("Usage: go run . encode [-password <password>] [-bits <1-4>] [-channels <rgba>] <input_image> <message> <output_image>")
 			return
+		} else {
+			hidden.content = []byte(args[1])
 		}
-		err := encodeImage(os.Args[2], os.Args[3], os.Args[4])
+		err = encodeImage(args[0], hidden, args[2], *password, layout)
 		if err != nil {
 			fmt.Println("Error encoding image:", err)
 		}
 	} else if action == "decode" {
-		if len(os.Args) != 3 {
+		flags := flag.NewFlagSet("decode", flag.ExitOnError)
+		outDir := flags.String("out", ".", "Directory to restore a hidden file into")
+		password := flags.String("password", "", "Password the payload was encoded with")
+		bits := flags.Int("bits", 1, "Bits per channel the payload was encoded with")
+		channels := flags.String("channels", "rgb", "Channels the payload was encoded with")
+		flags.Parse(os.Args[2:])
+		args := flags.Args()
+		layout, err := parseLayout(*bits, *channels)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if len(args) != 1 {
 			fmt.Println("Error: Incorrect number of arguments for 'decode'.")
-			fmt.Println("Usage: go run main.go decode <input_image>")
+			fmt.Println("Usage: go run . decode [-out <dir>] [-password <password>] [-bits <1-4>] [-channels <rgba>] <input_image>")
 			return
 		}
-		message, err := decodeImage(os.Args[2])
+		hidden, err := decodeImage(args[0], *password, layout)
 		if err != nil {
 			fmt.Println("Error decoding image:", err)
+		} else if hidden.name != "" {
+			outputPath, err := restoreFile(*outDir, hidden)
+			if err != nil {
+				fmt.Println("Error restoring file:", err)
+			} else {
+				fmt.Printf("File restored as %s (%d bytes, modified %s)\n", outputPath, len(hidden.content), hidden.modTime.Format(time.DateTime))
+			}
 		} else {
-			fmt.Println("Decoded message:", message)
+			fmt.Println("Decoded message:", string(hidden.content))
+		}
+	} else if action == "capacity" {
+		flags := flag.NewFlagSet("capacity", flag.ExitOnError)
+		channels := flags.String("channels", "rgb", "Channels for PNG/BMP output, any letters of 'rgba'")
+		flags.Parse(os.Args[2:])
+		args := flags.Args()
+		if len(args) != 1 {
+			fmt.Println("Error: Incorrect number of arguments for 'capacity'.")
+			fmt.Println("Usage: go run . capacity [-channels <rgba>] <input_image>")
+			return
+		}
+		img, _, err := loadImage(args[0])
+		if err != nil {
+			fmt.Println("Error loading image:", err)
+			return
+		}
+		jc, err := jpegCarrierFor(args[0], img)
+		if err != nil {
+			fmt.Println("Error loading image:", err)
+			return
+		}
+		type output struct {
+			name string
+			carrier
+		}
+		outputs := []output{{"JPEG", jc}}
+		for bits := 1; bits <= 4; bits++ {
+			layout, err := parseLayout(bits, *channels)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			name := fmt.Sprintf("PNG/BMP with -bits %d -channels %s", bits, *channels)
+			outputs = append(outputs, output{name, imageCarrier{img, layout}})
+		}
+		// Eine Datei braucht zusätzlich die Metadaten und ihren Namen
+		for _, o := range outputs {
+			fmt.Printf("As %s the image holds %d bytes: a message of up to %d bytes, a file of up to %d bytes minus the length of its name\n",
+				o.name, capacity(o), max(capacity(o)-headerSize-1, 0), max(capacity(o)-headerSize-1-fileInfoSize, 0))
 		}
+		fmt.Printf("With -password %d bytes less\n", encryptionOverhead)
 	} else {
 		fmt.Println("Error: Unknown action:", action)
 		fmt.Println("Use '--help' to see available actions.")
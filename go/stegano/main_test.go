@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Funktion zum Schreiben eines Testbildes mit zufälligen Pixeln als PNG
+func writeTestImage(t testing.TB, w, h int) string {
+	t.Helper()
+	rng := rand.New(rand.NewSource(int64(w*h + 1)))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rng.Intn(256))
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	path := filepath.Join(t.TempDir(), "input.png")
+	writePNG(t, path, img)
+	return path
+}
+
+// Funktion zum Speichern eines Bildes als PNG
+func writePNG(t testing.TB, path string, img image.Image) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	input := writeTestImage(t, 64, 64)
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"text", "Hidden message"},
+		{"empty", ""},
+		{"0xFF byte", "before\xffafter"},
+		{"ÿ", "ÿÿÿ"},
+		{"binary", "\x00\x01\xfe\xff\x00"},
+		{"long", strings.Repeat("0123456789", 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output.png")
+			if err := encodeImage(input, hiddenData{content: []byte(tt.message)}, output, "", defaultLayout); err != nil {
+				t.Fatal(err)
+			}
+			hidden, err := decodeImage(output, "", defaultLayout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(hidden.content) != tt.message || hidden.name != "" {
+				t.Errorf("decoded %q, want %q", hidden.content, tt.message)
+			}
+		})
+	}
+}
+
+func TestDecodeWithoutPayload(t *testing.T) {
+	if _, err := decodeImage(writeTestImage(t, 64, 64), "", defaultLayout); !errors.Is(err, errNoPayload) {
+		t.Errorf("got %v, want errNoPayload", err)
+	}
+	// Zu klein selbst für den Header
+	if _, err := decodeImage(writeTestImage(t, 2, 2), "", defaultLayout); !errors.Is(err, errNoPayload) {
+		t.Errorf("tiny image: got %v, want errNoPayload", err)
+	}
+}
+
+func TestDecodeCorrupted(t *testing.T) {
+	payload := buildPayload(hiddenData{content: []byte("Hidden message")}.marshal())
+	tests := []struct {
+		name   string
+		modify func(p []byte) []byte
+		error  string
+	}{
+		{"flipped bit", func(p []byte) []byte { p[len(p)-1] ^= 1; return p }, "CRC mismatch"},
+		{"truncated", func(p []byte) []byte { return p[:len(p)-1] }, "exceeds the available data"},
+		{"newer version", func(p []byte) []byte { p[len(payloadMagic)] = payloadVersion + 1; return p }, "unsupported payload version"},
+		{"no magic", func(p []byte) []byte { p[0] = 'X'; return p }, errNoPayload.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.modify(append([]byte(nil), payload...))
+			if _, err := parsePayload(p); err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("got %v, want %q", err, tt.error)
+			}
+		})
+	}
+
+	// Ein gekipptes Bit im gespeicherten Bild wird erkannt
+	input := writeTestImage(t, 64, 64)
+	output := filepath.Join(t.TempDir(), "output.png")
+	if err := encodeImage(input, hiddenData{content: []byte("Hidden message")}, output, "", defaultLayout); err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := loadImage(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := imageCarrier{img, defaultLayout}
+	last := (headerSize+1+len("Hidden message"))*8 - 1
+	c.setBit(last, c.bit(last)^1)
+	writePNG(t, output, img)
+	if _, err := decodeImage(output, "", defaultLayout); err == nil || !strings.Contains(err.Error(), "CRC mismatch") {
+		t.Errorf("got %v, want a CRC mismatch", err)
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	input := writeTestImage(t, 64, 64)
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "secret ÿ.bin")
+	content := []byte("file content\xff\x00\xfe")
+	if err := os.WriteFile(filePath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	hidden, err := readHiddenFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output.png")
+	if err := encodeImage(input, hidden, output, "", defaultLayout); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeImage(output, "", defaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.name != "secret ÿ.bin" || !decoded.modTime.Equal(modTime) || !bytes.Equal(decoded.content, content) {
+		t.Fatalf("decoded %q modified %v: %q", decoded.name, decoded.modTime, decoded.content)
+	}
+
+	restoreDir := filepath.Join(dir, "restored")
+	restored, err := restoreFile(restoreDir, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(restored); !bytes.Equal(data, content) || !info.ModTime().Equal(modTime) {
+		t.Errorf("restored %q modified %v", data, info.ModTime())
+	}
+
+	// Eine vorhandene Datei wird nicht überschrieben
+	if _, err := restoreFile(restoreDir, hiddenData{name: decoded.name, content: []byte("other")}); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("second restore: got %v, want ErrExist", err)
+	}
+	if data, _ := os.ReadFile(restored); !bytes.Equal(data, content) {
+		t.Errorf("the existing file was changed to %q", data)
+	}
+}
+
+func TestRestoreFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"../../escape.txt", "escape.txt"},
+		{"/etc/passwd", "passwd"},
+		{`..\..\windows.txt`, "windows.txt"},
+		{"dir/sub/file.txt", "file.txt"},
+		{"..", ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		restored, err := restoreFile(dir, hiddenData{name: tt.name, content: []byte("x")})
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: restored as %s", tt.name, restored)
+			}
+			continue
+		}
+		if err != nil || restored != filepath.Join(dir, tt.want) {
+			t.Errorf("%q: restored as %q, %v, want %q", tt.name, restored, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalHiddenData(t *testing.T) {
+	file := hiddenData{name: "a.txt", modTime: time.Unix(1600000000, 0), content: []byte("content")}.marshal()
+	tests := []struct {
+		name  string
+		data  []byte
+		error string
+	}{
+		{"empty", nil, "empty"},
+		{"unknown kind", []byte{7}, "unsupported payload kind"},
+		{"header truncated", file[:1+fileInfoSize-1], "file header truncated"},
+		{"content truncated", file[:len(file)-1], "file size does not match"},
+		{"content appended", append(append([]byte(nil), file...), 'x'), "file size does not match"},
+	}
+	for _, tt := range tests {
+		if _, err := unmarshalHiddenData(payloadVersion, tt.data); err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.error)
+		}
+	}
+	// Version 1 kannte nur Nachrichten
+	if d, err := unmarshalHiddenData(1, []byte{kindFile, 'x'}); err != nil || d.name != "" || string(d.content) != "\x01x" {
+		t.Errorf("version 1: %+v, %v", d, err)
+	}
+}
+
+func TestPasswordRoundTrip(t *testing.T) {
+	input := writeTestImage(t, 64, 64)
+	output := filepath.Join(t.TempDir(), "output.png")
+	message := "Hidden message\xff"
+	if err := encodeImage(input, hiddenData{content: []byte(message)}, output, "secret", defaultLayout); err != nil {
+		t.Fatal(err)
+	}
+
+	hidden, err := decodeImage(output, "secret", defaultLayout)
+	if err != nil || string(hidden.content) != message {
+		t.Fatalf("decoded %q, %v", hidden.content, err)
+	}
+	if _, err := decodeImage(output, "wrong", defaultLayout); !errors.Is(err, errWrongPassword) {
+		t.Errorf("wrong password: got %v, want errWrongPassword", err)
+	}
+	// Ohne Passwort sind weder Header noch Nachricht zu sehen
+	if _, err := decodeImage(output, "", defaultLayout); !errors.Is(err, errNoPayload) {
+		t.Errorf("without password: got %v, want errNoPayload", err)
+	}
+	// Ein Bild ohne Nutzdaten sieht aus wie ein falsches Passwort
+	if _, err := decodeImage(input, "secret", defaultLayout); !errors.Is(err, errWrongPassword) {
+		t.Errorf("image without payload: got %v, want errWrongPassword", err)
+	}
+}
+
+func TestKeyedOrder(t *testing.T) {
+	// Die Reihenfolge ist eine Permutation, auch wenn n keine Zweierpotenz ist
+	for _, n := range []int{8, 100, 1000, 4096} {
+		o, err := newKeyedOrder(make([]byte, 32), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make([]bool, n)
+		for i := 0; i < n; i++ {
+			j := o.permute(i)
+			if j < 0 || j >= n || seen[j] {
+				t.Fatalf("n=%d: permute(%d) = %d twice or out of range", n, i, j)
+			}
+			seen[j] = true
+		}
+	}
+}
+
+// Funktion zum Schreiben eines Testbildes als JPEG, farbig oder in Graustufen
+func writeTestJPEG(t testing.TB, gray bool) string {
+	t.Helper()
+	src, _, err := loadImage(writeTestImage(t, 64, 48))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img image.Image = src
+	if gray {
+		g := image.NewGray(src.Bounds())
+		draw.Draw(g, g.Bounds(), src, image.Point{}, draw.Src)
+		img = g
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "input.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJPEGRoundTrip(t *testing.T) {
+	tests := []struct {
+		name, input, output, password string
+	}{
+		{"PNG to JPG", writeTestImage(t, 64, 48), "output.jpg", ""},
+		{"JPG to JPG", writeTestJPEG(t, false), "output.jpeg", ""},
+		{"grayscale JPG to JPG", writeTestJPEG(t, true), "output.jpg", ""},
+		{"JPG to PNG", writeTestJPEG(t, false), "output.png", ""},
+		{"JPG to JPG with password", writeTestJPEG(t, false), "output.jpg", "secret"},
+	}
+	message := "Hidden message\xff"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), tt.output)
+			if err := encodeImage(tt.input, hiddenData{content: []byte(message)}, output, tt.password, defaultLayout); err != nil {
+				t.Fatal(err)
+			}
+			hidden, err := decodeImage(output, tt.password, defaultLayout)
+			if err != nil || string(hidden.content) != message {
+				t.Fatalf("decoded %q, %v", hidden.content, err)
+			}
+
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isJPEG(data) != (filepath.Ext(tt.output) != ".png") {
+				t.Errorf("%s written in the wrong format", tt.output)
+			}
+			// Ein JPEG wird mit seinen eigenen Tabellen neu kodiert
+			if input, _ := os.ReadFile(tt.input); isJPEG(data) && isJPEG(input) {
+				c, err := parseJPEG(input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.HasPrefix(data, c.header) {
+					t.Error("the segments before the image data were changed")
+				}
+			}
+		})
+	}
+}
+
+func TestParseJPEGMalformed(t *testing.T) {
+	valid, err := os.ReadFile(writeTestJPEG(t, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseJPEG(valid); err != nil {
+		t.Fatal(err)
+	}
+	sof := bytes.Index(valid, []byte{0xFF, 0xC0})
+	if sof < 0 {
+		t.Fatal("no SOF segment")
+	}
+	patched := func(offset int, b ...byte) []byte {
+		data := append([]byte(nil), valid...)
+		copy(data[offset:], b)
+		return data
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		error string
+	}{
+		{"empty", nil, "missing SOI"},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n"), "missing SOI"},
+		{"only SOI", valid[:2], "truncated or invalid marker"},
+		{"oversized", patched(sof+5, 0xFF, 0xFF, 0xFF, 0xFF), "pixels"},
+		{"zero width", patched(sof+7, 0, 0), "invalid SOF"},
+		{"progressive", patched(sof+1, 0xC2), "progressive"},
+		{"12 bit", patched(sof+4, 12), "8 bit precision"},
+		{"segment too long", patched(4, 0xFF, 0xFF), "truncated segment"},
+		{"missing EOI", valid[:len(valid)-2], ""},
+		{"data after EOI", append(append([]byte(nil), valid[:len(valid)-2]...), 0x12, 0xFF, 0xD9), "data after the scan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJPEG(tt.data)
+			if !errors.Is(err, errUnsupportedJPEG) || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("got %v, want errUnsupportedJPEG with %q", err, tt.error)
+			}
+		})
+	}
+
+	// decode meldet kaputte JPEGs
+	path := filepath.Join(t.TempDir(), "truncated.jpg")
+	if err := os.WriteFile(path, valid[:len(valid)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeImage(path, "", defaultLayout); !errors.Is(err, errUnsupportedJPEG) {
+		t.Errorf("decode of a truncated JPEG: got %v", err)
+	}
+}
+
+// Beliebige Daten werden ohne Panik gelesen oder mit errUnsupportedJPEG
+// abgelehnt, jedes abgeschnittene JPEG wird abgelehnt
+func FuzzParseJPEG(f *testing.F) {
+	valid, err := os.ReadFile(writeTestJPEG(f, false))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for n := 0; n <= len(valid); n++ {
+		f.Add(valid[:n])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, err := parseJPEG(data)
+		if err != nil && !errors.Is(err, errUnsupportedJPEG) {
+			t.Fatalf("got %v, want errUnsupportedJPEG", err)
+		}
+		if err == nil && len(data) < len(valid) && bytes.Equal(data, valid[:len(data)]) {
+			t.Fatalf("truncated to %d of %d bytes: accepted", len(data), len(valid))
+		}
+	})
+}
+
+func TestLayoutRoundTrip(t *testing.T) {
+	input := writeTestImage(t, 32, 32)
+	for bits := 1; bits <= 4; bits++ {
+		for _, channels := range []string{"r", "g", "b", "a", "rgb", "rgba", "ba", "AGR"} {
+			layout, err := parseLayout(bits, channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Das Alpha überlebt nur PNG
+			outputs := []string{"output.png"}
+			if !slices.Contains(layout.channels, 3) {
+				outputs = append(outputs, "output.bmp")
+			}
+			for _, name := range outputs {
+				// Die Nachricht füllt den Träger bis auf wenige Bytes
+				message := bytes.Repeat([]byte{0xFF, 'x'}, (capacity(imageCarrier{image.NewNRGBA(image.Rect(0, 0, 32, 32)), layout})-headerSize-1)/2)
+				output := filepath.Join(t.TempDir(), name)
+				if err := encodeImage(input, hiddenData{content: message}, output, "", layout); err != nil {
+					t.Fatalf("-bits %d -channels %s %s: %v", bits, channels, name, err)
+				}
+				hidden, err := decodeImage(output, "", layout)
+				if err != nil || !bytes.Equal(hidden.content, message) {
+					t.Fatalf("-bits %d -channels %s %s: decoded %d bytes, %v", bits, channels, name, len(hidden.content), err)
+				}
+			}
+		}
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		bits     int
+		channels string
+		want     []int
+	}{
+		{1, "rgb", []int{0, 1, 2}},
+		{4, "bgr", []int{0, 1, 2}},
+		{2, "A", []int{3}},
+		{3, "gg", []int{1}},
+		{0, "rgb", nil},
+		{5, "rgb", nil},
+		{1, "", nil},
+		{1, "rgbx", nil},
+	}
+	for _, tt := range tests {
+		layout, err := parseLayout(tt.bits, tt.channels)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseLayout(%d, %q) accepted", tt.bits, tt.channels)
+			}
+			continue
+		}
+		if err != nil || layout.bits != tt.bits || !slices.Equal(layout.channels, tt.want) {
+			t.Errorf("parseLayout(%d, %q) = %+v, %v", tt.bits, tt.channels, layout, err)
+		}
+	}
+}
+
+func TestEncodeRefused(t *testing.T) {
+	input := writeTestImage(t, 16, 16)
+	rgba, err := parseLayout(2, "rgba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, output string
+		message      []byte
+		password     string
+		layout       pixelLayout
+		error        string
+	}{
+		{"too large", "output.png", make([]byte, 16*16*3/8), "", defaultLayout, "payload too large"},
+		{"too large with password", "output.png", make([]byte, 16*16*3/8-headerSize-1-encryptionOverhead+1), "secret", defaultLayout, "payload too large"},
+		{"alpha in BMP", "output.bmp", []byte("message"), "", rgba, "needs PNG output"},
+		{"layout for JPEG", "output.jpg", []byte("message"), "", rgba, "only apply to PNG and BMP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), tt.output)
+			err := encodeImage(input, hiddenData{content: tt.message}, output, tt.password, tt.layout)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("got %v, want %q", err, tt.error)
+			}
+			if _, err := os.Stat(output); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s was written", tt.output)
+			}
+		})
+	}
+
+	// Die Ausgabe darf das Eingabebild nicht ersetzen, auch nicht über
+	// einen anderen Pfad
+	before, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, output := range []string{input, filepath.Join(filepath.Dir(input), ".", filepath.Base(input))} {
+		err := encodeImage(input, hiddenData{content: []byte("message")}, output, "", defaultLayout)
+		if err == nil || !strings.Contains(err.Error(), "is the input image") {
+			t.Errorf("output %s: got %v", output, err)
+		}
+	}
+	if after, err := os.ReadFile(input); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("the input image was changed: %v", err)
+	}
+
+	// Genau passende Nutzdaten werden angenommen
+	fits := make([]byte, 16*16*3/8-headerSize-1)
+	if err := encodeImage(input, hiddenData{content: fits}, filepath.Join(t.TempDir(), "output.png"), "", defaultLayout); err != nil {
+		t.Errorf("payload of exactly the capacity: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Maße für die Veränderung durch das Einbetten, berechnet zwischen dem
+// Original und dem gespeicherten Bild
+
+// Funktion zur Berechnung des PSNR in dB über R, G, B und A. Identische
+// Bilder ergeben +Inf.
+func psnr(a, b *image.NRGBA) float64 {
+	var sum float64
+	for i := range a.Pix {
+		d := float64(a.Pix[i]) - float64(b.Pix[i])
+		sum += d * d
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	mse := sum / float64(len(a.Pix))
+	return 10 * math.Log10(255*255/mse)
+}
+
+func formatPSNR(v float64) string {
+	if math.IsInf(v, 1) {
+		return "inf (identical)"
+	}
+	return fmt.Sprintf("%.2f dB", v)
+}
+
+// ssimWindow ist die Kantenlänge der Fenster für die SSIM, die sich um die
+// Hälfte überlappen. Das ist gröber als das Gauß-Fenster von Wang et al.,
+// reicht aber zum Vergleichen von Einstellungen.
+const ssimWindow = 8
+
+// Funktion zur Berechnung der mittleren SSIM der Helligkeit. Änderungen am
+// Alpha-Kanal sieht sie nicht, die zeigt nur der PSNR.
+func ssim(a, b *image.NRGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	la, lb := luma(a), luma(b)
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	size := min(ssimWindow, w, h)
+	if size == 0 {
+		return 1
+	}
+
+	var total float64
+	windows := 0
+	for y := 0; y+size <= h; y += max(size/2, 1) {
+		for x := 0; x+size <= w; x += max(size/2, 1) {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for dy := 0; dy < size; dy++ {
+				for dx := 0; dx < size; dx++ {
+					va, vb := la[(y+dy)*w+x+dx], lb[(y+dy)*w+x+dx]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			n := float64(size * size)
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += (2*meanA*meanB + c1) * (2*cov + c2) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// Funktion zur Berechnung der Helligkeit (BT.601) jedes Pixels
+func luma(img *image.NRGBA) []float64 {
+	bounds := img.Bounds()
+	l := make([]float64, 0, bounds.Dx()*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			p := row[x*4:]
+			l = append(l, 0.299*float64(p[0])+0.587*float64(p[1])+0.114*float64(p[2]))
+		}
+	}
+	return l
+}